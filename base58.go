@@ -24,7 +24,7 @@ func Base58Encode(input []byte) []byte {
 
 	ReverseBytes(result)
 
-	for b := range input {
+	for _, b := range input {
 		if b == 0x00 {
 			result = append([]byte{b58Alphabet[0]}, result...)
 		} else {
@@ -40,9 +40,11 @@ func Base58Decode(data []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range data {
-		if b == 0x00 {
+	for _, b := range data {
+		if b == b58Alphabet[0] {
 			zeroBytes++
+		} else {
+			break
 		}
 	}
 
@@ -3,33 +3,96 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
+	"errors"
+	"io"
 	"log"
-	"strconv"
 	"time"
 )
 
-// Block - the block
-type Block struct {
+const (
+	blockVersion = 1
+	hashLen      = 32
+	// blockHeaderLen - version, prev hash, merkle root, timestamp, bits, nonce
+	blockHeaderLen = 4 + hashLen + hashLen + 8 + 4 + 8
+)
+
+// BlockHeader - the part of a block that is hashed and mined
+type BlockHeader struct {
+	Version       int32
+	PrevBlockHash []byte
+	MerkleRoot    []byte
 	Timestamp     int64
+	Bits          uint32
 	Nonce         int
-	Transactions  []*Transaction
-	PrevBlockHash []byte
-	Hash          []byte
-	Height        int
+}
+
+// Serialize - the fixed-size canonical encoding of the header, used for
+// hashing, storage and the wire. Integers are big-endian and the empty
+// previous hash of the genesis block is written as zeros
+func (h *BlockHeader) Serialize() []byte {
+	var buff bytes.Buffer
+
+	buff.Grow(blockHeaderLen)
+	writeUint32(&buff, uint32(h.Version))
+	buff.Write(fixedHash(h.PrevBlockHash))
+	buff.Write(fixedHash(h.MerkleRoot))
+	writeUint64(&buff, uint64(h.Timestamp))
+	writeUint32(&buff, h.Bits)
+	writeUint64(&buff, uint64(h.Nonce))
+
+	return buff.Bytes()
+}
+
+// DeserializeBlockHeader - returns a header previously serialized
+func DeserializeBlockHeader(d []byte) (*BlockHeader, error) {
+	if len(d) != blockHeaderLen {
+		return nil, errors.New("block header has wrong length")
+	}
+
+	h := BlockHeader{
+		Version:       int32(binary.BigEndian.Uint32(d[0:4])),
+		PrevBlockHash: append([]byte{}, d[4:4+hashLen]...),
+		MerkleRoot:    append([]byte{}, d[4+hashLen:4+2*hashLen]...),
+	}
+	d = d[4+2*hashLen:]
+	h.Timestamp = int64(binary.BigEndian.Uint64(d[0:8]))
+	h.Bits = binary.BigEndian.Uint32(d[8:12])
+	h.Nonce = int(binary.BigEndian.Uint64(d[12:20]))
+
+	if bytes.Equal(h.PrevBlockHash, make([]byte, hashLen)) {
+		h.PrevBlockHash = []byte{}
+	}
+
+	return &h, nil
+}
+
+// Hash - the hash of the serialized header
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
+
+// Block - the block
+type Block struct {
+	BlockHeader
+	Transactions []*Transaction
+	Hash         []byte
+	Height       int
 	//Data          []byte
 }
 
-// Serialize - used to store in BoltDB
+// Serialize - used to store in BoltDB and send over the wire: the header,
+// the height, then each transaction prefixed with its length
 func (b *Block) Serialize() []byte {
 	var result bytes.Buffer
 
-	encoder := gob.NewEncoder(&result)
-
-	err := encoder.Encode(b)
+	result.Write(b.BlockHeader.Serialize())
+	writeUint64(&result, uint64(b.Height))
+	writeUint32(&result, uint32(len(b.Transactions)))
 
-	if err != nil {
-		log.Panic(err)
+	for _, tx := range b.Transactions {
+		writeBytes(&result, tx.Serialize())
 	}
 
 	return result.Bytes()
@@ -37,21 +100,50 @@ func (b *Block) Serialize() []byte {
 
 // DeserializeBlock - returns a block previously serialized
 func DeserializeBlock(d []byte) *Block {
-	var block Block
+	block, err := deserializeBlock(d)
+	if err != nil {
+		log.Printf("passed value is not a block: %v\n", err)
+		return nil
+	}
 
-	decoder := gob.NewDecoder(bytes.NewReader(d))
+	return block
+}
 
-	err := decoder.Decode(&block)
+func deserializeBlock(d []byte) (*Block, error) {
+	if len(d) < blockHeaderLen+12 {
+		return nil, io.ErrUnexpectedEOF
+	}
 
+	header, err := DeserializeBlockHeader(d[:blockHeaderLen])
 	if err != nil {
-		log.Printf("passed value is not a block: %v\n", err)
-		return nil
+		return nil, err
 	}
 
-	return &block
+	block := Block{BlockHeader: *header}
+	block.Hash = header.Hash()
+
+	br := newByteReader(d[blockHeaderLen:])
+	block.Height = int(br.uint64())
+
+	txCount := br.uint32()
+	for i := uint32(0); i < txCount && br.err == nil; i++ {
+		tx, err := DeserializeTransaction(br.bytes())
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, &tx)
+	}
+
+	err = br.done()
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
 }
 
-// HashTransactions - hash the included transactions
+// HashTransactions - hash the included transactions. A block without any
+// has no merkle root
 func (b *Block) HashTransactions() []byte {
 	//var txHashes [][]byte
 	//var txHash [32]byte
@@ -62,30 +154,34 @@ func (b *Block) HashTransactions() []byte {
 		transactions = append(transactions, tx.Serialize())
 	}
 
-	mTree := NewMerkleTree(transactions)
+	mTree, err := NewMerkleTree(transactions)
+	if err != nil {
+		return nil
+	}
 
 	//txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
 
 	return mTree.RootNode.Data
 }
 
-// SetHash - set the block hash
+// SetHash - set the block hash from the header
 func (b *Block) SetHash() {
-	timestamp := []byte(strconv.FormatInt(b.Timestamp, 10))
-	headers := bytes.Join([][]byte{b.PrevBlockHash, b.HashTransactions(), timestamp}, []byte{})
-	hash := sha256.Sum256(headers)
-	b.Hash = hash[:]
+	b.Hash = b.BlockHeader.Hash()
 }
 
 // NewBlock - return a new block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block {
 	block := &Block{
-		Timestamp:     time.Now().Unix(),
-		Transactions:  transactions,
-		PrevBlockHash: prevBlockHash,
-		Height:        height,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+			Bits:          bits,
+		},
+		Transactions: transactions,
+		Height:       height,
 	}
-	//block.SetHash()
+	block.MerkleRoot = block.HashTransactions()
 
 	pow := NewProofOfWork(block)
 	nonce, hash := pow.Run()
@@ -98,5 +194,13 @@ func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Bl
 
 // NewGenesisBlock - get the one that starts it all
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, initialBits)
+}
+
+// fixedHash - pads or cuts a hash to exactly hashLen bytes
+func fixedHash(hash []byte) []byte {
+	fixed := make([]byte, hashLen)
+	copy(fixed, hash)
+
+	return fixed
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestBlockHeaderRoundTrip(t *testing.T) {
+	header := BlockHeader{
+		Version:       blockVersion,
+		PrevBlockHash: bytes.Repeat([]byte{0xaa}, hashLen),
+		MerkleRoot:    bytes.Repeat([]byte{0xbb}, hashLen),
+		Timestamp:     0x0102030405060708,
+		Bits:          0x1d00ffff,
+		Nonce:         0x1112131415161718,
+	}
+
+	data := header.Serialize()
+	if len(data) != blockHeaderLen {
+		t.Fatalf("header of %d bytes, want %d", len(data), blockHeaderLen)
+	}
+
+	// the fields in order, big-endian
+	var want bytes.Buffer
+	writeUint32(&want, uint32(blockVersion))
+	want.Write(header.PrevBlockHash)
+	want.Write(header.MerkleRoot)
+	want.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	want.Write([]byte{0x1d, 0x00, 0xff, 0xff})
+	want.Write([]byte{0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18})
+	if !bytes.Equal(data, want.Bytes()) {
+		t.Fatalf("header encodes as %x, want %x", data, want.Bytes())
+	}
+
+	decoded, err := DeserializeBlockHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decoded, header) {
+		t.Fatalf("header decodes as %+v, want %+v", *decoded, header)
+	}
+
+	hash := sha256.Sum256(data)
+	if !bytes.Equal(header.Hash(), hash[:]) {
+		t.Fatal("header hash is not the hash of its encoding")
+	}
+
+	for _, n := range []int{0, blockHeaderLen - 1, blockHeaderLen + 1} {
+		if _, err := DeserializeBlockHeader(make([]byte, n)); err == nil {
+			t.Errorf("header of %d bytes decoded", n)
+		}
+	}
+}
+
+func TestGenesisHeaderRoundTrip(t *testing.T) {
+	header := BlockHeader{
+		Version:       blockVersion,
+		PrevBlockHash: []byte{},
+		MerkleRoot:    bytes.Repeat([]byte{0xbb}, hashLen),
+		Bits:          initialBits,
+	}
+
+	data := header.Serialize()
+	if !bytes.Equal(data[4:4+hashLen], make([]byte, hashLen)) {
+		t.Fatalf("empty previous hash encodes as %x", data[4:4+hashLen])
+	}
+
+	decoded, err := DeserializeBlockHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decoded, header) {
+		t.Fatalf("header decodes as %+v, want %+v", *decoded, header)
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	block := NewBlock([]*Transaction{NewCoinbaseTX(address, "", 7, 0)}, bytes.Repeat([]byte{0xaa}, hashLen), 7, initialBits)
+
+	data := block.Serialize()
+	if !bytes.Equal(data[:blockHeaderLen], block.BlockHeader.Serialize()) {
+		t.Fatal("block does not start with its header")
+	}
+
+	decoded, err := deserializeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Hash, block.Hash) || decoded.Height != block.Height {
+		t.Fatalf("block decodes as %x at height %d, want %x at %d", decoded.Hash, decoded.Height, block.Hash, block.Height)
+	}
+	if len(decoded.Transactions) != 1 || !bytes.Equal(decoded.Transactions[0].ID, block.Transactions[0].ID) {
+		t.Fatal("transactions of the block changed")
+	}
+	if !bytes.Equal(decoded.Serialize(), data) {
+		t.Fatal("block encodes differently once decoded")
+	}
+
+	if _, err := deserializeBlock(append(data, 0)); err == nil {
+		t.Fatal("block with trailing bytes decoded")
+	}
+	if _, err := deserializeBlock(data[:len(data)-1]); err == nil {
+		t.Fatal("truncated block decoded")
+	}
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"sort"
 
 	"github.com/boltdb/bolt"
 )
@@ -15,9 +18,37 @@ import (
 const (
 	dbFile              = "blockchain_%s.db"
 	blocksBucket        = "blocks"
+	chainworkBucket     = "chainwork"
+	headersBucket       = "headers"
 	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
 )
 
+// status of a block in the headers bucket. Records from before it was kept
+// have none, and count as valid
+const (
+	// blockStatusValid - the block connected to the UTXO set at least once
+	blockStatusValid byte = iota
+	// blockStatusUnvalidated - the block is on a side branch with less work
+	// than the tip, so only the checks before the UTXO set were run on it
+	blockStatusUnvalidated
+	// blockStatusInvalid - the block failed to connect. Its body is dropped
+	// and no block building on it is accepted
+	blockStatusInvalid
+)
+
+var errOrphanBlock = errors.New("parent block not found")
+
+// invalidBlockError - a block that broke a consensus rule while connecting,
+// so AddBlock can record it as invalid once the failed update rolled back
+type invalidBlockError struct {
+	hash []byte
+	err  error
+}
+
+func (e invalidBlockError) Error() string {
+	return e.err.Error()
+}
+
 // Blockchain - the star of the show
 type Blockchain struct {
 	tip []byte
@@ -60,40 +91,261 @@ func (bci *BlockchainIterator) Next() *Block {
 	return block
 }
 
-// AddBlock - add a block to the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
+// AddBlock - validate and add a block to the blockchain. Blocks on side
+// branches are kept, and the chain reorganizes onto whichever branch has the
+// most cumulative work
+func (bc *Blockchain) AddBlock(block *Block) error {
+	var newTip []byte
+	var disconnected, connected []*Block
 
-	err := bc.db.Update(func(tx *bolt.Tx) error {
+	if bc.HasBlock(block.Hash) {
+		return nil
+	}
+
+	if bc.branchStatus(block.Hash) == blockStatusInvalid {
+		return ruleError(ErrKnownInvalid, fmt.Sprintf("block %x is known to be invalid", block.Hash))
+	}
+	if bc.branchStatus(block.PrevBlockHash) == blockStatusInvalid {
+		bc.markInvalid(block, block.Hash)
+		str := fmt.Sprintf("block %x builds on a block known to be invalid", block.Hash)
+		return ruleError(ErrKnownInvalid, str)
+	}
+
+	err := bc.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+
+	err = bc.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
+		w := tx.Bucket([]byte(chainworkBucket))
 
-		if blockInDb == nil {
+		blockInDb := b.Get(block.Hash)
+		if blockInDb != nil {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		parentWork := w.Get(block.PrevBlockHash)
+		if parentWork == nil {
+			return errOrphanBlock
+		}
 
+		err := b.Put(block.Hash, block.Serialize())
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+
+		err = putBlockHeader(tx, block, blockStatusUnvalidated)
+		if err != nil {
+			return err
+		}
+
+		work := new(big.Int).SetBytes(parentWork)
+		work.Add(work, CalcWork(block.Bits))
+		err = w.Put(block.Hash, work.Bytes())
+		if err != nil {
+			return err
 		}
 
 		lastHash := b.Get([]byte("1"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		lastWork := new(big.Int).SetBytes(w.Get(lastHash))
+
+		if work.Cmp(lastWork) <= 0 {
+			return nil
+		}
+
+		if bytes.Equal(block.PrevBlockHash, lastHash) {
+			err = bc.connectBlock(tx, block)
+			connected = []*Block{block}
+		} else {
+			disconnected, connected, err = bc.reorganize(tx, block)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte("1"), block.Hash)
+		if err != nil {
+			return err
+		}
+		newTip = block.Hash
+
+		return nil
+	})
+	if invalid, ok := err.(invalidBlockError); ok {
+		bc.markInvalid(block, invalid.hash)
+		return invalid.err
+	}
+	if err != nil {
+		return err
+	}
+
+	if newTip != nil {
+		bc.tip = newTip
+	}
+	if len(disconnected) > 0 {
+		fmt.Printf("Reorganized onto block %x, disconnecting %d blocks\n", block.Hash, len(disconnected))
+	}
+	syncMempool(bc, disconnected, connected)
+
+	return nil
+}
+
+// connectBlock - connects block to the UTXO set and marks it valid. A
+// block breaking a consensus rule comes back as an invalidBlockError
+func (bc *Blockchain) connectBlock(tx *bolt.Tx, block *Block) error {
+	UTXOSet := UTXOSet{Blockchain: bc}
+
+	err := UTXOSet.connectBlock(tx, block)
+	if _, ok := err.(RuleError); ok {
+		return invalidBlockError{hash: block.Hash, err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	return setBlockStatus(tx, block.Hash, blockStatusValid)
+}
+
+// markInvalid - records the block with hash failedHash as invalid, along
+// with block, which is it or builds on it. The body of the failed block is
+// dropped, so only its header is kept to turn it away if it comes again
+func (bc *Blockchain) markInvalid(block *Block, failedHash []byte) {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		err := putBlockHeader(tx, block, blockStatusInvalid)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(block.Hash, failedHash) {
+			return nil
+		}
+
+		err = setBlockStatus(tx, failedHash, blockStatusInvalid)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket([]byte(blocksBucket)).Delete(failedHash)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(chainworkBucket)).Delete(failedHash)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// branchStatus - blockStatusInvalid if the block with blockHash or one of
+// its ancestors is known to be invalid. The walk back stops at the first
+// block that connected, as every block before it did too
+func (bc *Blockchain) branchStatus(blockHash []byte) byte {
+	status := blockStatusValid
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(headersBucket))
+
+		for hash := blockHash; ; {
+			data := b.Get(hash)
+			if data == nil {
+				return nil
+			}
+
+			switch blockStatus(data) {
+			case blockStatusValid:
+				return nil
+			case blockStatusInvalid:
+				status = blockStatusInvalid
+				return nil
+			}
 
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("1"), block.Hash)
+			header, err := DeserializeBlockHeader(data[:blockHeaderLen])
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
-			bc.tip = block.Hash
+			hash = header.PrevBlockHash
 		}
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return status
+}
+
+// reorganize - disconnects the blocks of the current branch back to the fork
+// point with newTip's branch, then connects the blocks of the new branch.
+// Returns the blocks disconnected, tip first, and those connected in order
+func (bc *Blockchain) reorganize(tx *bolt.Tx, newTip *Block) ([]*Block, []*Block, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+	UTXOSet := UTXOSet{Blockchain: bc}
+
+	parentOf := func(block *Block) (*Block, error) {
+		blockData := b.Get(block.PrevBlockHash)
+		if blockData == nil {
+			return nil, fmt.Errorf("no common ancestor with block %x", newTip.Hash)
+		}
+		return DeserializeBlock(blockData), nil
+	}
+
+	detach := DeserializeBlock(b.Get(b.Get([]byte("1"))))
+	attach := newTip
+	var attachList, detachList []*Block
+	var err error
+
+	for attach.Height > detach.Height {
+		attachList = append(attachList, attach)
+		if attach, err = parentOf(attach); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for !bytes.Equal(detach.Hash, attach.Hash) {
+		if detach.Height >= attach.Height {
+			err = UTXOSet.disconnectBlock(tx, detach)
+			if err != nil {
+				return nil, nil, err
+			}
+			detachList = append(detachList, detach)
+			if detach, err = parentOf(detach); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			attachList = append(attachList, attach)
+			if attach, err = parentOf(attach); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var connected []*Block
+	for i := len(attachList) - 1; i >= 0; i-- {
+		err = bc.connectBlock(tx, attachList[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		connected = append(connected, attachList[i])
+	}
+
+	return detachList, connected, nil
+}
+
+// HasBlock - checks whether the block is stored, on any branch
+func (bc *Blockchain) HasBlock(blockHash []byte) bool {
+	found := false
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		found = b.Get(blockHash) != nil
+
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return found
 }
 
 // NewBlockchain - start a new blockchain
@@ -149,7 +401,7 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 
 	var tip []byte
 
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
+	cbtx := NewCoinbaseTX(address, genesisCoinbaseData, 0, 0)
 	genesis := NewGenesisBlock(cbtx)
 
 	db, err := bolt.Open(dbFile, 0600, nil)
@@ -174,16 +426,47 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 			log.Panic(err)
 		}
 
+		_, err = tx.CreateBucket([]byte(headersBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = putBlockHeader(tx, genesis, blockStatusValid)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		w, err := tx.CreateBucket([]byte(chainworkBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = w.Put(genesis.Hash, CalcWork(genesis.Bits).Bytes())
+		if err != nil {
+			log.Panic(err)
+		}
+
+		_, err = tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
 		tip = genesis.Hash
 
 		return nil
 	})
+	if err != nil {
+		log.Panic(err)
+	}
 
 	bc := Blockchain{
 		tip: tip,
 		db:  db,
 	}
 
+	UTXOSet := UTXOSet{Blockchain: &bc}
+	UTXOSet.Update(genesis)
+
 	return &bc
 }
 
@@ -213,6 +496,9 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	err := bc.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockData := b.Get(blockHash)
+		if blockData == nil {
+			return errors.New("block not found")
+		}
 		block = *DeserializeBlock(blockData)
 
 		return nil
@@ -224,6 +510,119 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
+// GetBlockHeader - returns the header and height of the block with the
+// specified hash without reading its transactions
+func (bc *Blockchain) GetBlockHeader(blockHash []byte) (*BlockHeader, int, error) {
+	var header *BlockHeader
+	var height int
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(headersBucket))
+		data := b.Get(blockHash)
+		if len(data) != blockHeaderLen+8 && len(data) != blockHeaderLen+9 {
+			return errors.New("block header not found")
+		}
+
+		var err error
+		header, err = DeserializeBlockHeader(data[:blockHeaderLen])
+		height = int(binary.BigEndian.Uint64(data[blockHeaderLen:]))
+
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return header, height, nil
+}
+
+// putBlockHeader - stores the header, height and status of block in the
+// headers bucket
+func putBlockHeader(tx *bolt.Tx, block *Block, status byte) error {
+	b := tx.Bucket([]byte(headersBucket))
+	data := append(block.BlockHeader.Serialize(), IntToHex(int64(block.Height))...)
+	data = append(data, status)
+
+	return b.Put(block.Hash, data)
+}
+
+// blockStatus - the status of a record of the headers bucket
+func blockStatus(data []byte) byte {
+	if len(data) <= blockHeaderLen+8 {
+		return blockStatusValid
+	}
+
+	return data[blockHeaderLen+8]
+}
+
+// setBlockStatus - changes the status of the stored header of blockHash
+func setBlockStatus(tx *bolt.Tx, blockHash []byte, status byte) error {
+	b := tx.Bucket([]byte(headersBucket))
+	data := b.Get(blockHash)
+	if data == nil {
+		return fmt.Errorf("no header for block %x", blockHash)
+	}
+
+	updated := append(append([]byte{}, data[:blockHeaderLen+8]...), status)
+	return b.Put(blockHash, updated)
+}
+
+// GetBlockLocator - returns main chain hashes from the tip back to genesis,
+// dense near the tip and sparse further back, to tell a peer where we are
+func (bc *Blockchain) GetBlockLocator() [][]byte {
+	var locator [][]byte
+
+	hashes := bc.GetBlockHashes()
+	step := 1
+	for i := 0; i < len(hashes); i += step {
+		locator = append(locator, hashes[i])
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+
+	genesis := hashes[len(hashes)-1]
+	if !bytes.Equal(locator[len(locator)-1], genesis) {
+		locator = append(locator, genesis)
+	}
+
+	return locator
+}
+
+// GetHeadersAfter - returns, oldest first, the serialized headers of the main
+// chain that follow the most recent locator hash we have on it
+func (bc *Blockchain) GetHeadersAfter(locator [][]byte, max int) [][]byte {
+	var headers [][]byte
+
+	known := make(map[string]bool)
+	for _, hash := range locator {
+		known[hex.EncodeToString(hash)] = true
+	}
+
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+		if known[hex.EncodeToString(block.Hash)] {
+			break
+		}
+
+		headers = append(headers, block.BlockHeader.Serialize())
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+
+	if len(headers) > max {
+		headers = headers[:max]
+	}
+
+	return headers
+}
+
 // GetBlockHashes - return the hashes of the blocks
 func (bc *Blockchain) GetBlockHashes() [][]byte {
 	var hashes [][]byte
@@ -293,6 +692,15 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	for {
 		block := bci.Next()
 
+		var coinTime int64
+		err := bc.db.View(func(tx *bolt.Tx) error {
+			coinTime = lockTimeCutoff(tx, block)
+			return nil
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 
@@ -307,7 +715,13 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 				}
 
 				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				if outs.Outputs == nil {
+					outs.Outputs = make(map[int]TXOutput)
+					outs.Height = block.Height
+					outs.Time = coinTime
+					outs.Coinbase = tx.IsCoinbase()
+				}
+				outs.Outputs[outIdx] = out
 				UTXO[txID] = outs
 			}
 
@@ -327,30 +741,32 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	return UTXO
 }
 
-// FindSpendableOutputs - find spendable outputs for an address
-func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	unspentTXs := bc.FindUnspentTransactions(pubKeyHash)
-	accumulated := 0
-
-Work:
-	for _, tx := range unspentTXs {
-		txID := hex.EncodeToString(tx.ID)
+// FindSpendableOutputs - find the mature unspent outputs of an address,
+// picked by selector, or in the order of their outpoints when it is nil
+func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int, selector CoinSelector) (int, map[string][]int, error) {
+	var coins []Coin
+	spendHeight := bc.GetBestHeight() + 1
 
-		for outIdx, out := range tx.Vout {
-			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-				accumulated = accumulated + out.Value
-				unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-
-				if accumulated >= amount {
-					break Work
-				}
+	for txID, outs := range bc.FindUTXO() {
+		if !outs.IsMature(spendHeight) {
+			continue
+		}
 
+		id, err := hex.DecodeString(txID)
+		if err != nil {
+			return 0, nil, err
+		}
+		for outIdx, out := range outs.Outputs {
+			if out.IsLockedWithKey(pubKeyHash) {
+				coins = append(coins, Coin{TxID: id, Vout: outIdx, Value: out.Value})
 			}
 		}
 	}
+	sort.Slice(coins, func(i, j int) bool {
+		return coins[i].Outpoint() < coins[j].Outpoint()
+	})
 
-	return accumulated, unspentOutputs
+	return SelectCoins(coins, amount, selector, nil)
 }
 
 // FindTransaction - get the transaction with the ID specified
@@ -374,25 +790,23 @@ func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("transaction not found")
 }
 
-// MineBlock - mine a block for the blockchain
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
+// MineBlock - mine a block for the blockchain. A block the chain rejects,
+// such as one spending an output twice, is returned as an error
+func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
+	var lastBlock *Block
 
 	for _, tx := range transactions {
 		if !bc.VerifyTransaction(tx) {
-			log.Panic("err : invalid transaction")
+			return nil, fmt.Errorf("transaction %x is invalid", tx.ID)
 		}
 	}
 
 	err := bc.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
-		lastHash = b.Get([]byte("1"))
+		lastHash := b.Get([]byte("1"))
 		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
-
-		lastHeight = lastBlock.Height
+		lastBlock = DeserializeBlock(lastBlockData)
 
 		return nil
 	})
@@ -401,26 +815,14 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		log.Panic(err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	bits := bc.GetNextWorkRequired(lastBlock)
+	newBlock := NewBlock(transactions, lastBlock.Hash, lastBlock.Height+1, bits)
 
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-		err = b.Put([]byte("1"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
-		}
-		bc.tip = newBlock.Hash
-		return nil
-	})
+	err = bc.AddBlock(newBlock)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return newBlock
+	return newBlock, nil
 }
 
 // SignTransaction - signs input of a transaction
@@ -438,14 +840,19 @@ func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 	tx.Sign(privKey, prevTXs)
 }
 
-// VerifyTransaction - verify the input signatures of a transaction
+// VerifyTransaction - run the input scripts of a transaction. Spending a
+// transaction not on the chain fails it
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
 		prevTX, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return false
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
@@ -0,0 +1,78 @@
+package main
+
+import "math"
+
+// ChainParams - consensus parameters of a network
+type ChainParams struct {
+	// InitialSubsidy - coins paid to the miner of each block before the first halving
+	InitialSubsidy int
+	// HalvingInterval - number of blocks between subsidy halvings
+	HalvingInterval int
+	// MaxSupply - coins that may ever be issued; the subsidy stops once reached
+	MaxSupply int
+	// CoinbaseMaturity - blocks that must be built on a coinbase before its
+	// outputs can be spent
+	CoinbaseMaturity int
+}
+
+// mainNetParams - parameters of the default network
+var mainNetParams = ChainParams{
+	InitialSubsidy:   10,
+	HalvingInterval:  1000,
+	MaxSupply:        18000,
+	CoinbaseMaturity: 100,
+}
+
+// activeParams - parameters in use by this node
+var activeParams = &mainNetParams
+
+// maxMoney - the coins issued once the subsidy has run out. No output, and
+// no sum of outputs or inputs, may be worth more
+var maxMoney = activeParams.CalcIssuedSupply(math.MaxInt32)
+
+// CalcBlockSubsidy - returns the new coins a coinbase may create at height
+func (p *ChainParams) CalcBlockSubsidy(height int) int {
+	subsidy := p.halvedSubsidy(height)
+
+	if height > 0 {
+		issued := p.CalcIssuedSupply(height - 1)
+		if issued+subsidy > p.MaxSupply {
+			subsidy = p.MaxSupply - issued
+		}
+	}
+
+	return subsidy
+}
+
+// CalcIssuedSupply - returns the coins created by the blocks up to and including height
+func (p *ChainParams) CalcIssuedSupply(height int) int {
+	supply := 0
+
+	for start := 0; start <= height; start += p.HalvingInterval {
+		subsidy := p.halvedSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := p.HalvingInterval
+		if start+blocks > height+1 {
+			blocks = height + 1 - start
+		}
+		supply += subsidy * blocks
+	}
+
+	if supply > p.MaxSupply {
+		supply = p.MaxSupply
+	}
+
+	return supply
+}
+
+func (p *ChainParams) halvedSubsidy(height int) int {
+	halvings := uint(height / p.HalvingInterval)
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.InitialSubsidy >> halvings
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCalcBlockSubsidy(t *testing.T) {
+	tests := []struct {
+		height  int
+		subsidy int
+		issued  int
+	}{
+		{0, 10, 10},
+		{999, 10, 10000},
+		{1000, 5, 10005},
+		{1999, 5, 15000},
+		{2000, 2, 15002},
+		{2999, 2, 17000},
+		{3000, 1, 17001},
+		{3999, 1, 18000},
+		{4000, 0, 18000},
+		{1000000, 0, 18000},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.height), func(t *testing.T) {
+			subsidy := mainNetParams.CalcBlockSubsidy(test.height)
+			if subsidy != test.subsidy {
+				t.Errorf("subsidy = %d, want %d", subsidy, test.subsidy)
+			}
+			issued := mainNetParams.CalcIssuedSupply(test.height)
+			if issued != test.issued {
+				t.Errorf("issued supply = %d, want %d", issued, test.issued)
+			}
+		})
+	}
+}
+
+func TestCalcBlockSubsidyMaxSupply(t *testing.T) {
+	// 100 coins by height 9, then 5 a block until 118 is reached part way
+	// through the block at height 13
+	params := ChainParams{InitialSubsidy: 10, HalvingInterval: 10, MaxSupply: 118}
+
+	tests := []struct {
+		height  int
+		subsidy int
+		issued  int
+	}{
+		{9, 10, 100},
+		{12, 5, 115},
+		{13, 3, 118},
+		{14, 0, 118},
+		{20, 0, 118},
+	}
+
+	for _, test := range tests {
+		subsidy := params.CalcBlockSubsidy(test.height)
+		if subsidy != test.subsidy {
+			t.Errorf("subsidy at %d = %d, want %d", test.height, subsidy, test.subsidy)
+		}
+		issued := params.CalcIssuedSupply(test.height)
+		if issued != test.issued {
+			t.Errorf("issued supply at %d = %d, want %d", test.height, issued, test.issued)
+		}
+	}
+}
+
+func TestMaxMoney(t *testing.T) {
+	if maxMoney != mainNetParams.MaxSupply {
+		t.Fatalf("maxMoney = %d, want %d", maxMoney, mainNetParams.MaxSupply)
+	}
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
 // CLI - cmd line interface
@@ -16,12 +27,40 @@ type CLI struct {
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage: ")
 	//fmt.Println(" addblock -data BLOCK_DATA - add a block to the blockchain")
-	fmt.Println("createwallet - generates a new key pair and stores them in a wallet")
-	fmt.Println("listaddresses - lists all addresses from the wallet")
+	fmt.Println("createwallet -schnorr -mnemonic - generates a new key pair and stores them in a wallet, paid to its x-only key and signing with Schnorr signatures if schnorr is set. Keys are derived from the seed of the wallet once it has one; mnemonic gives it one from a new mnemonic and a passphrase read from the terminal")
+	fmt.Println("restorewallet - gives the wallet the seed of a mnemonic and its passphrase, both read from the terminal, and brings back the addresses of it paid on the chain")
+	fmt.Println("encryptwallet - encrypts the private keys and the seed of the wallet with a passphrase read from the terminal. Commands needing the keys of an encrypted wallet then refuse to run until walletpassphrase unlocks it")
+	fmt.Println("walletpassphrase -timeout SECONDS - reads the passphrase of the wallet from the terminal and has the running node hold its key in memory for SECONDS, so commands need not ask for it")
+	fmt.Println("walletlock - has the running node drop the key of the wallet before its timeout")
+	fmt.Println("dumpprivkey -address ADDRESS - prints the private key of ADDRESS in wallet import format")
+	fmt.Println("importprivkey -key KEY -schnorr -rescan - adds the private key KEY in wallet import format, paid to its x-only key if schnorr is set, and rebuilds the UTXO set to show its balance if rescan is set")
+	fmt.Println("dumpwallet -file FILE - writes every private key of the wallet and its seed to FILE")
+	fmt.Println("importwallet -file FILE -rescan - adds the keys and the seed of a wallet dumped to FILE, and rebuilds the UTXO set to show their balances if rescan is set")
+	fmt.Println("signmessage -address ADDRESS -message MESSAGE - signs MESSAGE with the key of ADDRESS, printing the signature in base64")
+	fmt.Println("verifymessage -address ADDRESS -signature SIGNATURE -message MESSAGE - checks that the base64 SIGNATURE of MESSAGE was made with the key of ADDRESS")
+	fmt.Println("listaddresses - lists all addresses from the wallet, with the derivation paths of those derived from the seed and marking those only watched")
+	fmt.Println("createmultisig -m M -pubkeys KEY,KEY,...  - creates an address needing M signatures from the KEYs, each a hex public key or an address of the wallet")
+	fmt.Println("createmusig -pubkeys KEY,KEY,...  - creates a Schnorr address needing signatures from all the KEYs, each a hex public key or an address of the wallet")
 	fmt.Println(" createblockchain -address ADDRESS create a blockchain and send the genesis reward to ADDRESS")
 	fmt.Println(" getbalance -address ADDRESS get the balance for ADDRESS")
+	fmt.Println(" listtransactions -address ADDRESS list the coins ADDRESS, or every address of the wallet, received and spent, with their confirmations")
+	fmt.Println("importaddress -address ADDRESS - watches ADDRESS without holding its keys")
+	fmt.Println("importpubkey -pubkey KEY -schnorr - watches the address of the hex public key KEY, its x-only address if schnorr is set, without holding its private key")
 	fmt.Println(" printchain - print all the blocks of the blockchain")
-	fmt.Println(" send -from SENDER -to RECEIVER -amount AMOUNT -mine  send AMAOUNT from SENDER to RECEIVER and mine if mine is set")
+	fmt.Println(" getsupply - print the coins issued up to the current height")
+	fmt.Println(" send -from SENDER -to RECEIVER -amount AMOUNT -fee FEE -locktime LOCKTIME -coinselect STRATEGY -pin TXID:VOUT,... -exclude TXID:VOUT,... -mine  send AMAOUNT from SENDER to RECEIVER paying FEE to the miner, not before the block height or unix time LOCKTIME, and mine if mine is set. STRATEGY picks the outputs spent: bnb, largest, smallest or random. bnb looks for outputs leaving no change, or change too small to be worth an output, which goes to the miner. Pinned outputs are always spent and excluded ones never")
+	fmt.Println(" htlc-initiate -from SENDER -to RECEIVER -amount AMOUNT -fee FEE -locktime LOCKTIME -secrethash HASH -mine  lock AMOUNT from SENDER in a contract RECEIVER can redeem with the secret, or SENDER can refund after the block height or unix time LOCKTIME. Without HASH a new secret is made and kept in the wallet")
+	fmt.Println(" htlc-redeem -contract CONTRACT -secret SECRET -fee FEE -mine  take the coins locked in CONTRACT, an address of the wallet or a hex script, by revealing SECRET, which defaults to the one in the wallet")
+	fmt.Println(" htlc-refund -contract CONTRACT -fee FEE -mine  take back the coins locked in CONTRACT once its lock time has passed")
+	fmt.Println(" htlc-audit -contract CONTRACT  print the terms of CONTRACT, the coins locked in it and its secret if a redeem revealed it")
+	fmt.Println(" createpsbt -from SENDER -to RECEIVER -amount AMOUNT -fee FEE -locktime LOCKTIME  print a partially signed transaction sending AMOUNT from SENDER to RECEIVER, in base64, for the holders of the keys to sign. Musig addresses take two rounds of signpsbt and combinepsbt, the first adding a nonce of each holder and the second their partial signatures")
+	fmt.Println(" signpsbt -psbt PSBT  add the signatures the keys of the wallet can make to PSBT, which needs no blockchain")
+	fmt.Println(" combinepsbt -psbts PSBT,PSBT,...  merge the signatures of copies of one PSBT signed apart")
+	fmt.Println(" finalizepsbt -psbt PSBT  print the signed transaction of a PSBT holding every signature it needs, in hex")
+	fmt.Println(" createrawtransaction -inputs TXID:VOUT,... -outputs ADDRESS:AMOUNT,... -locktime LOCKTIME  print an unsigned transaction spending the outputs VOUT of TXID to the ADDRESSes, in hex")
+	fmt.Println(" decoderawtransaction -hex TX -json  print the hex transaction TX, as JSON if json is set")
+	fmt.Println(" signrawtransaction -hex TX  sign the inputs of the hex transaction TX the wallet holds the keys of, printing it in hex and whether it is complete")
+	fmt.Println(" sendrawtransaction -hex TX -miner ADDRESS -mine  check and send the hex transaction TX, or mine it paying ADDRESS if mine is set")
 	fmt.Println("startnode -miner ADDRESS  - Start a node with the specified ID in the env var. miner enables mining")
 }
 
@@ -32,6 +71,53 @@ func (cli *CLI) validateArgs() {
 	}
 }
 
+// stdin - buffers the passphrases piped in, which may come several at once
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassphrase - prints prompt and reads a passphrase from the terminal
+// without echoing it, or a line of stdin when that is not a terminal, so it
+// never shows up in the arguments of the command
+func readPassphrase(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Panic(err)
+		}
+		return string(passphrase)
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Panic(err)
+	}
+
+	return strings.TrimRight(line, "\r\n")
+}
+
+// unlockWallets - opens encrypted wallets for the one command with the key
+// held by the running node after walletpassphrase, refusing while the node
+// holds none. The key is never written anywhere
+func unlockWallets(wallets *Wallets, nodeID string) {
+	if !wallets.IsEncrypted() {
+		return
+	}
+
+	key := sendWalletKey(nodeID)
+	if key == nil {
+		log.Panic(ErrWalletLocked)
+	}
+
+	err := wallets.Unlock(key)
+	if err != nil {
+		zeroBytes(key)
+		log.Panic(err)
+	}
+}
+
 func (cli *CLI) createBlockchain(address, nodeID string) {
 	if !ValidateAddress(address) {
 		log.Panic("Err : address invalid")
@@ -61,20 +147,15 @@ func (cli *CLI) getBalance(address, nodeID string) {
 	}
 	defer bc.db.Close()
 
-	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
-
-	for _, out := range UTXOs {
-		balance = balance + out.Value
-	}
+	balance, immature := UTXOSet.GetBalance(AddressScript(address))
 
 	fmt.Printf("Balance of %s is %d\n", address, balance)
+	if immature > 0 {
+		fmt.Printf("Immature balance of %s is %d\n", address, immature)
+	}
 }
 
-func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool) {
+func (cli *CLI) send(from, to, nodeID, coinSelect, pin, exclude string, amount, fee int, lockTime uint32, mineNow bool) {
 	if !ValidateAddress(from) {
 		log.Panic("err : sender address invalid")
 	}
@@ -83,9 +164,17 @@ func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool) {
 		log.Panic("err : recipient address invalid")
 	}
 
+	selector := coinSelectorArg(coinSelect)
+	control, err := ParseCoinControl(pin, exclude)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	bc := NewBlockchain(nodeID)
 	UTXOSet := UTXOSet{
 		Blockchain: bc,
+		Selector:   selector,
+		Control:    control,
 	}
 	defer bc.db.Close()
 
@@ -93,244 +182,1571 @@ func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool) {
 	if err != nil {
 		log.Panic(err)
 	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+	if wallets.IsWatchOnly(from) {
+		log.Panic("ERROR: Wallet only watches the sender address and cannot sign for it")
+	}
 
-	wallet := wallets.GetWallet(from)
-
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	var tx *Transaction
+	if redeemScript, ok := wallets.RedeemScripts[from]; ok {
+		privKeys := wallets.GetMultiSigKeys(redeemScript)
+		tx, err = NewMultiSigTransaction(redeemScript, privKeys, to, amount, fee, lockTime, &UTXOSet)
+	} else if pubKeys, ok := wallets.MuSigKeys[from]; ok {
+		var key *MuSigKey
+		key, err = AggregateKeys(pubKeys)
+		if err != nil {
+			log.Panic(err)
+		}
+		privKeys := wallets.GetMuSigKeys(key.PubKeys)
+		if privKeys == nil {
+			log.Panic("ERROR: Wallet does not hold every key of the musig address")
+		}
+		tx, err = NewMuSigTransaction(key, privKeys, to, amount, fee, lockTime, &UTXOSet)
+	} else {
+		wallet := wallets.GetWallet(from)
+		tx, err = NewUTXOTransaction(&wallet, to, amount, fee, lockTime, &UTXOSet)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
 
+	// branch and bound leaves the miner the change too small to be worth
+	// an output, which the coinbase claims along with fee
 	if mineNow {
-		cbTx := NewCoinbaseTX(from, "")
-		txs := []*Transaction{cbTx, tx}
-
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
-	} else {
-		sendTx(knownNodes[0], tx)
+		fee, err = UTXOSet.CalculateFee(tx)
+		if err != nil {
+			log.Panic(err)
+		}
 	}
 
+	publishTx(bc, tx, from, fee, mineNow)
+
 	fmt.Println("success!")
 
 }
 
-func (cli *CLI) printChain(nodeID string) {
+// coinSelectorArg - the coin selector named by arg, nil to take outputs in
+// the order of the UTXO set
+func coinSelectorArg(arg string) CoinSelector {
+	if arg == "" {
+		return nil
+	}
 
-	bc := NewBlockchain(nodeID)
-	bci := bc.Iterator()
+	selector, err := NewCoinSelector(arg)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	for {
-		block := bci.Next()
+	return selector
+}
 
-		pow := NewProofOfWork(block)
-		//fmt.Printf("Data       : %s\n", block.Data)
-		fmt.Printf("Prev Hash  : %x\n", block.PrevBlockHash)
-		fmt.Printf("Hash       : %x\n", block.Hash)
-		fmt.Printf("Nonce      : %d\n", block.Nonce)
-		fmt.Printf("PoW        : %s\n", strconv.FormatBool(pow.Validate()))
-		fmt.Printf("Height     : %d\n", block.Height)
-		fmt.Println("-------------------------------------------------------")
+// publishTx - mine tx on this node, paying fee to minerAddress, or hand it
+// to the central node
+func publishTx(bc *Blockchain, tx *Transaction, minerAddress string, fee int, mineNow bool) {
+	if mineNow {
+		cbTx := NewCoinbaseTX(minerAddress, "", bc.GetBestHeight()+1, fee)
+		txs := []*Transaction{cbTx, tx}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
+		_, err := bc.MineBlock(txs)
+		if err != nil {
+			log.Panic(err)
 		}
-
+	} else {
+		sendTx(knownNodes[0], tx)
 	}
-
 }
 
-func (cli *CLI) createWallet(nodeID string) {
-	wallets, _ := NewWallets(nodeID)
-	address := wallets.CreateWallet()
-	wallets.SaveToFile(nodeID)
+func (cli *CLI) htlcInitiate(from, to, secretHashHex, nodeID string, amount, fee int, lockTime uint32, mineNow bool) {
+	if !ValidateAddress(from) || GetScriptClass(AddressScript(from)) != PubKeyHashTy {
+		log.Panic("err : sender address invalid")
+	}
 
-	fmt.Printf("Your new address : %s\n", address)
-}
+	if !ValidateAddress(to) || GetScriptClass(AddressScript(to)) != PubKeyHashTy {
+		log.Panic("err : recipient address invalid")
+	}
 
-func (cli *CLI) listAddresses(nodeID string) {
 	wallets, err := NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+	wallet := wallets.GetWallet(from)
 
-	addresses := wallets.GetAddresses()
+	// without a hash this is the side that picks the secret
+	var secretHash []byte
+	if secretHashHex == "" {
+		secret := make([]byte, htlcSecretSize)
+		_, err = rand.Read(secret)
+		if err != nil {
+			log.Panic(err)
+		}
+		wallets.AddSecret(secret)
 
-	for _, address := range addresses {
-		fmt.Println(address)
+		hash := sha256.Sum256(secret)
+		secretHash = hash[:]
+	} else {
+		secretHash, err = hex.DecodeString(secretHashHex)
+		if err != nil {
+			log.Panic(err)
+		}
 	}
 
-}
+	contract, err := HTLCScript(&HTLC{
+		RecipientHash: ExtractPubKeyHash(AddressScript(to)),
+		RefundHash:    ExtractPubKeyHash(AddressScript(from)),
+		SecretHash:    secretHash,
+		LockTime:      lockTime,
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	contractAddress := wallets.AddContract(contract)
+	wallets.SaveToFile(nodeID)
 
-func (cli *CLI) reindexUTXO(nodeID string) {
 	bc := NewBlockchain(nodeID)
 	UTXOSet := UTXOSet{
 		Blockchain: bc,
 	}
-	UTXOSet.Reindex()
-
-	count := UTXOSet.CountTransactions()
-	fmt.Printf("Done! There are %d transactions in the UTXO set\n", count)
-}
+	defer bc.db.Close()
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
-	fmt.Printf("Starting node %s]n", nodeID)
-	if len(minerAddress) > 0 {
-		if ValidateAddress(minerAddress) {
-			fmt.Println("Mining started. Rewards sent to: ", minerAddress)
-		} else {
-			log.Panic("wrong miner address")
-		}
+	tx, err := NewUTXOTransaction(&wallet, contractAddress, amount, fee, 0, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
 	}
-	StartServer(nodeID, minerAddress)
-}
+	publishTx(bc, tx, from, fee, mineNow)
 
-// Run - execute the cli
-func (cli *CLI) Run() {
-	cli.validateArgs()
+	fmt.Printf("Secret hash      : %x\n", secretHash)
+	fmt.Printf("Contract address : %s\n", contractAddress)
+	fmt.Printf("Contract         : %x\n", contract)
+	fmt.Printf("Transaction      : %x\n", tx.ID)
+}
 
-	nodeID := os.Getenv("NODE_ID")
-	if nodeID == "" {
-		fmt.Println("No node ID set.")
-		os.Exit(1)
+func (cli *CLI) htlcRedeem(contractArg, secretHex, nodeID string, fee int, mineNow bool) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
 	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
 
-	//addBlockCmd := flag.NewFlagSet("addblock", flag.ExitOnError)
-	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
-	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
-	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
-	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
-	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
-	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
-	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
-	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
-
-	//addBlockData := addBlockCmd.String("data", "", "block data")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "coinbase address")
-	getBalanceAddress := getBalanceCmd.String("address", "", "get the balance for this address")
-	senderAddress := sendCmd.String("from", "", " specify the sender address")
-	receiverAddress := sendCmd.String("to", "", " specify the receiver address")
-	amountInt := sendCmd.Int("amount", 0, " specify the amount to be transferred")
-	sendMine := sendCmd.Bool("mine", false, "mine on the same node")
-	startNodeMiner := startNodeCmd.String("miner", "", "enable mining and send reward to ADDRESS")
+	contract := wallets.GetContract(contractArg)
+	htlc, err := ExtractHTLC(contract)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	switch os.Args[1] {
-	case "createblockchain":
-		{
-			err := createBlockchainCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printUsage()
-				os.Exit(1)
-			}
-		}
-	case "printchain":
-		{
-			err := printChainCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printUsage()
-				os.Exit(1)
-			}
-		}
-	case "getbalance":
-		{
-			err := getBalanceCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printUsage()
-				os.Exit(1)
-			}
-		}
-	case "send":
-		{
-			err := sendCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printUsage()
-				os.Exit(1)
-			}
-		}
-	case "createwallet":
-		{
-			err := createWalletCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printUsage()
-				os.Exit(1)
-			}
-		}
-	case "listaddresses":
-		{
-			err := listAddressesCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printUsage()
-				os.Exit(1)
-			}
-		}
-	case "reindex":
-		{
-			err := reindexCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printUsage()
-				os.Exit(1)
-			}
-		}
-	case "startnode":
-		{
-			err := startNodeCmd.Parse(os.Args[2:])
-			if err != nil {
-				cli.printChain(nodeID)
-				os.Exit(1)
-			}
+	secret := wallets.GetSecret(htlc.SecretHash)
+	if secretHex != "" {
+		secret, err = hex.DecodeString(secretHex)
+		if err != nil {
+			log.Panic(err)
 		}
-	default:
-		{
-			cli.printUsage()
-			os.Exit(1)
+		if !htlc.IsSecret(secret) {
+			log.Panic("ERROR: Secret does not match the contract")
 		}
-
 	}
-
-	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
-			os.Exit(1)
-		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+	if secret == nil {
+		log.Panic("ERROR: The secret of the contract is not known")
 	}
 
-	if printChainCmd.Parsed() {
-		cli.printChain(nodeID)
+	wallet := wallets.GetWalletByKeyHash(htlc.RecipientHash)
+	if wallet == nil {
+		log.Panic("ERROR: Wallet does not hold the recipient key of the contract")
 	}
 
-	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			os.Exit(1)
-		}
-		cli.getBalance(*getBalanceAddress, nodeID)
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{
+		Blockchain: bc,
 	}
+	defer bc.db.Close()
 
-	if sendCmd.Parsed() {
-		if *senderAddress == "" || *receiverAddress == "" || *amountInt <= 0 {
-			sendCmd.Usage()
-			os.Exit(1)
-		}
-		cli.send(*senderAddress, *receiverAddress, nodeID, *amountInt, *sendMine)
+	tx := NewHTLCRedeemTransaction(contract, secret, wallet, fee, &UTXOSet)
+	if !bc.VerifyTransaction(tx) {
+		log.Panic("ERROR: Redeem transaction is invalid")
 	}
 
-	if startNodeCmd.Parsed() {
-		nodeID = os.Getenv("NODE_ID")
-		if nodeID == "" {
-			startNodeCmd.Usage()
-			os.Exit(1)
-		}
-		cli.startNode(nodeID, *startNodeMiner)
+	// the secret is only kept once it has redeemed the contract
+	wallets.AddSecret(secret)
+	wallets.AddContract(contract)
+	wallets.SaveToFile(nodeID)
+
+	publishTx(bc, tx, fmt.Sprintf("%s", wallet.GetAddress()), fee, mineNow)
+
+	fmt.Printf("Transaction : %x\n", tx.ID)
+}
+
+func (cli *CLI) htlcRefund(contractArg, nodeID string, fee int, mineNow bool) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
 	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
 
-	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+	contract := wallets.GetContract(contractArg)
+	htlc, err := ExtractHTLC(contract)
+	if err != nil {
+		log.Panic(err)
 	}
 
-	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+	wallet := wallets.GetWalletByKeyHash(htlc.RefundHash)
+	if wallet == nil {
+		log.Panic("ERROR: Wallet does not hold the refund key of the contract")
 	}
 
-	if reindexCmd.Parsed() {
-		cli.reindexUTXO(nodeID)
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{
+		Blockchain: bc,
+	}
+	defer bc.db.Close()
+
+	tx := NewHTLCRefundTransaction(contract, wallet, fee, &UTXOSet)
+	publishTx(bc, tx, fmt.Sprintf("%s", wallet.GetAddress()), fee, mineNow)
+
+	fmt.Printf("Transaction : %x\n", tx.ID)
+}
+
+func (cli *CLI) htlcAudit(contractArg, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	contract := wallets.GetContract(contractArg)
+	htlc, err := ExtractHTLC(contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{
+		Blockchain: bc,
+	}
+	defer bc.db.Close()
+
+	balance, _ := UTXOSet.GetBalance(PayToScriptHashScript(HashPubKey(contract)))
+
+	fmt.Printf("Contract address : %s\n", ScriptAddress(contract))
+	fmt.Printf("Recipient        : %s\n", encodeAddress(version, htlc.RecipientHash))
+	fmt.Printf("Refund           : %s\n", encodeAddress(version, htlc.RefundHash))
+	fmt.Printf("Secret hash      : %x\n", htlc.SecretHash)
+	if htlc.LockTime < lockTimeThreshold {
+		fmt.Printf("Lock time        : height %d, now %d\n", htlc.LockTime, bc.GetBestHeight())
+	} else {
+		fmt.Printf("Lock time        : %s\n", time.Unix(int64(htlc.LockTime), 0))
+	}
+	fmt.Printf("Locked           : %d\n", balance)
+
+	// a redeem on this chain gives the secret away
+	secret := wallets.GetSecret(htlc.SecretHash)
+	if secret == nil {
+		secret = bc.FindHTLCSecret(contract)
+		// a locked wallet cannot seal it, and it stays on the chain anyway
+		if secret != nil && !wallets.IsLocked() {
+			wallets.AddSecret(secret)
+			wallets.SaveToFile(nodeID)
+		}
+	}
+	if secret != nil {
+		fmt.Printf("Secret           : %x\n", secret)
+	}
+}
+
+func (cli *CLI) createPSBT(from, to, nodeID string, amount, fee int, lockTime uint32) {
+	if !ValidateAddress(from) {
+		log.Panic("err : sender address invalid")
+	}
+
+	if !ValidateAddress(to) {
+		log.Panic("err : recipient address invalid")
+	}
+
+	wallets, _ := NewWallets(nodeID)
+
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{
+		Blockchain: bc,
+	}
+	defer bc.db.Close()
+
+	tx, err := newSpendTransaction(from, to, amount, fee, lockTime, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	psbt, err := NewPSBT(tx, wallets.RedeemScripts[from], wallets.MuSigKeys[from], bc)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(psbt.Serialize()))
+}
+
+// psbtArg - decodes a base64 PSBT
+func psbtArg(arg string) *PSBT {
+	data, err := base64.StdEncoding.DecodeString(arg)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	psbt, err := DeserializePSBT(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return psbt
+}
+
+func (cli *CLI) signPSBT(psbtBase64, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	psbt := psbtArg(psbtBase64)
+	signed, err := wallets.SignPSBT(psbt)
+	// keeps the secret musig nonces for the second round and drops the used,
+	// even when signing stopped part way
+	wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Added %d signatures and musig nonces\n", signed)
+	fmt.Println(base64.StdEncoding.EncodeToString(psbt.Serialize()))
+}
+
+func (cli *CLI) combinePSBT(psbts string) {
+	var combined *PSBT
+	for _, arg := range strings.Split(psbts, ",") {
+		psbt := psbtArg(arg)
+		if combined == nil {
+			combined = psbt
+			continue
+		}
+
+		err := combined.Combine(psbt)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(combined.Serialize()))
+}
+
+func (cli *CLI) finalizePSBT(psbtBase64 string) {
+	tx, err := psbtArg(psbtBase64).Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(hex.EncodeToString(tx.Serialize()))
+}
+
+// rawTxArg - decodes a hex transaction
+func rawTxArg(arg string) Transaction {
+	tx, err := DecodeRawTransaction(arg)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return *tx
+}
+
+func (cli *CLI) createRawTransaction(inputsArg, outputsArg string, lockTime uint32) {
+	inputs, err := ParseRawInputs(inputsArg)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	outputs, err := ParseRawOutputs(outputsArg)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx := NewRawTransaction(inputs, outputs, lockTime)
+	fmt.Println(hex.EncodeToString(tx.Serialize()))
+}
+
+func (cli *CLI) decodeRawTransaction(txHex string, asJSON bool) {
+	tx := rawTxArg(txHex)
+
+	if !asJSON {
+		fmt.Println(tx.String())
+		return
+	}
+
+	decoded, err := tx.JSON()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(string(decoded))
+}
+
+func (cli *CLI) signRawTransaction(txHex, nodeID string) {
+	tx := rawTxArg(txHex)
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	prevOutputs, err := bc.PrevOutputs(&tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	complete, err := wallets.SignRawTransaction(&tx, prevOutputs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(hex.EncodeToString(tx.Serialize()))
+	fmt.Printf("complete: %s\n", strconv.FormatBool(complete))
+}
+
+func (cli *CLI) sendRawTransaction(txHex, minerAddress, nodeID string, mineNow bool) {
+	tx := rawTxArg(txHex)
+
+	err := CheckTransactionSanity(&tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{
+		Blockchain: bc,
+	}
+	defer bc.db.Close()
+
+	fee, err := UTXOSet.CalculateFee(&tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !bc.VerifyTransaction(&tx) {
+		log.Panic("ERROR: Invalid transaction")
+	}
+
+	publishTx(bc, &tx, minerAddress, fee, mineNow)
+
+	fmt.Printf("Sent transaction %x\n", tx.ID)
+}
+
+func (cli *CLI) printChain(nodeID string) {
+
+	bc := NewBlockchain(nodeID)
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		pow := NewProofOfWork(block)
+		//fmt.Printf("Data       : %s\n", block.Data)
+		fmt.Printf("Prev Hash  : %x\n", block.PrevBlockHash)
+		fmt.Printf("Hash       : %x\n", block.Hash)
+		fmt.Printf("Merkle Root: %x\n", block.MerkleRoot)
+		fmt.Printf("Nonce      : %d\n", block.Nonce)
+		fmt.Printf("Bits       : %08x\n", block.Bits)
+		fmt.Printf("PoW        : %s\n", strconv.FormatBool(pow.Validate(bc)))
+		fmt.Printf("Height     : %d\n", block.Height)
+		fmt.Println("-------------------------------------------------------")
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+
+	}
+
+}
+
+func (cli *CLI) createWallet(nodeID string, schnorr, mnemonic bool) {
+	wallets, _ := NewWallets(nodeID)
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	if mnemonic {
+		passphrase := readPassphrase("Mnemonic passphrase, empty for none: ")
+		if readPassphrase("Repeat the passphrase: ") != passphrase {
+			log.Panic("ERROR: the passphrases do not match")
+		}
+
+		words, err := wallets.NewSeed(passphrase)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Your mnemonic : %s\n", words)
+		fmt.Println("Write it down, it and the passphrase are all that is needed to restore the wallet")
+	}
+
+	address := wallets.CreateWallet(schnorr)
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Your new address : %s\n", address)
+	if path, ok := wallets.Paths[address]; ok {
+		fmt.Printf("Derivation path : %s\n", path)
+	}
+}
+
+func (cli *CLI) restoreWallet(nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	mnemonic := strings.Join(strings.Fields(readPassphrase("Mnemonic: ")), " ")
+	if mnemonic == "" {
+		log.Panic("ERROR: the mnemonic is empty")
+	}
+	passphrase := readPassphrase("Mnemonic passphrase, empty for none: ")
+
+	err := wallets.SetSeed(mnemonic, passphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if dbExists(fmt.Sprintf(dbFile, nodeID)) {
+		bc := NewBlockchain(nodeID)
+		found := wallets.ScanHD(bc)
+		bc.db.Close()
+
+		fmt.Printf("Found %d used keys of the seed\n", found)
+	} else {
+		fmt.Println("No blockchain to scan, addresses will be found by restoring again once there is one")
+	}
+	wallets.SaveToFile(nodeID)
+
+	for address, path := range wallets.Paths {
+		fmt.Printf("%s %s\n", address, path)
+	}
+}
+
+func (cli *CLI) encryptWallet(nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	passphrase := readPassphrase("New wallet passphrase: ")
+	if passphrase == "" {
+		log.Panic("ERROR: the passphrase is empty")
+	}
+	if readPassphrase("Repeat the passphrase: ") != passphrase {
+		log.Panic("ERROR: the passphrases do not match")
+	}
+
+	err = wallets.Encrypt(passphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Println("Wallet encrypted, unlock it with walletpassphrase on a running node to sign")
+}
+
+func (cli *CLI) walletPassphrase(timeout int, nodeID string) {
+	passphrase := readPassphrase("Wallet passphrase: ")
+
+	err := sendWalletUnlock(nodeID, passphrase, time.Duration(timeout)*time.Second)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wallet unlocked until %s\n", time.Now().Add(time.Duration(timeout)*time.Second).Format(time.RFC3339))
+}
+
+func (cli *CLI) walletLock(nodeID string) {
+	err := sendWalletLock(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Wallet locked")
+}
+
+func (cli *CLI) importAddress(address, nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+
+	err := wallets.AddWatchAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Watching %s\n", address)
+}
+
+func (cli *CLI) importPubKey(pubKeyHex string, schnorr bool, nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+
+	pubKey, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	address, err := wallets.AddWatchPubKey(pubKey, schnorr)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Watching %s\n", address)
+}
+
+func (cli *CLI) listTransactions(address, nodeID string) {
+	var addresses []string
+	if address != "" {
+		if !ValidateAddress(address) {
+			log.Panic("Err : Invalid Address")
+		}
+		addresses = []string{address}
+	} else {
+		wallets, err := NewWallets(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		addresses = wallets.GetAddresses()
+	}
+
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	bestHeight := bc.GetBestHeight()
+
+	for _, wtx := range bc.FindHistory(addresses) {
+		fmt.Printf("%x %s %-8s %6d  confirmations %d  %s\n", wtx.TxID, wtx.Address, wtx.Category, wtx.Amount,
+			bestHeight-wtx.Height+1, time.Unix(wtx.Time, 0).Format(time.RFC3339))
+	}
+}
+
+func (cli *CLI) dumpPrivKey(address, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("ERROR: Wallet holds no key for %s", address)
+	}
+
+	fmt.Println(EncodeWIF(wallet.PrivateKey))
+}
+
+func (cli *CLI) importPrivKey(key string, schnorr, rescan bool, nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	privKey, err := DecodeWIF(key)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	address, err := wallets.ImportKey(*privKey, schnorr)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Imported %s\n", address)
+	if rescan {
+		cli.rescan([]string{address}, nodeID)
+	}
+}
+
+func (cli *CLI) dumpWallet(file, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	// a file dumped over keeps its mode otherwise
+	err = f.Chmod(0600)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = wallets.DumpWallet(f)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wallet dumped to %s\n", file)
+}
+
+func (cli *CLI) importWallet(file string, rescan bool, nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	addresses, err := wallets.ImportWallet(f)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Imported %d keys\n", len(addresses))
+	if rescan {
+		cli.rescan(addresses, nodeID)
+	}
+}
+
+// rescan - rebuilds the UTXO set from the blocks and prints the balances of
+// addresses
+func (cli *CLI) rescan(addresses []string, nodeID string) {
+	if !dbExists(fmt.Sprintf(dbFile, nodeID)) {
+		fmt.Println("No blockchain to rescan")
+		return
+	}
+
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{
+		Blockchain: bc,
+	}
+	defer bc.db.Close()
+
+	UTXOSet.Reindex()
+
+	for _, address := range addresses {
+		balance, immature := UTXOSet.GetBalance(AddressScript(address))
+		fmt.Printf("Balance of %s is %d, immature %d\n", address, balance, immature)
+	}
+}
+
+func (cli *CLI) signMessage(address, message, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, nodeID)
+	defer wallets.Lock()
+
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("ERROR: Wallet holds no key for %s", address)
+	}
+
+	signature, err := wallet.SignMessage(message)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(signature))
+}
+
+func (cli *CLI) verifyMessage(address, signatureArg, message string) {
+	signature, err := base64.StdEncoding.DecodeString(signatureArg)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if VerifyMessage(address, signature, message) {
+		fmt.Println("Signature is valid")
+	} else {
+		fmt.Println("Signature is not valid")
+		os.Exit(1)
+	}
+}
+
+func (cli *CLI) listAddresses(nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	addresses := wallets.GetAddresses()
+
+	for _, address := range addresses {
+		if path, ok := wallets.Paths[address]; ok {
+			fmt.Println(address, path)
+			continue
+		}
+		if wallets.IsWatchOnly(address) {
+			fmt.Println(address, "watch-only")
+			continue
+		}
+		fmt.Println(address)
+	}
+
+}
+
+// pubKeysArg - the public keys of a comma separated list of hex keys and
+// addresses of the wallet, watched ones included when their key is known
+func pubKeysArg(wallets *Wallets, keys string) [][]byte {
+	var pubKeys [][]byte
+	for _, key := range strings.Split(keys, ",") {
+		if wallet, ok := wallets.Wallets[key]; ok {
+			pubKeys = append(pubKeys, wallet.PublicKey)
+			continue
+		}
+		if pubKey := wallets.WatchOnly[key]; pubKey != nil {
+			pubKeys = append(pubKeys, pubKey)
+			continue
+		}
+
+		pubKey, err := hex.DecodeString(key)
+		if err != nil {
+			log.Panicf("Err : %s is neither a public key nor an address of the wallet", key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	return pubKeys
+}
+
+func (cli *CLI) createMultiSig(m int, keys, nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+
+	address, err := wallets.AddMultiSig(m, pubKeysArg(wallets, keys))
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Your new multisig address : %s\n", address)
+	fmt.Printf("Redeem script : %x\n", wallets.RedeemScripts[address])
+}
+
+func (cli *CLI) createMuSig(keys, nodeID string) {
+	wallets, _ := NewWallets(nodeID)
+
+	address, err := wallets.AddMuSig(pubKeysArg(wallets, keys))
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Your new musig address : %s\n", address)
+}
+
+func (cli *CLI) reindexUTXO(nodeID string) {
+	bc := NewBlockchain(nodeID)
+	UTXOSet := UTXOSet{
+		Blockchain: bc,
+	}
+	UTXOSet.Reindex()
+
+	count := UTXOSet.CountTransactions()
+	fmt.Printf("Done! There are %d transactions in the UTXO set\n", count)
+}
+
+func (cli *CLI) getSupply(nodeID string) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	height := bc.GetBestHeight()
+
+	fmt.Printf("Height        : %d\n", height)
+	fmt.Printf("Issued supply : %d\n", activeParams.CalcIssuedSupply(height))
+	fmt.Printf("Max supply    : %d\n", activeParams.MaxSupply)
+	fmt.Printf("Next subsidy  : %d\n", activeParams.CalcBlockSubsidy(height+1))
+}
+
+func (cli *CLI) startNode(nodeID, minerAddress string) {
+	fmt.Printf("Starting node %s]n", nodeID)
+	if len(minerAddress) > 0 {
+		if ValidateAddress(minerAddress) {
+			fmt.Println("Mining started. Rewards sent to: ", minerAddress)
+		} else {
+			log.Panic("wrong miner address")
+		}
+	}
+	StartServer(nodeID, minerAddress)
+}
+
+// Run - execute the cli
+func (cli *CLI) Run() {
+	cli.validateArgs()
+
+	nodeID := os.Getenv("NODE_ID")
+	if nodeID == "" {
+		fmt.Println("No node ID set.")
+		os.Exit(1)
+	}
+
+	//addBlockCmd := flag.NewFlagSet("addblock", flag.ExitOnError)
+	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	dumpWalletCmd := flag.NewFlagSet("dumpwallet", flag.ExitOnError)
+	importWalletCmd := flag.NewFlagSet("importwallet", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	walletPassphraseCmd := flag.NewFlagSet("walletpassphrase", flag.ExitOnError)
+	walletLockCmd := flag.NewFlagSet("walletlock", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMuSigCmd := flag.NewFlagSet("createmusig", flag.ExitOnError)
+	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
+	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	importPubKeyCmd := flag.NewFlagSet("importpubkey", flag.ExitOnError)
+	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
+	getSupplyCmd := flag.NewFlagSet("getsupply", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	createPSBTCmd := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	signPSBTCmd := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	combinePSBTCmd := flag.NewFlagSet("combinepsbt", flag.ExitOnError)
+	finalizePSBTCmd := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtransaction", flag.ExitOnError)
+	decodeRawTxCmd := flag.NewFlagSet("decoderawtransaction", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtransaction", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtransaction", flag.ExitOnError)
+	htlcInitiateCmd := flag.NewFlagSet("htlc-initiate", flag.ExitOnError)
+	htlcRedeemCmd := flag.NewFlagSet("htlc-redeem", flag.ExitOnError)
+	htlcRefundCmd := flag.NewFlagSet("htlc-refund", flag.ExitOnError)
+	htlcAuditCmd := flag.NewFlagSet("htlc-audit", flag.ExitOnError)
+
+	//addBlockData := addBlockCmd.String("data", "", "block data")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "coinbase address")
+	getBalanceAddress := getBalanceCmd.String("address", "", "get the balance for this address")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", " the address whose key to print")
+	importPrivKey := importPrivKeyCmd.String("key", "", " private key in wallet import format")
+	importPrivKeySchnorr := importPrivKeyCmd.Bool("schnorr", false, " pay the wallet to its x-only key and sign with Schnorr signatures")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", false, " rebuild the UTXO set and show the balance of the key")
+	dumpWalletFile := dumpWalletCmd.String("file", "", " the file to write the keys to")
+	importWalletFile := importWalletCmd.String("file", "", " the file of a wallet dump")
+	importWalletRescan := importWalletCmd.Bool("rescan", false, " rebuild the UTXO set and show the balances of the keys")
+	signMessageAddress := signMessageCmd.String("address", "", " the address whose key signs")
+	signMessageText := signMessageCmd.String("message", "", " the message to sign")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", " the address that signed")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", " base64 signature")
+	verifyMessageText := verifyMessageCmd.String("message", "", " the message signed")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "list the transactions of this address instead of the whole wallet")
+	importAddress := importAddressCmd.String("address", "", " the address to watch")
+	importPubKey := importPubKeyCmd.String("pubkey", "", " hex public key whose address to watch")
+	importPubKeySchnorr := importPubKeyCmd.Bool("schnorr", false, " watch the x-only address of the key")
+	senderAddress := sendCmd.String("from", "", " specify the sender address")
+	receiverAddress := sendCmd.String("to", "", " specify the receiver address")
+	amountInt := sendCmd.Int("amount", 0, " specify the amount to be transferred")
+	feeInt := sendCmd.Int("fee", 0, " specify the fee paid to the miner")
+	lockTime := sendCmd.Uint("locktime", 0, " block height or unix time before which the transaction cannot be mined")
+	sendMine := sendCmd.Bool("mine", false, "mine on the same node")
+	sendCoinSelect := sendCmd.String("coinselect", "", " how to pick the outputs spent: bnb, largest, smallest or random")
+	sendPin := sendCmd.String("pin", "", " comma separated txid:vout of outputs to spend")
+	sendExclude := sendCmd.String("exclude", "", " comma separated txid:vout of outputs not to spend")
+	psbtFrom := createPSBTCmd.String("from", "", " specify the sender address")
+	psbtTo := createPSBTCmd.String("to", "", " specify the receiver address")
+	psbtAmount := createPSBTCmd.Int("amount", 0, " specify the amount to be transferred")
+	psbtFee := createPSBTCmd.Int("fee", 0, " specify the fee paid to the miner")
+	psbtLockTime := createPSBTCmd.Uint("locktime", 0, " block height or unix time before which the transaction cannot be mined")
+	signPSBTArg := signPSBTCmd.String("psbt", "", " base64 PSBT to sign")
+	combinePSBTArg := combinePSBTCmd.String("psbts", "", " comma separated base64 PSBTs")
+	finalizePSBTArg := finalizePSBTCmd.String("psbt", "", " base64 PSBT to finalize")
+	createRawTxInputs := createRawTxCmd.String("inputs", "", " comma separated txid:vout of the outputs to spend")
+	createRawTxOutputs := createRawTxCmd.String("outputs", "", " comma separated address:amount to pay")
+	createRawTxLockTime := createRawTxCmd.Uint("locktime", 0, " block height or unix time before which the transaction cannot be mined")
+	decodeRawTxHex := decodeRawTxCmd.String("hex", "", " hex transaction to decode")
+	decodeRawTxJSON := decodeRawTxCmd.Bool("json", false, " print the transaction as JSON")
+	signRawTxHex := signRawTxCmd.String("hex", "", " hex transaction to sign")
+	sendRawTxHex := sendRawTxCmd.String("hex", "", " hex signed transaction")
+	sendRawTxMiner := sendRawTxCmd.String("miner", "", " address the reward goes to when mining")
+	sendRawTxMine := sendRawTxCmd.Bool("mine", false, "mine on the same node")
+	startNodeMiner := startNodeCmd.String("miner", "", "enable mining and send reward to ADDRESS")
+	multiSigRequired := createMultiSigCmd.Int("m", 0, " number of signatures required")
+	multiSigKeys := createMultiSigCmd.String("pubkeys", "", " comma separated public keys or wallet addresses")
+	muSigKeys := createMuSigCmd.String("pubkeys", "", " comma separated public keys or wallet addresses")
+	createWalletSchnorr := createWalletCmd.Bool("schnorr", false, " pay the wallet to its x-only key and sign with Schnorr signatures")
+	createWalletMnemonic := createWalletCmd.Bool("mnemonic", false, " give the wallet a seed from a new mnemonic")
+	unlockTimeout := walletPassphraseCmd.Int("timeout", 0, " seconds the node holds the key of the wallet for")
+	htlcFrom := htlcInitiateCmd.String("from", "", " the address funding the contract, which can refund it")
+	htlcTo := htlcInitiateCmd.String("to", "", " the address that can redeem the contract")
+	htlcAmount := htlcInitiateCmd.Int("amount", 0, " specify the amount to lock in the contract")
+	htlcInitiateFee := htlcInitiateCmd.Int("fee", 0, " specify the fee paid to the miner")
+	htlcLockTime := htlcInitiateCmd.Uint("locktime", 0, " block height or unix time after which the contract can be refunded")
+	htlcSecretHash := htlcInitiateCmd.String("secrethash", "", " hex SHA-256 of the secret, when the other side picked it")
+	htlcInitiateMine := htlcInitiateCmd.Bool("mine", false, "mine on the same node")
+	htlcRedeemContract := htlcRedeemCmd.String("contract", "", " contract address or hex script")
+	htlcSecret := htlcRedeemCmd.String("secret", "", " hex secret of the contract")
+	htlcRedeemFee := htlcRedeemCmd.Int("fee", 0, " specify the fee paid to the miner")
+	htlcRedeemMine := htlcRedeemCmd.Bool("mine", false, "mine on the same node")
+	htlcRefundContract := htlcRefundCmd.String("contract", "", " contract address or hex script")
+	htlcRefundFee := htlcRefundCmd.Int("fee", 0, " specify the fee paid to the miner")
+	htlcRefundMine := htlcRefundCmd.Bool("mine", false, "mine on the same node")
+	htlcAuditContract := htlcAuditCmd.String("contract", "", " contract address or hex script")
+
+	switch os.Args[1] {
+	case "createblockchain":
+		{
+			err := createBlockchainCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "printchain":
+		{
+			err := printChainCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "listtransactions":
+		{
+			err := listTransactionsCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "importaddress":
+		{
+			err := importAddressCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "importpubkey":
+		{
+			err := importPubKeyCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "createpsbt":
+		{
+			err := createPSBTCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "signpsbt":
+		{
+			err := signPSBTCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "combinepsbt":
+		{
+			err := combinePSBTCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "finalizepsbt":
+		{
+			err := finalizePSBTCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "createrawtransaction":
+		{
+			err := createRawTxCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "decoderawtransaction":
+		{
+			err := decodeRawTxCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "signrawtransaction":
+		{
+			err := signRawTxCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "sendrawtransaction":
+		{
+			err := sendRawTxCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "getbalance":
+		{
+			err := getBalanceCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "send":
+		{
+			err := sendCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "createwallet":
+		{
+			err := createWalletCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "restorewallet":
+		{
+			err := restoreWalletCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "encryptwallet":
+		{
+			err := encryptWalletCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "walletpassphrase":
+		{
+			err := walletPassphraseCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "walletlock":
+		{
+			err := walletLockCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "dumpprivkey":
+		{
+			err := dumpPrivKeyCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "importprivkey":
+		{
+			err := importPrivKeyCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "dumpwallet":
+		{
+			err := dumpWalletCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "importwallet":
+		{
+			err := importWalletCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "signmessage":
+		{
+			err := signMessageCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "verifymessage":
+		{
+			err := verifyMessageCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "listaddresses":
+		{
+			err := listAddressesCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "createmultisig":
+		{
+			err := createMultiSigCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "createmusig":
+		{
+			err := createMuSigCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "reindex":
+		{
+			err := reindexCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "getsupply":
+		{
+			err := getSupplyCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "startnode":
+		{
+			err := startNodeCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printChain(nodeID)
+				os.Exit(1)
+			}
+		}
+	case "htlc-initiate":
+		{
+			err := htlcInitiateCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "htlc-redeem":
+		{
+			err := htlcRedeemCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "htlc-refund":
+		{
+			err := htlcRefundCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	case "htlc-audit":
+		{
+			err := htlcAuditCmd.Parse(os.Args[2:])
+			if err != nil {
+				cli.printUsage()
+				os.Exit(1)
+			}
+		}
+	default:
+		{
+			cli.printUsage()
+			os.Exit(1)
+		}
+
+	}
+
+	if createBlockchainCmd.Parsed() {
+		if *createBlockchainAddress == "" {
+			createBlockchainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createBlockchain(*createBlockchainAddress, nodeID)
+	}
+
+	if printChainCmd.Parsed() {
+		cli.printChain(nodeID)
+	}
+
+	if getBalanceCmd.Parsed() {
+		if *getBalanceAddress == "" {
+			getBalanceCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBalance(*getBalanceAddress, nodeID)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		cli.listTransactions(*listTransactionsAddress, nodeID)
+	}
+
+	if importAddressCmd.Parsed() {
+		if *importAddress == "" {
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importAddress(*importAddress, nodeID)
+	}
+
+	if importPubKeyCmd.Parsed() {
+		if *importPubKey == "" {
+			importPubKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPubKey(*importPubKey, *importPubKeySchnorr, nodeID)
+	}
+
+	if createPSBTCmd.Parsed() {
+		if *psbtFrom == "" || *psbtTo == "" || *psbtAmount <= 0 || *psbtFee < 0 {
+			createPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		if *psbtLockTime > math.MaxUint32 {
+			createPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createPSBT(*psbtFrom, *psbtTo, nodeID, *psbtAmount, *psbtFee, uint32(*psbtLockTime))
+	}
+
+	if signPSBTCmd.Parsed() {
+		if *signPSBTArg == "" {
+			signPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signPSBT(*signPSBTArg, nodeID)
+	}
+
+	if combinePSBTCmd.Parsed() {
+		if *combinePSBTArg == "" {
+			combinePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.combinePSBT(*combinePSBTArg)
+	}
+
+	if finalizePSBTCmd.Parsed() {
+		if *finalizePSBTArg == "" {
+			finalizePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizePSBT(*finalizePSBTArg)
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxInputs == "" || *createRawTxOutputs == "" || *createRawTxLockTime > math.MaxUint32 {
+			createRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createRawTransaction(*createRawTxInputs, *createRawTxOutputs, uint32(*createRawTxLockTime))
+	}
+
+	if decodeRawTxCmd.Parsed() {
+		if *decodeRawTxHex == "" {
+			decodeRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.decodeRawTransaction(*decodeRawTxHex, *decodeRawTxJSON)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxHex == "" {
+			signRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signRawTransaction(*signRawTxHex, nodeID)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxHex == "" || (*sendRawTxMine && *sendRawTxMiner == "") {
+			sendRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendRawTransaction(*sendRawTxHex, *sendRawTxMiner, nodeID, *sendRawTxMine)
+	}
+
+	if sendCmd.Parsed() {
+		if *senderAddress == "" || *receiverAddress == "" || *amountInt <= 0 || *feeInt < 0 || *lockTime > math.MaxUint32 {
+			sendCmd.Usage()
+			os.Exit(1)
+		}
+		cli.send(*senderAddress, *receiverAddress, nodeID, *sendCoinSelect, *sendPin, *sendExclude, *amountInt, *feeInt, uint32(*lockTime), *sendMine)
+	}
+
+	if startNodeCmd.Parsed() {
+		nodeID = os.Getenv("NODE_ID")
+		if nodeID == "" {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.startNode(nodeID, *startNodeMiner)
+	}
+
+	if createWalletCmd.Parsed() {
+		cli.createWallet(nodeID, *createWalletSchnorr, *createWalletMnemonic)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		cli.restoreWallet(nodeID)
+	}
+
+	if encryptWalletCmd.Parsed() {
+		cli.encryptWallet(nodeID)
+	}
+
+	if walletPassphraseCmd.Parsed() {
+		if *unlockTimeout <= 0 {
+			walletPassphraseCmd.Usage()
+			os.Exit(1)
+		}
+		cli.walletPassphrase(*unlockTimeout, nodeID)
+	}
+
+	if walletLockCmd.Parsed() {
+		cli.walletLock(nodeID)
+	}
+
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(*dumpPrivKeyAddress, nodeID)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKey == "" {
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(*importPrivKey, *importPrivKeySchnorr, *importPrivKeyRescan, nodeID)
+	}
+
+	if dumpWalletCmd.Parsed() {
+		if *dumpWalletFile == "" {
+			dumpWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpWallet(*dumpWalletFile, nodeID)
+	}
+
+	if importWalletCmd.Parsed() {
+		if *importWalletFile == "" {
+			importWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importWallet(*importWalletFile, *importWalletRescan, nodeID)
+	}
+
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMessage(*signMessageAddress, *signMessageText, nodeID)
+	}
+
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyMessage(*verifyMessageAddress, *verifyMessageSignature, *verifyMessageText)
+	}
+
+	if listAddressesCmd.Parsed() {
+		cli.listAddresses(nodeID)
+	}
+
+	if createMultiSigCmd.Parsed() {
+		if *multiSigRequired <= 0 || *multiSigKeys == "" {
+			createMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultiSig(*multiSigRequired, *multiSigKeys, nodeID)
+	}
+
+	if createMuSigCmd.Parsed() {
+		if *muSigKeys == "" {
+			createMuSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMuSig(*muSigKeys, nodeID)
+	}
+
+	if reindexCmd.Parsed() {
+		cli.reindexUTXO(nodeID)
+	}
+
+	if getSupplyCmd.Parsed() {
+		cli.getSupply(nodeID)
+	}
+
+	if htlcInitiateCmd.Parsed() {
+		if *htlcFrom == "" || *htlcTo == "" || *htlcAmount <= 0 || *htlcInitiateFee < 0 || *htlcLockTime == 0 || *htlcLockTime > math.MaxUint32 {
+			htlcInitiateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcInitiate(*htlcFrom, *htlcTo, *htlcSecretHash, nodeID, *htlcAmount, *htlcInitiateFee, uint32(*htlcLockTime), *htlcInitiateMine)
+	}
+
+	if htlcRedeemCmd.Parsed() {
+		if *htlcRedeemContract == "" || *htlcRedeemFee < 0 {
+			htlcRedeemCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcRedeem(*htlcRedeemContract, *htlcSecret, nodeID, *htlcRedeemFee, *htlcRedeemMine)
+	}
+
+	if htlcRefundCmd.Parsed() {
+		if *htlcRefundContract == "" || *htlcRefundFee < 0 {
+			htlcRefundCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcRefund(*htlcRefundContract, nodeID, *htlcRefundFee, *htlcRefundMine)
+	}
+
+	if htlcAuditCmd.Parsed() {
+		if *htlcAuditContract == "" {
+			htlcAuditCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcAudit(*htlcAuditContract, nodeID)
 	}
 
 }
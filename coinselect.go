@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// bnbMaxTries - the branches branch and bound walks before settling for the
+// best selection without change found so far
+const bnbMaxTries = 100000
+
+// defaultCostOfChange - the excess over the target branch and bound leaves to
+// the miner instead of making a change output of it
+const defaultCostOfChange = 1
+
+// Coin - an unspent output that can be selected to fund a transaction
+type Coin struct {
+	TxID  []byte
+	Vout  int
+	Value int
+}
+
+// Outpoint - the coin as txid:vout
+func (c Coin) Outpoint() string {
+	return fmt.Sprintf("%x:%d", c.TxID, c.Vout)
+}
+
+// CoinSelector - picks which coins fund target. When the coins are not
+// enough it returns all of them
+type CoinSelector interface {
+	Select(coins []Coin, target int) []Coin
+}
+
+// NewCoinSelector - the selector known by name: bnb, largest, smallest or
+// random
+func NewCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case "bnb":
+		return BranchAndBoundSelector{CostOfChange: defaultCostOfChange, Fallback: LargestFirstSelector{}}, nil
+	case "largest":
+		return LargestFirstSelector{}, nil
+	case "smallest":
+		return SmallestFirstSelector{}, nil
+	case "random":
+		return RandomImproveSelector{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown coin selection %s", name)
+}
+
+// sumCoins - the value of coins
+func sumCoins(coins []Coin) int {
+	total := 0
+	for _, coin := range coins {
+		total += coin.Value
+	}
+
+	return total
+}
+
+// sortedCoins - a copy of coins sorted by value, largest first unless
+// ascending is set
+func sortedCoins(coins []Coin, ascending bool) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if ascending {
+			return sorted[i].Value < sorted[j].Value
+		}
+		return sorted[i].Value > sorted[j].Value
+	})
+
+	return sorted
+}
+
+// accumulate - takes coins in order until they reach target
+func accumulate(coins []Coin, target int) []Coin {
+	var selected []Coin
+	total := 0
+
+	for _, coin := range coins {
+		if total >= target {
+			break
+		}
+		selected = append(selected, coin)
+		total += coin.Value
+	}
+
+	return selected
+}
+
+// LargestFirstSelector - takes the largest coins first, spending as few
+// inputs as it can
+type LargestFirstSelector struct{}
+
+// Select - see CoinSelector
+func (LargestFirstSelector) Select(coins []Coin, target int) []Coin {
+	return accumulate(sortedCoins(coins, false), target)
+}
+
+// SmallestFirstSelector - takes the smallest coins first, consolidating dust
+// at the cost of more inputs
+type SmallestFirstSelector struct{}
+
+// Select - see CoinSelector
+func (SmallestFirstSelector) Select(coins []Coin, target int) []Coin {
+	return accumulate(sortedCoins(coins, true), target)
+}
+
+// BranchAndBoundSelector - searches for coins adding up to between target
+// and target plus CostOfChange, the least above target it finds. The excess
+// is worth less than a change output would cost to make and spend later, so
+// it goes to the miner and the transaction needs no change output. When there
+// are no such coins, or the search gives up, Fallback selects instead
+type BranchAndBoundSelector struct {
+	CostOfChange int
+	Fallback     CoinSelector
+}
+
+// Select - see CoinSelector
+func (s BranchAndBoundSelector) Select(coins []Coin, target int) []Coin {
+	sorted := sortedCoins(coins, false)
+
+	// remaining[i] - the value of the coins from i on, to prune branches that
+	// cannot reach target any more
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+
+	var selected, best []Coin
+	bestExcess := s.CostOfChange + 1
+	tries := 0
+
+	// search - walks the branches from coin i on, keeping the selection of
+	// least excess. Returns true once the search is over
+	var search func(i, total int) bool
+	search = func(i, total int) bool {
+		tries++
+		if total >= target {
+			if excess := total - target; excess < bestExcess {
+				best = append([]Coin{}, selected...)
+				bestExcess = excess
+			}
+			// adding coins only adds to the excess
+			return bestExcess == 0 || tries > bnbMaxTries
+		}
+		if total+remaining[i] < target || tries > bnbMaxTries {
+			return tries > bnbMaxTries
+		}
+
+		if total+sorted[i].Value <= target+s.CostOfChange {
+			selected = append(selected, sorted[i])
+			done := search(i+1, total+sorted[i].Value)
+			selected = selected[:len(selected)-1]
+			if done {
+				return true
+			}
+		}
+
+		// leaving out a coin of the same value as the one just left out
+		// only repeats that branch
+		next := i + 1
+		for next < len(sorted) && sorted[next].Value == sorted[i].Value {
+			next++
+		}
+		return search(next, total)
+	}
+
+	if target > 0 && s.CostOfChange >= 0 {
+		search(0, 0)
+		if best != nil {
+			return best
+		}
+	}
+	if s.Fallback == nil {
+		return LargestFirstSelector{}.Select(coins, target)
+	}
+
+	return s.Fallback.Select(coins, target)
+}
+
+// avoidsChange - whether selector leaves change of value to the miner rather
+// than making an output of it
+func avoidsChange(selector CoinSelector, change int) bool {
+	bnb, ok := selector.(BranchAndBoundSelector)
+	return ok && change <= bnb.CostOfChange
+}
+
+// RandomImproveSelector - takes random coins until they reach target, then
+// keeps adding random coins while that brings the total closer to twice
+// target without passing three times it. The change comes out about as large
+// as the payment, leaving coins of a useful size for later spends
+type RandomImproveSelector struct{}
+
+// Select - see CoinSelector
+func (RandomImproveSelector) Select(coins []Coin, target int) []Coin {
+	shuffled := append([]Coin{}, coins...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	selected := accumulate(shuffled, target)
+	total := sumCoins(selected)
+	if total < target {
+		return selected
+	}
+
+	ideal := 2 * target
+	for _, coin := range shuffled[len(selected):] {
+		improved := total + coin.Value
+		if improved > 3*target || abs(ideal-improved) >= abs(ideal-total) {
+			break
+		}
+		selected = append(selected, coin)
+		total = improved
+	}
+
+	return selected
+}
+
+// abs - the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// CoinControl - outpoints, as txid:vout, a spend must include or must leave
+// alone whatever the selector picks
+type CoinControl struct {
+	Pinned   map[string]bool
+	Excluded map[string]bool
+}
+
+// ParseCoinControl - the coin control of comma separated txid:vout lists of
+// outpoints to pin and to exclude, either of which may be empty
+func ParseCoinControl(pin, exclude string) (*CoinControl, error) {
+	control := CoinControl{
+		Pinned:   make(map[string]bool),
+		Excluded: make(map[string]bool),
+	}
+
+	for _, list := range []struct {
+		arg string
+		set map[string]bool
+	}{{pin, control.Pinned}, {exclude, control.Excluded}} {
+		if list.arg == "" {
+			continue
+		}
+
+		inputs, err := ParseRawInputs(list.arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, in := range inputs {
+			list.set[fmt.Sprintf("%x:%d", in.Txid, in.Vout)] = true
+		}
+	}
+
+	for outpoint := range control.Pinned {
+		if control.Excluded[outpoint] {
+			return nil, fmt.Errorf("output %s is both pinned and excluded", outpoint)
+		}
+	}
+
+	return &control, nil
+}
+
+// SelectCoins - picks the coins funding amount with selector, or in the
+// order of coins when it is nil. The pinned coins of control go first and
+// its excluded ones are never picked. Returns the value picked and the
+// outputs picked, keyed by hex txid, or an error if a pinned coin is not
+// among coins
+func SelectCoins(coins []Coin, amount int, selector CoinSelector, control *CoinControl) (int, map[string][]int, error) {
+	var pinned, candidates []Coin
+
+	for _, coin := range coins {
+		outpoint := coin.Outpoint()
+		switch {
+		case control != nil && control.Pinned[outpoint]:
+			pinned = append(pinned, coin)
+		case control != nil && control.Excluded[outpoint]:
+		default:
+			candidates = append(candidates, coin)
+		}
+	}
+
+	if control != nil && len(pinned) != len(control.Pinned) {
+		found := make(map[string]bool)
+		for _, coin := range pinned {
+			found[coin.Outpoint()] = true
+		}
+		var missing []string
+		for outpoint := range control.Pinned {
+			if !found[outpoint] {
+				missing = append(missing, outpoint)
+			}
+		}
+		sort.Strings(missing)
+		return 0, nil, fmt.Errorf("pinned outputs %s are not spendable", strings.Join(missing, ","))
+	}
+
+	selected := pinned
+	if rest := amount - sumCoins(pinned); rest > 0 {
+		if selector == nil {
+			selected = append(selected, accumulate(candidates, rest)...)
+		} else {
+			selected = append(selected, selector.Select(candidates, rest)...)
+		}
+	}
+
+	accumulated := 0
+	unspentOutputs := make(map[string][]int)
+	for _, coin := range selected {
+		txID := hex.EncodeToString(coin.TxID)
+		unspentOutputs[txID] = append(unspentOutputs[txID], coin.Vout)
+		accumulated += coin.Value
+	}
+
+	return accumulated, unspentOutputs, nil
+}
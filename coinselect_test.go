@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testCoins - coins of values, each with its own txid
+func testCoins(values ...int) []Coin {
+	var coins []Coin
+	for i, value := range values {
+		coins = append(coins, Coin{TxID: bytes.Repeat([]byte{byte(i + 1)}, 32), Vout: 0, Value: value})
+	}
+
+	return coins
+}
+
+func TestBranchAndBoundSelector(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []int
+		target       int
+		costOfChange int
+		want         int
+	}{
+		{"exact match", []int{5, 4, 3, 1}, 7, 1, 7},
+		{"within cost of change", []int{11, 3}, 10, 1, 11},
+		{"least excess in window", []int{9, 6, 5}, 10, 2, 11},
+		{"excess only in window", []int{8, 4}, 11, 2, 12},
+		// nothing lands in the window, so largest first takes 20 and makes change
+		{"fallback", []int{20, 3}, 10, 1, 20},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector := BranchAndBoundSelector{CostOfChange: test.costOfChange, Fallback: LargestFirstSelector{}}
+			total := sumCoins(selector.Select(testCoins(test.values...), test.target))
+			if total != test.want {
+				t.Fatalf("selected %d, want %d", total, test.want)
+			}
+		})
+	}
+}
+
+func TestSelectCoinsMissingPinned(t *testing.T) {
+	coins := testCoins(5, 3)
+	control, err := ParseCoinControl(strings.Repeat("09", 32)+":0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = SelectCoins(coins, 4, LargestFirstSelector{}, control)
+	if err == nil {
+		t.Fatal("spend pinning a missing output selected coins")
+	}
+
+	control, err = ParseCoinControl(coins[1].Outpoint(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, outputs, err := SelectCoins(coins, 4, LargestFirstSelector{}, control)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 8 || len(outputs) != 2 {
+		t.Fatalf("selected %d from %d transactions, want 8 from 2", total, len(outputs))
+	}
+}
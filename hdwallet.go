@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// HardenedKeyStart - child indexes from here on are hardened: they are
+// derived from the parent private key, so a child private key leaking along
+// with the parent public key does not give away the other children
+const HardenedKeyStart = uint32(0x80000000)
+
+const (
+	// hdAccountPath - the chain the addresses of the wallet are derived on,
+	// the external chain of the first account as in BIP44
+	hdAccountPath = "m/44'/0'/0'/0"
+	// hdGapLimit - unused addresses in a row after which a scan stops
+	hdGapLimit = 20
+	// hdEntropyBits - the entropy of a new mnemonic, 12 words
+	hdEntropyBits = 128
+)
+
+// errInvalidChild - the few indexes that give no valid key are skipped
+var errInvalidChild = errors.New("index gives no valid key")
+
+// ExtendedKey - a BIP32 private key along with the chain code its children
+// are derived with
+type ExtendedKey struct {
+	Key       []byte
+	ChainCode []byte
+	Depth     byte
+	Index     uint32
+}
+
+// NewMasterKey - the root key of seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("seed of %d bytes is not between 16 and 64", len(seed))
+	}
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	var d secp256k1.ModNScalar
+	if d.SetByteSlice(sum[:32]) || d.IsZero() {
+		return nil, errors.New("seed gives no valid master key")
+	}
+
+	return &ExtendedKey{Key: sum[:32], ChainCode: sum[32:]}, nil
+}
+
+// Child - the child key at index
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	var d secp256k1.ModNScalar
+	d.SetByteSlice(k.Key)
+
+	var data []byte
+	if index >= HardenedKeyStart {
+		data = append([]byte{0x00}, k.Key...)
+	} else {
+		data = secp256k1.NewPrivateKey(&d).PubKey().SerializeCompressed()
+	}
+	indexBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexBytes, index)
+	data = append(data, indexBytes...)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	// the child key is the parent key tweaked by the left half
+	var child secp256k1.ModNScalar
+	if child.SetByteSlice(sum[:32]) {
+		return nil, errInvalidChild
+	}
+	child.Add(&d)
+	if child.IsZero() {
+		return nil, errInvalidChild
+	}
+	key := child.Bytes()
+
+	return &ExtendedKey{
+		Key:       key[:],
+		ChainCode: sum[32:],
+		Depth:     k.Depth + 1,
+		Index:     index,
+	}, nil
+}
+
+// Derive - the key at path, such as m/44'/0'/0'/0/1, below the master key.
+// A ' or h after an index hardens it
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" || k.Depth != 0 {
+		return nil, fmt.Errorf("path %s does not start at the master key", path)
+	}
+
+	key := k
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+			offset = HardenedKeyStart
+			part = part[:len(part)-1]
+		}
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedKeyStart {
+			return nil, fmt.Errorf("path %s has a bad index %s", path, part)
+		}
+
+		key, err = key.Child(uint32(index) + offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Wallet - the wallet holding the key
+func (k *ExtendedKey) Wallet() *Wallet {
+	privKey := secp256k1.PrivKeyFromBytes(k.Key).ToECDSA()
+
+	return &Wallet{
+		PrivateKey: *privKey,
+		PublicKey:  pubKeyBytes(&privKey.PublicKey),
+	}
+}
+
+// NewSeed - gives the wallets a seed made from a new mnemonic, which is
+// returned to be written down. The mnemonic and passphrase alone bring back
+// every address later derived from the seed
+func (ws *Wallets) NewSeed(passphrase string) (string, error) {
+	entropy, err := bip39.NewEntropy(hdEntropyBits)
+	if err != nil {
+		return "", err
+	}
+
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return "", err
+	}
+
+	return mnemonic, ws.SetSeed(mnemonic, passphrase)
+}
+
+// SetSeed - gives the wallets the seed of an existing mnemonic
+func (ws *Wallets) SetSeed(mnemonic, passphrase string) error {
+	if ws.IsLocked() {
+		return ErrWalletLocked
+	}
+	if ws.Seed != nil {
+		return errors.New("wallet already has a seed")
+	}
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+
+	_, err = NewMasterKey(seed)
+	if err != nil {
+		return err
+	}
+
+	ws.Seed = seed
+	ws.NextIndex = 0
+	return nil
+}
+
+// deriveWallet - the wallet at index on the account chain, and its path
+func (ws *Wallets) deriveWallet(index uint32) (*Wallet, string, error) {
+	master, err := NewMasterKey(ws.Seed)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path := fmt.Sprintf("%s/%d", hdAccountPath, index)
+	key, err := master.Derive(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key.Wallet(), path, nil
+}
+
+// nextHDWallet - the wallet at the next index not handed out yet
+func (ws *Wallets) nextHDWallet() (*Wallet, string) {
+	for {
+		index := ws.NextIndex
+		ws.NextIndex++
+
+		wallet, path, err := ws.deriveWallet(index)
+		if err == errInvalidChild {
+			continue
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+
+		return wallet, path
+	}
+}
+
+// ScanHD - rediscovers the addresses of the seed that have been paid on bc.
+// Keys are derived in turn until hdGapLimit of them in a row were never paid
+// to either of their addresses, and the ones that were are added with their
+// paths. Returns the number of keys found
+func (ws *Wallets) ScanHD(bc *Blockchain) int {
+	paid := bc.FindPaidScripts()
+	found := 0
+	gap := 0
+
+	for index := uint32(0); gap < hdGapLimit; index++ {
+		wallet, path, err := ws.deriveWallet(index)
+		if err == errInvalidChild {
+			continue
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+
+		used := false
+		for _, schnorr := range []bool{false, true} {
+			form := *wallet
+			form.Schnorr = schnorr
+			address := fmt.Sprintf("%s", form.GetAddress())
+
+			if paid[hex.EncodeToString(AddressScript(address))] {
+				ws.Wallets[address] = &form
+				ws.Paths[address] = path
+				used = true
+			}
+		}
+
+		if !used {
+			gap++
+			continue
+		}
+
+		found++
+		gap = 0
+		if index >= ws.NextIndex {
+			ws.NextIndex = index + 1
+		}
+	}
+
+	return found
+}
+
+// FindPaidScripts - the hex locking scripts of every output on the chain,
+// spent or not
+func (bc *Blockchain) FindPaidScripts() map[string]bool {
+	paid := make(map[string]bool)
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Vout {
+				paid[hex.EncodeToString(out.ScriptPubKey)] = true
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return paid
+}
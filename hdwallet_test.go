@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestDeriveBIP32(t *testing.T) {
+	tests := []struct {
+		seed     string
+		path     string
+		key      string
+		chain    string
+		depth    byte
+		hardened bool
+	}{
+		// test vector 1
+		{"000102030405060708090a0b0c0d0e0f", "m",
+			"e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35", "873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508", 0, false},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'",
+			"edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea", "47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141", 1, true},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1",
+			"3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368", "2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19", 2, false},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'",
+			"cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca", "04466b9cc8e161e966409ca52986c584f07e9dc81f735db683c3ff6ec7b1503f", 3, true},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2",
+			"0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4", "cfb71883f01676f587d023cc53a35bc7f88f724b1f8c2892ac1275ac822a3edd", 4, false},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2/1000000000",
+			"471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8", "c783e67b921d2beb8f6b389cc646d7263b4145701dadd2161548a8b078e65e9e", 5, false},
+		// test vector 2
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m",
+			"4b03d6fc340455b363f51020ad3ecca4f0850280cf436c70c727923f6db46c3e", "60499f801b896d83179a4374aeb7822aaeaceaa0db1f85ee3e904c4defbd9689", 0, false},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0",
+			"abe74a98f6c7eabee0428f53798f0ab8aa1bd37873999041703c742f15ac7e1e", "f0909affaa7ee7abe5dd4e100598d4dc53cd709d5a5c2cac40e7412f232f7c9c", 1, false},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647h",
+			"877c779ad9687164e9c2f4f0f4ff0340814392330693ce95a58fe18fd52e6e93", "be17a268474a6bb9c61e1d720cf6215e2a88c5406c4aee7b38547f585c9a37d9", 2, true},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647h/1/2147483646h/2",
+			"bb7d39bdb83ecf58f2fd82b6d918341cbef428661ef01ab97c28a4842125ac23", "9452b549be8cea3ecb7a84bec10dcfd94afe4d129ebfd3b3cb58eedf394ed271", 5, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			master, err := NewMasterKey(mustHex(t, test.seed))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := master.Derive(test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(key.Key, mustHex(t, test.key)) {
+				t.Errorf("key %x, want %s", key.Key, test.key)
+			}
+			if !bytes.Equal(key.ChainCode, mustHex(t, test.chain)) {
+				t.Errorf("chain code %x, want %s", key.ChainCode, test.chain)
+			}
+			if key.Depth != test.depth || (key.Index >= HardenedKeyStart) != test.hardened {
+				t.Errorf("depth %d index %d", key.Depth, key.Index)
+			}
+		})
+	}
+}
+
+func TestDeriveBadPath(t *testing.T) {
+	master, err := NewMasterKey(mustHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"", "x/1", "M/1", "m/", "m/a", "m/1/", "m/-1", "m/2147483648", "m/1''", "m/0x1"} {
+		t.Run(path, func(t *testing.T) {
+			_, err := master.Derive(path)
+			if err == nil {
+				t.Fatalf("derived %q", path)
+			}
+		})
+	}
+
+	child, err := master.Derive("m/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = child.Derive("m/1")
+	if err == nil {
+		t.Fatal("derived from below the master key")
+	}
+
+	for _, size := range []int{15, 65} {
+		_, err := NewMasterKey(make([]byte, size))
+		if err == nil {
+			t.Fatalf("master key of a %d byte seed", size)
+		}
+	}
+}
+
+func TestSetSeedBIP39(t *testing.T) {
+	// the vectors of BIP39, all with the passphrase TREZOR
+	tests := []struct {
+		mnemonic string
+		seed     string
+	}{
+		{"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"},
+		{"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607"},
+		{"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+			"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8"},
+		{"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069"},
+		{"legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal will",
+			"f2b94508732bcbacbcc020faefecfc89feafa6649a5491b8c952cede496c214a0c7b3c392d168748f2d4a612bada0753b52a1c7ac53c1e93abd5c6320b9e95dd"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mnemonic, func(t *testing.T) {
+			ws := &Wallets{}
+			err := ws.SetSeed(test.mnemonic, "TREZOR")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(ws.Seed, mustHex(t, test.seed)) {
+				t.Fatalf("seed %x, want %s", ws.Seed, test.seed)
+			}
+		})
+	}
+
+	bad := []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon mblah",
+	}
+	for _, mnemonic := range bad {
+		ws := &Wallets{}
+		if ws.SetSeed(mnemonic, "") == nil {
+			t.Errorf("seed of %q", mnemonic)
+		}
+	}
+
+	ws := &Wallets{}
+	err := ws.SetSeed(tests[0].mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.SetSeed(tests[1].mnemonic, "") == nil {
+		t.Fatal("seed replaced")
+	}
+}
+
+func TestCreateWalletFromSeed(t *testing.T) {
+	// newSeeded - wallets given the seed of mnemonic
+	newSeeded := func(mnemonic, passphrase string) *Wallets {
+		ws, _ := NewWallets("hdnone")
+		err := ws.SetSeed(mnemonic, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+
+	ws, _ := NewWallets("hdnone")
+	mnemonic, err := ws.NewSeed("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var addresses []string
+	for i := 0; i < 4; i++ {
+		address := ws.CreateWallet(i == 2)
+		if path := fmt.Sprintf("m/44'/0'/0'/0/%d", i); ws.Paths[address] != path {
+			t.Fatalf("path %s, want %s", ws.Paths[address], path)
+		}
+		addresses = append(addresses, address)
+	}
+
+	restored := newSeeded(mnemonic, "pw")
+	for i, address := range addresses {
+		if got := restored.CreateWallet(i == 2); got != address {
+			t.Fatalf("restored address %d is %s, want %s", i, got, address)
+		}
+	}
+
+	other := newSeeded(mnemonic, "other")
+	if other.CreateWallet(false) == addresses[0] {
+		t.Fatal("passphrase did not change the seed")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// WalletTx - what one transaction on the chain did to one address. Amount is
+// the change it made to the balance of the address: what it paid the address
+// less what it spent from it, so a send counts its fee and leaves out change
+type WalletTx struct {
+	TxID     []byte
+	Address  string
+	Category string
+	Amount   int
+	Height   int
+	Time     int64
+}
+
+// FindHistory - the transactions of the chain paying or spending from
+// addresses, oldest first. It goes through every block rather than the UTXO
+// set, so spent outputs are counted as well
+func (bc *Blockchain) FindHistory(addresses []string) []WalletTx {
+	byScript := make(map[string]string)
+	var unique []string
+	for _, address := range addresses {
+		script := hex.EncodeToString(AddressScript(address))
+		if _, ok := byScript[script]; !ok {
+			byScript[script] = address
+			unique = append(unique, address)
+		}
+	}
+
+	var blocks []*Block
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	// outputs paying the addresses, to tell whose coins an input spends
+	owned := make(map[string]TXOutput)
+	var history []WalletTx
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+
+		for _, tx := range block.Transactions {
+			received := make(map[string]int)
+			spent := make(map[string]int)
+
+			if !tx.IsCoinbase() {
+				for _, vin := range tx.Vin {
+					outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+					if out, ok := owned[outpoint]; ok {
+						spent[byScript[hex.EncodeToString(out.ScriptPubKey)]] += out.Value
+						delete(owned, outpoint)
+					}
+				}
+			}
+
+			for outIdx, out := range tx.Vout {
+				if address, ok := byScript[hex.EncodeToString(out.ScriptPubKey)]; ok {
+					received[address] += out.Value
+					owned[fmt.Sprintf("%x:%d", tx.ID, outIdx)] = out
+				}
+			}
+
+			for _, address := range unique {
+				if received[address] == 0 && spent[address] == 0 {
+					continue
+				}
+
+				category := "receive"
+				if tx.IsCoinbase() {
+					category = "generate"
+				} else if spent[address] > 0 {
+					category = "send"
+				}
+
+				history = append(history, WalletTx{
+					TxID:     tx.ID,
+					Address:  address,
+					Category: category,
+					Amount:   received[address] - spent[address],
+					Height:   block.Height,
+					Time:     block.Timestamp,
+				})
+			}
+		}
+	}
+
+	return history
+}
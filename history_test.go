@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+)
+
+func TestWatchOnly(t *testing.T) {
+	wallets := Wallets{Wallets: make(map[string]*Wallet), WatchOnly: make(map[string][]byte)}
+	wallet := NewWallet()
+	schnorr := NewSchnorrWallet()
+	other := string(NewWallet().GetAddress())
+
+	address, err := wallets.AddWatchPubKey(wallet.PublicKey, false)
+	if err != nil || address != string(wallet.GetAddress()) {
+		t.Fatalf("public key watched as %s, want %s: %v", address, wallet.GetAddress(), err)
+	}
+	address, err = wallets.AddWatchPubKey(schnorr.PublicKey, true)
+	if err != nil || address != string(schnorr.GetAddress()) {
+		t.Fatalf("schnorr public key watched as %s, want %s: %v", address, schnorr.GetAddress(), err)
+	}
+	if err := wallets.AddWatchAddress(other); err != nil {
+		t.Fatal(err)
+	}
+	// watching an address again keeps the public key it is known by
+	if err := wallets.AddWatchAddress(string(wallet.GetAddress())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wallets.WatchOnly[string(wallet.GetAddress())], wallet.PublicKey) {
+		t.Fatal("public key of a watched address forgotten")
+	}
+
+	for _, address := range []string{string(wallet.GetAddress()), string(schnorr.GetAddress()), other} {
+		if !wallets.IsWatchOnly(address) {
+			t.Fatalf("%s not watched", address)
+		}
+	}
+	if wallets.IsWatchOnly(string(NewWallet().GetAddress())) {
+		t.Fatal("address never added is watched")
+	}
+	if addresses := wallets.GetAddresses(); len(addresses) != 3 {
+		t.Fatalf("wallet lists %d addresses, want 3", len(addresses))
+	}
+
+	p256Key := testPrivKey(t, elliptic.P256(), "1")
+	if _, err := wallets.AddWatchPubKey(pubKeyBytes(&p256Key.PublicKey), true); err == nil {
+		t.Fatal("P-256 key watched as a schnorr key")
+	}
+	if _, err := wallets.AddWatchPubKey(wallet.PublicKey[1:], false); err == nil {
+		t.Fatal("malformed public key watched")
+	}
+	if err := wallets.AddWatchAddress(other[1:]); err == nil {
+		t.Fatal("malformed address watched")
+	}
+}
+
+func TestFindHistory(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	watched := NewWallet()
+	watchedAddress := string(watched.GetAddress())
+	payee := string(NewWallet().GetAddress())
+	miner := string(NewWallet().GetAddress())
+
+	bc := testChain(t, wallet)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisTx := genesis.Transactions[0]
+	subsidy := genesisTx.Vout[0].Value
+
+	// the wallet pays 3 to the watched address, keeping the change less a
+	// fee of 1
+	pay := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: genesisTx.ID, Vout: 0, Sequence: MaxTxInSequenceNum}},
+		Vout:    []TXOutput{*NewTXOutput(3, watchedAddress), *NewTXOutput(subsidy-4, address)},
+	}
+	signTx(t, wallet, pay, genesisTx.Vout[0])
+	first, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(miner, "", 1, 1), pay})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the watched address passes 2 of it on, paying a fee of 1
+	forward := spendTx(t, watched, pay, 0, payee, 2)
+	second, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(miner, "", 2, 1), forward})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []WalletTx{
+		{genesisTx.ID, address, "generate", subsidy, 0, genesis.Timestamp},
+		{pay.ID, address, "send", -4, 1, first.Timestamp},
+		{pay.ID, watchedAddress, "receive", 3, 1, first.Timestamp},
+		{forward.ID, watchedAddress, "send", -3, 2, second.Timestamp},
+		{forward.ID, payee, "receive", 2, 2, second.Timestamp},
+	}
+
+	history := bc.FindHistory([]string{address, watchedAddress, payee, address})
+	if len(history) != len(want) {
+		t.Fatalf("history of %d entries, want %d: %+v", len(history), len(want), history)
+	}
+	for i, wtx := range history {
+		w := want[i]
+		if !bytes.Equal(wtx.TxID, w.TxID) || wtx.Address != w.Address || wtx.Category != w.Category ||
+			wtx.Amount != w.Amount || wtx.Height != w.Height || wtx.Time != w.Time {
+			t.Fatalf("entry %d is %+v, want %+v", i, wtx, w)
+		}
+	}
+
+	if history := bc.FindHistory([]string{miner}); len(history) != 2 || history[0].Category != "generate" {
+		t.Fatalf("miner history %+v, want two generated coins", history)
+	}
+	if history := bc.FindHistory(nil); len(history) != 0 {
+		t.Fatalf("history of no addresses %+v", history)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// NewHTLCRedeemTransaction - create a transaction taking everything locked in
+// contract, less fee, to the recipient of the contract by revealing secret
+func NewHTLCRedeemTransaction(contract, secret []byte, wallet *Wallet, fee int, UTXOSet *UTXOSet) *Transaction {
+	htlc, err := ExtractHTLC(contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !htlc.IsSecret(secret) {
+		log.Panic("ERROR: Secret does not match the contract")
+	}
+
+	to := fmt.Sprintf("%s", encodeAddress(version, htlc.RecipientHash))
+	tx := newContractSpend(contract, to, fee, 0, UTXOSet)
+
+	scriptSig := NewScriptBuilder().AddData(secret).AddInt64(1).AddData(contract).Script()
+	for i := range tx.Vin {
+		tx.Vin[i].ScriptSig = scriptSig
+	}
+	UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// NewHTLCRefundTransaction - create a transaction taking everything locked in
+// contract, less fee, back to the refund address of the contract. It cannot
+// be mined before the lock time of the contract
+func NewHTLCRefundTransaction(contract []byte, wallet *Wallet, fee int, UTXOSet *UTXOSet) *Transaction {
+	htlc, err := ExtractHTLC(contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	to := fmt.Sprintf("%s", encodeAddress(version, htlc.RefundHash))
+	tx := newContractSpend(contract, to, fee, htlc.LockTime, UTXOSet)
+
+	scriptSig := NewScriptBuilder().AddInt64(0).AddData(contract).Script()
+	for i := range tx.Vin {
+		tx.Vin[i].ScriptSig = scriptSig
+	}
+	UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// newContractSpend - build an unsigned transaction sending all the outputs
+// locked to contract, less fee, to address
+func newContractSpend(contract []byte, to string, fee int, lockTime uint32, UTXOSet *UTXOSet) *Transaction {
+	var inputs []TXInput
+
+	lockingScript := PayToScriptHashScript(HashPubKey(contract))
+	acc, validOutputs, err := UTXOSet.FindSpendableOutputs(lockingScript, math.MaxInt32)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if acc == 0 {
+		log.Panic("ERROR: Nothing is locked in the contract")
+	}
+	if acc <= fee {
+		log.Panic("ERROR: Contract holds less than the fee")
+	}
+
+	// the lock time is only enforced while an input is not final
+	sequence := MaxTxInSequenceNum
+	if lockTime != 0 {
+		sequence = MaxTxInSequenceNum - 1
+	}
+
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		for _, out := range outs {
+			inputs = append(inputs, TXInput{
+				Txid:      txID,
+				Vout:      out,
+				ScriptSig: nil,
+				Sequence:  sequence,
+			})
+		}
+	}
+
+	tx := Transaction{
+		ID:       nil,
+		Version:  txVersion,
+		Vin:      inputs,
+		Vout:     []TXOutput{*NewTXOutput(acc-fee, to)},
+		LockTime: lockTime,
+	}
+	tx.SetID()
+
+	return &tx
+}
+
+// signHTLCInput - signs an input spending a hash time-locked contract. The
+// unlocking script must already hold the branch being taken and end with the
+// contract; the signature and the public key go in front of them
+func (tx *Transaction) signHTLCInput(privKey ecdsa.PrivateKey, inputIndex int, prevOutput TXOutput, hashType SigHashType) error {
+	ops, err := parseScript(tx.Vin[inputIndex].ScriptSig)
+	if err != nil || len(ops) < 2 {
+		return errors.New("input has no contract branch to sign for")
+	}
+
+	contract := ops[len(ops)-1].data
+	if !bytes.Equal(HashPubKey(contract), ExtractScriptHash(prevOutput.ScriptPubKey)) {
+		return errors.New("contract does not match the output being spent")
+	}
+
+	htlc, err := ExtractHTLC(contract)
+	if err != nil {
+		return err
+	}
+
+	// the branch is picked by the push just before the contract
+	keyHash := htlc.RefundHash
+	if ops[len(ops)-2].opcode != Op0 {
+		keyHash = htlc.RecipientHash
+	}
+
+	pubKey := pubKeyBytes(&privKey.PublicKey)
+	if !bytes.Equal(HashPubKey(pubKey), keyHash) {
+		return errors.New("key does not match the contract branch being taken")
+	}
+
+	scriptCode := TXOutput{Value: prevOutput.Value, ScriptPubKey: contract}
+	signature, err := tx.signature(privKey, inputIndex, scriptCode, hashType)
+	if err != nil {
+		return err
+	}
+
+	unlock := pubKeyHashScriptSig(signature, pubKey)
+	tx.Vin[inputIndex].ScriptSig = append(unlock, tx.Vin[inputIndex].ScriptSig...)
+
+	return nil
+}
+
+// FindHTLCSecret - looks through the chain for a spend of contract that
+// revealed its secret, returning nil if there is none
+func (bc *Blockchain) FindHTLCSecret(contract []byte) []byte {
+	htlc, err := ExtractHTLC(contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+
+			for _, vin := range tx.Vin {
+				ops, err := parseScript(vin.ScriptSig)
+				if err != nil || len(ops) == 0 || !bytes.Equal(ops[len(ops)-1].data, contract) {
+					continue
+				}
+
+				for _, op := range ops {
+					if op.data != nil && htlc.IsSecret(op.data) {
+						return op.data
+					}
+				}
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil
+}
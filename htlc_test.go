@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestHTLCScriptRoundTrip(t *testing.T) {
+	secretHash := sha256.Sum256(bytes.Repeat([]byte{7}, htlcSecretSize))
+
+	for _, lockTime := range []uint32{1, 16, 17, 1000, lockTimeThreshold + 1, 0xffffffff} {
+		htlc := HTLC{
+			RecipientHash: bytes.Repeat([]byte{1}, 20),
+			RefundHash:    bytes.Repeat([]byte{2}, 20),
+			SecretHash:    secretHash[:],
+			LockTime:      lockTime,
+		}
+
+		script, err := HTLCScript(&htlc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extracted, err := ExtractHTLC(script)
+		if err != nil {
+			t.Fatalf("lock time %d: %v", lockTime, err)
+		}
+		if !reflect.DeepEqual(*extracted, htlc) {
+			t.Fatalf("contract extracts as %+v, want %+v", *extracted, htlc)
+		}
+	}
+
+	bad := []HTLC{
+		{RecipientHash: make([]byte, 19), RefundHash: make([]byte, 20), SecretHash: secretHash[:], LockTime: 1},
+		{RecipientHash: make([]byte, 20), RefundHash: make([]byte, 20), SecretHash: secretHash[1:], LockTime: 1},
+		{RecipientHash: make([]byte, 20), RefundHash: make([]byte, 20), SecretHash: secretHash[:]},
+	}
+	for _, htlc := range bad {
+		if _, err := HTLCScript(&htlc); err == nil {
+			t.Errorf("contract %+v made", htlc)
+		}
+	}
+
+	if _, err := ExtractHTLC(PayToPubKeyHashScript(make([]byte, 20))); err == nil {
+		t.Fatal("pay to pubkey hash script extracted as a contract")
+	}
+}
+
+func TestVerifyHTLC(t *testing.T) {
+	recipient := NewWallet()
+	refunder := NewWallet()
+	secret := bytes.Repeat([]byte{7}, htlcSecretSize)
+	secretHash := sha256.Sum256(secret)
+	const lockTime = 100
+
+	htlc := HTLC{
+		RecipientHash: HashPubKey(recipient.PublicKey),
+		RefundHash:    HashPubKey(refunder.PublicKey),
+		SecretHash:    secretHash[:],
+		LockTime:      lockTime,
+	}
+	if !htlc.IsSecret(secret) || htlc.IsSecret(secret[1:]) {
+		t.Fatal("secret not told apart")
+	}
+	contract, err := HTLCScript(&htlc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := PayToScriptHashScript(HashPubKey(contract))
+
+	redeem := func(secret []byte) [][]byte { return [][]byte{secret, {1}, contract} }
+	refund := [][]byte{nil, contract}
+
+	// signed - an unlocking script of the signature and key of wallet in
+	// front of the branch pushes
+	signed := func(wallet *Wallet, branch [][]byte) func(tx *Transaction, prevOut TXOutput) []byte {
+		return signedBy(t, wallet, contract, append([][]byte{wallet.PublicKey}, branch...)...)
+	}
+	// signInput - the unlocking script SignInput makes of the branch pushes
+	signInput := func(wallet *Wallet, branch [][]byte) func(tx *Transaction, prevOut TXOutput) []byte {
+		return func(tx *Transaction, prevOut TXOutput) []byte {
+			tx.Vin[0].ScriptSig = pushes(branch...)(tx, prevOut)
+			err := tx.SignInput(wallet.PrivateKey, 0, prevOut, SigHashAll)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tx.Vin[0].ScriptSig
+		}
+	}
+
+	tests := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		unlock   func(tx *Transaction, prevOut TXOutput) []byte
+		valid    bool
+	}{
+		{"redeem", 0, MaxTxInSequenceNum, signed(recipient, redeem(secret)), true},
+		{"redeem signed by SignInput", 0, MaxTxInSequenceNum, signInput(recipient, redeem(secret)), true},
+		{"redeem with a wrong secret", 0, MaxTxInSequenceNum, signed(recipient, redeem(bytes.Repeat([]byte{8}, htlcSecretSize))), false},
+		{"redeem with a short secret", 0, MaxTxInSequenceNum, signed(recipient, redeem(secret[1:])), false},
+		{"redeem by the refund key", 0, MaxTxInSequenceNum, signed(refunder, redeem(secret)), false},
+		{"refund", lockTime, 0, signed(refunder, refund), true},
+		{"refund signed by SignInput", lockTime, MaxTxInSequenceNum - 1, signInput(refunder, refund), true},
+		{"refund before the lock time", lockTime - 1, 0, signed(refunder, refund), false},
+		{"refund with a final input", lockTime, MaxTxInSequenceNum, signed(refunder, refund), false},
+		{"refund by the recipient", lockTime, 0, signed(recipient, refund), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, prevTXs := spendScript(lock, func(tx *Transaction) {
+				tx.LockTime = test.lockTime
+				tx.Vin[0].Sequence = test.sequence
+			}, test.unlock)
+
+			if tx.Verify(prevTXs) != test.valid {
+				t.Fatalf("Verify = %v, want %v", !test.valid, test.valid)
+			}
+		})
+	}
+
+	tx, _ := spendScript(lock, nil, pushes(redeem(secret)...))
+	if tx.SignInput(refunder.PrivateKey, 0, TXOutput{Value: 10, ScriptPubKey: lock}, SigHashAll) == nil {
+		t.Fatal("refund key signed the redeem branch")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+)
+
+// input sequence numbers. An input whose sequence has the disable flag clear
+// may only be spent once the output it spends is old enough: the low bits
+// count blocks or, with the seconds flag set, units of 512 seconds
+const (
+	// MaxTxInSequenceNum - the sequence of an input that takes no part in
+	// lock times. The lock time of a transaction whose inputs all have it
+	// is not enforced
+	MaxTxInSequenceNum uint32 = 0xffffffff
+	// SequenceLockTimeDisabled - set when the input has no relative lock
+	SequenceLockTimeDisabled uint32 = 1 << 31
+	// SequenceLockTimeIsSeconds - set when the relative lock counts time
+	SequenceLockTimeIsSeconds uint32 = 1 << 22
+	// SequenceLockTimeMask - the relative lock itself
+	SequenceLockTimeMask uint32 = 0x0000ffff
+	// SequenceLockTimeGranularity - time based relative locks count units
+	// of 2^9 = 512 seconds
+	SequenceLockTimeGranularity = 9
+
+	// lockTimeThreshold - lock times below this are heights, above are unix times
+	lockTimeThreshold = 500000000
+)
+
+// IsFinalTx - checks whether tx may go in a block at height whose parent has
+// the median time past medianTime. A lock time below lockTimeThreshold is a
+// block height, anything above is a unix time, and either way the
+// transaction is final once it has passed
+func IsFinalTx(tx *Transaction, height int, medianTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	lockTime := int64(tx.LockTime)
+	if lockTime < lockTimeThreshold {
+		if lockTime < int64(height) {
+			return true
+		}
+	} else if lockTime < medianTime {
+		return true
+	}
+
+	for _, vin := range tx.Vin {
+		if vin.Sequence != MaxTxInSequenceNum {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkSequenceLock - checks the relative lock of input inputIndex, which
+// spends outputs created in a block at coinHeight whose parent has the median
+// time past coinTime, for a spend in a block at height whose parent has the
+// median time past medianTime
+func checkSequenceLock(tx *Transaction, inputIndex int, coinHeight int, coinTime int64, height int, medianTime int64) error {
+	sequence := tx.Vin[inputIndex].Sequence
+	if tx.IsCoinbase() || sequence&SequenceLockTimeDisabled != 0 {
+		return nil
+	}
+
+	relativeLock := int64(sequence & SequenceLockTimeMask)
+
+	if sequence&SequenceLockTimeIsSeconds != 0 {
+		minTime := coinTime + relativeLock<<SequenceLockTimeGranularity
+		if medianTime < minTime {
+			str := fmt.Sprintf("transaction %x input %d is locked until time %d", tx.ID, inputIndex, minTime)
+			return ruleError(ErrSequenceLockNotMet, str)
+		}
+	} else {
+		minHeight := int64(coinHeight) + relativeLock
+		if int64(height) < minHeight {
+			str := fmt.Sprintf("transaction %x input %d is locked until height %d", tx.ID, inputIndex, minHeight)
+			return ruleError(ErrSequenceLockNotMet, str)
+		}
+	}
+
+	return nil
+}
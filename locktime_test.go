@@ -0,0 +1,141 @@
+package main
+
+import "testing"
+
+func TestIsFinalTx(t *testing.T) {
+	const lockTime = lockTimeThreshold + 1000
+
+	tests := []struct {
+		name       string
+		lockTime   uint32
+		sequence   uint32
+		height     int
+		medianTime int64
+		final      bool
+	}{
+		{"no lock time", 0, 0, 1, 0, true},
+		{"before height", 10, 0, 9, lockTime, false},
+		{"at height", 10, 0, 10, lockTime, false},
+		{"past height", 10, 0, 11, 0, true},
+		{"last height lock", lockTimeThreshold - 1, 0, lockTimeThreshold, 0, true},
+		{"before time", lockTime, 0, 1000, lockTime - 1, false},
+		{"at time", lockTime, 0, 1000, lockTime, false},
+		{"past time", lockTime, 0, 1, lockTime + 1, true},
+		// time locks go by the median time past, whatever the height
+		{"time lock past height", lockTime, 0, lockTime + 1, 0, false},
+		{"final inputs before height", 10, MaxTxInSequenceNum, 1, 0, true},
+		{"final inputs before time", lockTime, MaxTxInSequenceNum, 1, 0, true},
+		{"input with a relative lock before height", 10, 5, 1, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{
+				Version: txVersion,
+				Vin: []TXInput{
+					{Txid: []byte{1}, Vout: 0, Sequence: MaxTxInSequenceNum},
+					{Txid: []byte{1}, Vout: 1, Sequence: test.sequence},
+				},
+				LockTime: test.lockTime,
+			}
+
+			if IsFinalTx(tx, test.height, test.medianTime) != test.final {
+				t.Fatalf("IsFinalTx = %v, want %v", !test.final, test.final)
+			}
+		})
+	}
+}
+
+func TestCheckSequenceLock(t *testing.T) {
+	const (
+		coinHeight = 100
+		coinTime   = 1000000
+	)
+
+	tests := []struct {
+		name       string
+		sequence   uint32
+		height     int
+		medianTime int64
+		locked     bool
+	}{
+		{"final", MaxTxInSequenceNum, coinHeight, coinTime, false},
+		{"disabled", SequenceLockTimeDisabled | 10, coinHeight, coinTime, false},
+		{"no blocks", 0, coinHeight, coinTime, false},
+		{"before height", 10, coinHeight + 9, coinTime, true},
+		{"at height", 10, coinHeight + 10, coinTime, false},
+		{"only the low bits count", 1<<16 | 10, coinHeight + 10, coinTime, false},
+		{"before time", SequenceLockTimeIsSeconds | 2, coinHeight + 1000, coinTime + 2<<SequenceLockTimeGranularity - 1, true},
+		{"at time", SequenceLockTimeIsSeconds | 2, coinHeight, coinTime + 2<<SequenceLockTimeGranularity, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{
+				Version: txVersion,
+				Vin:     []TXInput{{Txid: []byte{1}, Vout: 0, Sequence: test.sequence}},
+			}
+
+			err := checkSequenceLock(tx, 0, coinHeight, coinTime, test.height, test.medianTime)
+			if !test.locked {
+				checkRuleError(t, err, noRuleError)
+			} else {
+				checkRuleError(t, err, ErrSequenceLockNotMet)
+			}
+		})
+	}
+
+	coinbase := NewCoinbaseTX(string(NewWallet().GetAddress()), "", 1, 0)
+	coinbase.Vin[0].Sequence = 10
+	checkRuleError(t, checkSequenceLock(coinbase, 0, coinHeight, coinTime, coinHeight, coinTime), noRuleError)
+}
+
+func TestVerifyLockTimeScripts(t *testing.T) {
+	const lockTime = lockTimeThreshold + 1000
+
+	// locked - a script spendable by anyone once the op passes for n
+	locked := func(n int64, op byte) []byte {
+		return NewScriptBuilder().AddInt64(n).AddOp(op).AddOp(OpDrop).AddInt64(1).Script()
+	}
+
+	tests := []struct {
+		name     string
+		lock     []byte
+		lockTime uint32
+		sequence uint32
+		valid    bool
+	}{
+		{"cltv at height", locked(10, OpCheckLockTimeVerify), 10, 0, true},
+		{"cltv past height", locked(10, OpCheckLockTimeVerify), 11, 0, true},
+		{"cltv before height", locked(10, OpCheckLockTimeVerify), 9, 0, false},
+		{"cltv at time", locked(lockTime, OpCheckLockTimeVerify), lockTime, 0, true},
+		{"cltv before time", locked(lockTime, OpCheckLockTimeVerify), lockTime - 1, 0, false},
+		{"cltv time against height", locked(lockTime, OpCheckLockTimeVerify), 10, 0, false},
+		{"cltv height against time", locked(10, OpCheckLockTimeVerify), lockTime, 0, false},
+		{"cltv final input", locked(10, OpCheckLockTimeVerify), 10, MaxTxInSequenceNum, false},
+		{"cltv negative", locked(-1, OpCheckLockTimeVerify), 10, 0, false},
+		{"csv blocks", locked(5, OpCheckSequenceVerify), 0, 5, true},
+		{"csv more blocks", locked(5, OpCheckSequenceVerify), 0, 6, true},
+		{"csv too few blocks", locked(5, OpCheckSequenceVerify), 0, 4, false},
+		{"csv time", locked(int64(SequenceLockTimeIsSeconds|5), OpCheckSequenceVerify), 0, SequenceLockTimeIsSeconds | 5, true},
+		{"csv time against blocks", locked(int64(SequenceLockTimeIsSeconds|5), OpCheckSequenceVerify), 0, 5, false},
+		{"csv blocks against time", locked(5, OpCheckSequenceVerify), 0, SequenceLockTimeIsSeconds | 5, false},
+		{"csv input without relative lock", locked(5, OpCheckSequenceVerify), 0, SequenceLockTimeDisabled | 5, false},
+		{"csv final input", locked(5, OpCheckSequenceVerify), 0, MaxTxInSequenceNum, false},
+		{"csv disabled in the script", locked(int64(SequenceLockTimeDisabled), OpCheckSequenceVerify), 0, MaxTxInSequenceNum, true},
+		{"csv negative", locked(-1, OpCheckSequenceVerify), 0, 5, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, prevTXs := spendScript(test.lock, func(tx *Transaction) {
+				tx.LockTime = test.lockTime
+				tx.Vin[0].Sequence = test.sequence
+			}, pushes())
+
+			if tx.Verify(prevTXs) != test.valid {
+				t.Fatalf("Verify = %v, want %v", !test.valid, test.valid)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha256"
+	"errors"
 )
 
 // MerkleTree - represents the Merkle tree
@@ -16,20 +17,25 @@ type MerkleNode struct {
 	Data  []byte
 }
 
-// NewMerkleTree - create a new merkle tree
-func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+// NewMerkleTree - create a new merkle tree. A level with an odd number of
+// nodes pairs its last node with itself
+func NewMerkleTree(data [][]byte) (*MerkleTree, error) {
+	if len(data) == 0 {
+		return nil, errors.New("merkle tree needs at least one leaf")
 	}
 
+	var nodes []MerkleNode
+
 	for _, datum := range data {
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j = j + 2 {
@@ -42,7 +48,7 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 
 	mTree := MerkleTree{&nodes[0]}
 
-	return &mTree
+	return &mTree, nil
 
 }
 
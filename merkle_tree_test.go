@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+// merkleRoot - the root of the leaves, each level pairing its last node with
+// itself when it has an odd number of them
+func merkleRoot(level [][]byte) []byte {
+	if len(level) == 1 {
+		return level[0]
+	}
+	if len(level)%2 != 0 {
+		level = append(level, level[len(level)-1])
+	}
+
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		hash := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+		next = append(next, hash[:])
+	}
+
+	return merkleRoot(next)
+}
+
+func TestNewMerkleTree(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			var data, leaves [][]byte
+			for i := 0; i < n; i++ {
+				datum := []byte{byte(i)}
+				hash := sha256.Sum256(datum)
+				data = append(data, datum)
+				leaves = append(leaves, hash[:])
+			}
+
+			tree, err := NewMerkleTree(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := merkleRoot(leaves); !bytes.Equal(tree.RootNode.Data, want) {
+				t.Fatalf("root %x, want %x", tree.RootNode.Data, want)
+			}
+		})
+	}
+
+	_, err := NewMerkleTree(nil)
+	if err == nil {
+		t.Fatal("merkle tree without leaves")
+	}
+}
+
+func TestMerkleTreeCommitsToOrder(t *testing.T) {
+	a, err := NewMerkleTree([][]byte{{1}, {2}, {3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMerkleTree([][]byte{{2}, {1}, {3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(a.RootNode.Data, b.RootNode.Data) {
+		t.Fatal("swapping leaves kept the root")
+	}
+}
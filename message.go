@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// messagePrefix - put in front of every signed message, so a message
+// signature can never pass for the signature of a transaction
+const messagePrefix = "MBlah Signed Message:\n"
+
+const (
+	// compactSigLen - a recoverable secp256k1 signature: the recovery code,
+	// r and s
+	compactSigLen = 65
+	// p256MessageSigLen - P-256 keys cannot be recovered, so their key goes
+	// in front of the signature
+	p256MessageSigLen = 64 + 64
+)
+
+// messageHash - the double SHA-256 of the prefix and the message, each
+// prefixed with its length
+func messageHash(message string) []byte {
+	var buff bytes.Buffer
+	writeBytes(&buff, []byte(messagePrefix))
+	writeBytes(&buff, []byte(message))
+
+	first := sha256.Sum256(buff.Bytes())
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
+
+// SignMessage - signs message to prove the wallet holds the key of its
+// address. secp256k1 wallets make a recoverable signature, Schnorr wallets a
+// Schnorr signature of their x-only key and P-256 wallets a signature with
+// their public key in front
+func (w *Wallet) SignMessage(message string) ([]byte, error) {
+	if w.PrivateKey.D == nil {
+		return nil, errors.New("wallet holds no private key")
+	}
+
+	hash := messageHash(message)
+
+	if w.Schnorr {
+		return schnorrSign(w.PrivateKey, hash)
+	}
+
+	if w.PrivateKey.Curve == secp256k1.S256() {
+		privKey := secp256k1.PrivKeyFromBytes(w.PrivateKey.D.Bytes())
+		return secpecdsa.SignCompact(privKey, hash, true), nil
+	}
+
+	signature, err := signHash(w.PrivateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, w.PublicKey...), signature...), nil
+}
+
+// VerifyMessage - checks that signature was made by SignMessage for message
+// with the key of address
+func VerifyMessage(address string, signature []byte, message string) bool {
+	if !ValidateAddress(address) {
+		return false
+	}
+
+	hash := messageHash(message)
+	lockingScript := AddressScript(address)
+
+	switch GetScriptClass(lockingScript) {
+	case SchnorrPubKeyTy:
+		return schnorrVerify(ExtractSchnorrPubKey(lockingScript), signature, hash)
+
+	case PubKeyHashTy:
+		var pubKey []byte
+		switch len(signature) {
+		case compactSigLen:
+			pub, compressed, err := secpecdsa.RecoverCompact(signature, hash)
+			if err != nil || !compressed {
+				return false
+			}
+			pubKey = pub.SerializeCompressed()
+		case p256MessageSigLen:
+			pubKey = signature[:64]
+			if !verifySignature(pubKey, signature[64:], hash) {
+				return false
+			}
+		default:
+			return false
+		}
+
+		return bytes.Equal(HashPubKey(pubKey), ExtractPubKeyHash(lockingScript))
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+func TestSignVerifyMessage(t *testing.T) {
+	schnorrWallet := NewWallet()
+	schnorrWallet.Schnorr = true
+
+	p256Key := testPrivKey(t, elliptic.P256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721")
+	p256Wallet := &Wallet{PrivateKey: p256Key, PublicKey: pubKeyBytes(&p256Key.PublicKey)}
+
+	tests := []struct {
+		name   string
+		wallet *Wallet
+		sigLen int
+	}{
+		{"secp256k1", NewWallet(), compactSigLen},
+		{"schnorr", schnorrWallet, schnorrSigLen},
+		{"p256", p256Wallet, p256MessageSigLen},
+	}
+
+	const message = "I own this address"
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := string(test.wallet.GetAddress())
+			other := string(NewWallet().GetAddress())
+
+			signature, err := test.wallet.SignMessage(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(signature) != test.sigLen {
+				t.Fatalf("signature of %d bytes, want %d", len(signature), test.sigLen)
+			}
+
+			if !VerifyMessage(address, signature, message) {
+				t.Fatal("signature does not verify")
+			}
+			if VerifyMessage(address, signature, message+".") {
+				t.Fatal("signature verifies for another message")
+			}
+			if VerifyMessage(other, signature, message) {
+				t.Fatal("signature verifies for another address")
+			}
+
+			for _, i := range []int{0, len(signature) / 2, len(signature) - 1} {
+				tampered := append([]byte{}, signature...)
+				tampered[i] ^= 1
+				if VerifyMessage(address, tampered, message) {
+					t.Fatalf("signature with byte %d changed verifies", i)
+				}
+			}
+			if VerifyMessage(address, signature[:len(signature)-1], message) {
+				t.Fatal("truncated signature verifies")
+			}
+		})
+	}
+}
+
+func TestVerifyMessageDomain(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	const message = "I own this address"
+
+	// a signature of the message hashed without the prefix, as a
+	// transaction signature would be, is no message signature
+	first := sha256.Sum256([]byte(message))
+	second := sha256.Sum256(first[:])
+	privKey := secp256k1.PrivKeyFromBytes(wallet.PrivateKey.D.Bytes())
+	if VerifyMessage(address, secpecdsa.SignCompact(privKey, second[:], true), message) {
+		t.Fatal("signature without the message prefix verifies")
+	}
+
+	// the signature of a secp256k1 wallet does not pass for its Schnorr address
+	signature, err := wallet.SignMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schnorrWallet := *wallet
+	schnorrWallet.Schnorr = true
+	if VerifyMessage(string(schnorrWallet.GetAddress()), signature, message) {
+		t.Fatal("ECDSA signature verifies for a Schnorr address")
+	}
+
+	watchOnly := &Wallet{PublicKey: wallet.PublicKey}
+	if _, err := watchOnly.SignMessage(message); err == nil {
+		t.Fatal("wallet without a private key signed")
+	}
+}
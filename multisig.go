@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+)
+
+// NewMultiSigTransaction - create a new UTXO spending from the multisig
+// address of redeemScript, signed with privKeys, which must be at least as
+// many of its keys as it requires
+func NewMultiSigTransaction(redeemScript []byte, privKeys []ecdsa.PrivateKey, to string, amount, fee int, lockTime uint32, UTXOSet *UTXOSet) (*Transaction, error) {
+	m, _, err := ExtractMultiSig(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(privKeys) < m {
+		return nil, fmt.Errorf("wallet holds %d of the %d keys needed", len(privKeys), m)
+	}
+
+	from := fmt.Sprintf("%s", ScriptAddress(redeemScript))
+	tx, err := newSpendTransaction(from, to, amount, fee, lockTime, UTXOSet)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tx.Vin {
+		tx.Vin[i].ScriptSig = NewScriptBuilder().AddData(redeemScript).Script()
+	}
+
+	for _, privKey := range privKeys[:m] {
+		UTXOSet.Blockchain.SignTransaction(tx, privKey)
+	}
+
+	return tx, nil
+}
+
+// signMultiSigInput - adds a signature to an input spending a multisig
+// pay-to-script-hash output. The unlocking script must already end with the
+// redeem script; the signatures before it are kept in the order of their
+// keys, up to as many as the script requires
+func (tx *Transaction) signMultiSigInput(privKey ecdsa.PrivateKey, inputIndex int, prevOutput TXOutput, hashType SigHashType) error {
+	pushes, err := scriptSigPushes(tx.Vin[inputIndex].ScriptSig)
+	if err != nil || len(pushes) == 0 {
+		return errors.New("input has no redeem script to sign for")
+	}
+
+	redeemScript := pushes[len(pushes)-1]
+	if !bytes.Equal(HashPubKey(redeemScript), ExtractScriptHash(prevOutput.ScriptPubKey)) {
+		return errors.New("redeem script does not match the output being spent")
+	}
+
+	m, pubKeys, err := ExtractMultiSig(redeemScript)
+	if err != nil {
+		return fmt.Errorf("cannot sign for redeem script %s", DisasmString(redeemScript))
+	}
+
+	pubKey := pubKeyBytes(&privKey.PublicKey)
+	keyIdx := -1
+	for i := range pubKeys {
+		if bytes.Equal(pubKeys[i], pubKey) {
+			keyIdx = i
+		}
+	}
+	if keyIdx < 0 {
+		return errors.New("key is not part of the multisig script")
+	}
+
+	scriptCode := TXOutput{Value: prevOutput.Value, ScriptPubKey: redeemScript}
+
+	// OP_CHECKMULTISIG wants the signatures in the order of their keys
+	sigs := make([][]byte, len(pubKeys))
+	for _, sig := range pushes[:len(pushes)-1] {
+		for i := range pubKeys {
+			if sigs[i] == nil && tx.checkSignature(inputIndex, scriptCode, sig, pubKeys[i]) {
+				sigs[i] = sig
+				break
+			}
+		}
+	}
+
+	sigs[keyIdx], err = tx.signature(privKey, inputIndex, scriptCode, hashType)
+	if err != nil {
+		return err
+	}
+
+	builder := NewScriptBuilder()
+	count := 0
+	for _, sig := range sigs {
+		if sig != nil && count < m {
+			builder.AddData(sig)
+			count++
+		}
+	}
+	tx.Vin[inputIndex].ScriptSig = builder.AddData(redeemScript).Script()
+
+	return nil
+}
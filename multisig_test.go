@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestVerifyMultiSig(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	outsider := NewWallet()
+
+	redeemScript, err := MultiSigScript(2, [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := PayToScriptHashScript(HashPubKey(redeemScript))
+
+	// sigs - the signatures of the signers on the input of tx
+	sigs := func(tx *Transaction, prevOut TXOutput, signers ...*Wallet) [][]byte {
+		scriptCode := TXOutput{Value: prevOut.Value, ScriptPubKey: redeemScript}
+		var data [][]byte
+		for _, signer := range signers {
+			sig, err := tx.signature(signer.PrivateKey, 0, scriptCode, SigHashAll)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, sig)
+		}
+
+		return data
+	}
+
+	// sigsOf - an unlocking script of the pushes before, the signatures of
+	// the signers and the redeem script, with nil standing for an empty push
+	sigsOf := func(before [][]byte, signers ...*Wallet) func(tx *Transaction, prevOut TXOutput) []byte {
+		return func(tx *Transaction, prevOut TXOutput) []byte {
+			data := append(append([][]byte{}, before...), sigs(tx, prevOut, signers...)...)
+			return pushes(append(data, redeemScript)...)(tx, prevOut)
+		}
+	}
+
+	// signInputs - the unlocking script SignInput builds as the signers add
+	// their signatures one after another
+	signInputs := func(signers ...*Wallet) func(tx *Transaction, prevOut TXOutput) []byte {
+		return func(tx *Transaction, prevOut TXOutput) []byte {
+			tx.Vin[0].ScriptSig = NewScriptBuilder().AddData(redeemScript).Script()
+			for _, signer := range signers {
+				err := tx.SignInput(signer.PrivateKey, 0, prevOut, SigHashAll)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			return tx.Vin[0].ScriptSig
+		}
+	}
+
+	tests := []struct {
+		name   string
+		unlock func(tx *Transaction, prevOut TXOutput) []byte
+		valid  bool
+	}{
+		{"first and second", sigsOf(nil, wallets[0], wallets[1]), true},
+		{"first and third", sigsOf(nil, wallets[0], wallets[2]), true},
+		{"second and third", sigsOf(nil, wallets[1], wallets[2]), true},
+		{"out of key order", sigsOf(nil, wallets[1], wallets[0]), false},
+		{"one signature", sigsOf(nil, wallets[0]), false},
+		{"same signature twice", sigsOf(nil, wallets[0], wallets[0]), false},
+		{"signature of an outsider", sigsOf(nil, wallets[0], outsider), false},
+		{"no signatures", sigsOf(nil), false},
+		// unlike in Bitcoin, OP_CHECKMULTISIG pops no extra item, so the empty
+		// push a Bitcoin spend starts with is left below the result
+		{"extra item below", sigsOf([][]byte{nil}, wallets[0], wallets[1]), true},
+		// and the extra item cannot stand in for a signature
+		{"extra item for a signature", sigsOf([][]byte{nil}, wallets[0]), false},
+		{"extra item above", func(tx *Transaction, prevOut TXOutput) []byte {
+			data := append(sigs(tx, prevOut, wallets[0], wallets[1]), nil, redeemScript)
+			return pushes(data...)(tx, prevOut)
+		}, false},
+		{"signed in key order", signInputs(wallets[0], wallets[2]), true},
+		{"signed out of key order", signInputs(wallets[2], wallets[0]), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, prevTXs := spendScript(lock, nil, test.unlock)
+			if tx.Verify(prevTXs) != test.valid {
+				t.Fatalf("Verify = %v, want %v", !test.valid, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// MuSigKey - the key n signers share as in BIP327 (MuSig2). Q is the sum of
+// every key weighted by its coefficient, and spends to the x-only form of Q
+// need a signature from every one of them
+type MuSigKey struct {
+	// PubKeys - the compressed keys of the signers, sorted
+	PubKeys [][]byte
+	// Q - the aggregate point
+	Q secp256k1.JacobianPoint
+
+	coefficients []secp256k1.ModNScalar
+}
+
+// AggregateKeys - the key shared by the holders of pubKeys. The keys are
+// sorted first, so their order does not change the address
+func AggregateKeys(pubKeys [][]byte) (*MuSigKey, error) {
+	if len(pubKeys) < 1 {
+		return nil, errors.New("musig needs at least one key")
+	}
+
+	sorted := make([][]byte, len(pubKeys))
+	copy(sorted, pubKeys)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	keyList := bytes.Join(sorted, nil)
+	listHash := taggedHash("KeyAgg list", keyList)
+
+	// the first key different from the first takes a coefficient of one
+	var second []byte
+	for _, pubKey := range sorted {
+		if !bytes.Equal(pubKey, sorted[0]) {
+			second = pubKey
+			break
+		}
+	}
+
+	key := MuSigKey{PubKeys: sorted}
+	for i, pubKey := range sorted {
+		if len(pubKey) != secp256k1.PubKeyBytesLenCompressed {
+			return nil, fmt.Errorf("public key %d is not a compressed secp256k1 key", i)
+		}
+		pub, err := secp256k1.ParsePubKey(pubKey)
+		if err != nil {
+			return nil, fmt.Errorf("public key %d is not valid: %v", i, err)
+		}
+
+		var a secp256k1.ModNScalar
+		if second != nil && bytes.Equal(pubKey, second) {
+			a.SetInt(1)
+		} else {
+			coefficient := taggedHash("KeyAgg coefficient", listHash[:], pubKey)
+			a.SetBytes(&coefficient)
+		}
+		key.coefficients = append(key.coefficients, a)
+
+		var p, aP, sum secp256k1.JacobianPoint
+		pub.AsJacobian(&p)
+		secp256k1.ScalarMultNonConst(&a, &p, &aP)
+		secp256k1.AddNonConst(&key.Q, &aP, &sum)
+		key.Q.Set(&sum)
+	}
+
+	if isInfinity(&key.Q) {
+		return nil, errors.New("musig keys cancel out")
+	}
+	key.Q.ToAffine()
+
+	return &key, nil
+}
+
+// XOnly - the x-only form of the aggregate key, which outputs are locked to
+func (key *MuSigKey) XOnly() []byte {
+	x := key.Q.X.Bytes()
+	return x[:]
+}
+
+// coefficient - the coefficient of the signer holding pubKey
+func (key *MuSigKey) coefficient(pubKey []byte) (*secp256k1.ModNScalar, error) {
+	for i := range key.PubKeys {
+		if bytes.Equal(key.PubKeys[i], pubKey) {
+			return &key.coefficients[i], nil
+		}
+	}
+
+	return nil, errors.New("key is not part of the musig key")
+}
+
+// MuSigNonce - the two secret nonces of one signer for one signature. A
+// nonce must never sign twice, or the private key can be worked out
+type MuSigNonce struct {
+	k1, k2 secp256k1.ModNScalar
+	// Public - k1*G and k2*G, compressed, for the other signers
+	Public []byte
+}
+
+// NewMuSigNonce - fresh random nonces for signing hash under key
+func NewMuSigNonce(privKey ecdsa.PrivateKey, key *MuSigKey, hash []byte) (*MuSigNonce, error) {
+	randBytes := make([]byte, 32)
+	_, err := rand.Read(randBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	privKey.D.FillBytes(secret)
+
+	nonce := MuSigNonce{}
+	for i, k := range []*secp256k1.ModNScalar{&nonce.k1, &nonce.k2} {
+		h := taggedHash("MuSig/nonce", randBytes, secret, key.XOnly(), hash, []byte{byte(i)})
+		k.SetBytes(&h)
+		if k.IsZero() {
+			return nil, errors.New("musig nonce is zero")
+		}
+	}
+	nonce.setPublic()
+
+	return &nonce, nil
+}
+
+// setPublic - works out the public nonces of k1 and k2
+func (nonce *MuSigNonce) setPublic() {
+	nonce.Public = nil
+	for _, k := range []*secp256k1.ModNScalar{&nonce.k1, &nonce.k2} {
+		var r secp256k1.JacobianPoint
+		secp256k1.ScalarBaseMultNonConst(k, &r)
+		r.ToAffine()
+		nonce.Public = append(nonce.Public, secp256k1.NewPublicKey(&r.X, &r.Y).SerializeCompressed()...)
+	}
+}
+
+// Secret - k1 and k2, for a signer to keep between the two rounds when they
+// are run apart. Whoever learns it along with a partial signature made with
+// it learns the private key
+func (nonce *MuSigNonce) Secret() []byte {
+	k1, k2 := nonce.k1.Bytes(), nonce.k2.Bytes()
+	return append(k1[:], k2[:]...)
+}
+
+// MuSigNonceFromSecret - the nonce kept as secret by Secret
+func MuSigNonceFromSecret(secret []byte) (*MuSigNonce, error) {
+	if len(secret) != 64 {
+		return nil, fmt.Errorf("secret nonce is %d bytes", len(secret))
+	}
+
+	nonce := MuSigNonce{}
+	if nonce.k1.SetByteSlice(secret[:32]) || nonce.k2.SetByteSlice(secret[32:]) || nonce.k1.IsZero() || nonce.k2.IsZero() {
+		return nil, errors.New("secret nonce is out of range")
+	}
+	nonce.setPublic()
+
+	return &nonce, nil
+}
+
+// MuSigSession - what every signer needs to sign hash once the public nonces
+// have been swapped: the final R and the factors the partial signatures use
+type MuSigSession struct {
+	key  *MuSigKey
+	hash []byte
+	r    secp256k1.JacobianPoint
+	b    secp256k1.ModNScalar
+	e    secp256k1.ModNScalar
+}
+
+// NewMuSigSession - aggregates the public nonces of all the signers, one for
+// each key of key
+func NewMuSigSession(key *MuSigKey, publicNonces [][]byte, hash []byte) (*MuSigSession, error) {
+	if len(publicNonces) != len(key.PubKeys) {
+		return nil, fmt.Errorf("musig needs %d public nonces, got %d", len(key.PubKeys), len(publicNonces))
+	}
+
+	var r1, r2 secp256k1.JacobianPoint
+
+	for i, public := range publicNonces {
+		if len(public) != 2*secp256k1.PubKeyBytesLenCompressed {
+			return nil, fmt.Errorf("public nonce %d is %d bytes", i, len(public))
+		}
+
+		for j, sum := range []*secp256k1.JacobianPoint{&r1, &r2} {
+			point := public[j*secp256k1.PubKeyBytesLenCompressed : (j+1)*secp256k1.PubKeyBytesLenCompressed]
+			pub, err := secp256k1.ParsePubKey(point)
+			if err != nil {
+				return nil, fmt.Errorf("public nonce %d is not valid: %v", i, err)
+			}
+
+			var p, tmp secp256k1.JacobianPoint
+			pub.AsJacobian(&p)
+			secp256k1.AddNonConst(sum, &p, &tmp)
+			sum.Set(&tmp)
+		}
+	}
+
+	aggNonce := append(jacobianBytes(&r1), jacobianBytes(&r2)...)
+
+	session := MuSigSession{key: key, hash: hash}
+	coefficient := taggedHash("MuSig/noncecoef", aggNonce, key.XOnly(), hash)
+	session.b.SetBytes(&coefficient)
+
+	// R = R1 + b*R2, or G should it come out at infinity
+	var bR2 secp256k1.JacobianPoint
+	secp256k1.ScalarMultNonConst(&session.b, &r2, &bR2)
+	secp256k1.AddNonConst(&r1, &bR2, &session.r)
+	if isInfinity(&session.r) {
+		var one secp256k1.ModNScalar
+		one.SetInt(1)
+		secp256k1.ScalarBaseMultNonConst(&one, &session.r)
+	}
+	session.r.ToAffine()
+
+	rX := session.r.X.Bytes()
+	session.e = schnorrChallenge(rX[:], key.XOnly(), hash)
+
+	return &session, nil
+}
+
+// jacobianBytes - the compressed form of a point, 33 zero bytes for infinity
+func jacobianBytes(p *secp256k1.JacobianPoint) []byte {
+	if isInfinity(p) {
+		return make([]byte, secp256k1.PubKeyBytesLenCompressed)
+	}
+
+	affine := *p
+	affine.ToAffine()
+	return secp256k1.NewPublicKey(&affine.X, &affine.Y).SerializeCompressed()
+}
+
+// PartialSign - the share of the signature of the holder of privKey, who
+// must have made nonce for this session and must not use it again
+func (session *MuSigSession) PartialSign(privKey ecdsa.PrivateKey, nonce *MuSigNonce) ([]byte, error) {
+	var d secp256k1.ModNScalar
+	if d.SetByteSlice(privKey.D.Bytes()) || d.IsZero() {
+		return nil, errors.New("private key is out of range")
+	}
+
+	a, err := session.key.coefficient(pubKeyBytes(&privKey.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	// the nonces and the key are negated to match the even y of R and Q
+	k1, k2 := nonce.k1, nonce.k2
+	if session.r.Y.IsOdd() {
+		k1.Negate()
+		k2.Negate()
+	}
+	if session.key.Q.Y.IsOdd() {
+		d.Negate()
+	}
+
+	// s = k1 + b*k2 + e*a*d
+	s := new(secp256k1.ModNScalar).Mul2(&session.b, &k2).Add(&k1)
+	ead := new(secp256k1.ModNScalar).Mul2(&session.e, a).Mul(&d)
+	s.Add(ead)
+
+	sBytes := s.Bytes()
+	return sBytes[:], nil
+}
+
+// Aggregate - the Schnorr signature made of every partial signature
+func (session *MuSigSession) Aggregate(partialSigs [][]byte) ([]byte, error) {
+	var s secp256k1.ModNScalar
+
+	for i, partialSig := range partialSigs {
+		var si secp256k1.ModNScalar
+		if len(partialSig) != 32 || si.SetByteSlice(partialSig) {
+			return nil, fmt.Errorf("partial signature %d is out of range", i)
+		}
+		s.Add(&si)
+	}
+
+	rX := session.r.X.Bytes()
+	sBytes := s.Bytes()
+	signature := append(rX[:], sBytes[:]...)
+
+	if !schnorrVerify(session.key.XOnly(), signature, session.hash) {
+		return nil, errors.New("musig signature did not verify")
+	}
+
+	return signature, nil
+}
+
+// musigSign - runs both rounds of MuSig2 for signers whose keys are all at
+// hand, returning the Schnorr signature of hash under key
+func musigSign(privKeys []ecdsa.PrivateKey, key *MuSigKey, hash []byte) ([]byte, error) {
+	if len(privKeys) != len(key.PubKeys) {
+		return nil, fmt.Errorf("musig needs all %d keys, got %d", len(key.PubKeys), len(privKeys))
+	}
+
+	var nonces []*MuSigNonce
+	var publicNonces [][]byte
+	for _, privKey := range privKeys {
+		nonce, err := NewMuSigNonce(privKey, key, hash)
+		if err != nil {
+			return nil, err
+		}
+		nonces = append(nonces, nonce)
+		publicNonces = append(publicNonces, nonce.Public)
+	}
+
+	session, err := NewMuSigSession(key, publicNonces, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var partialSigs [][]byte
+	for i, privKey := range privKeys {
+		partialSig, err := session.PartialSign(privKey, nonces[i])
+		if err != nil {
+			return nil, err
+		}
+		partialSigs = append(partialSigs, partialSig)
+	}
+
+	return session.Aggregate(partialSigs)
+}
+
+// NewMuSigTransaction - create a new UTXO spending from the address of the
+// musig key, signed with privKeys, which must hold every key of it
+func NewMuSigTransaction(key *MuSigKey, privKeys []ecdsa.PrivateKey, to string, amount, fee int, lockTime uint32, UTXOSet *UTXOSet) (*Transaction, error) {
+	from := fmt.Sprintf("%s", encodeAddress(schnorrVersion, key.XOnly()))
+	tx, err := newSpendTransaction(from, to, amount, fee, lockTime, UTXOSet)
+	if err != nil {
+		return nil, err
+	}
+
+	for inID, vin := range tx.Vin {
+		prevTX, err := UTXOSet.Blockchain.FindTransaction(vin.Txid)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.signMuSigInput(privKeys, key, inID, prevTX.Vout[vin.Vout], SigHashAll)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// signMuSigInput - signs an input spending the x-only key of a musig key
+// with every one of its keys
+func (tx *Transaction) signMuSigInput(privKeys []ecdsa.PrivateKey, key *MuSigKey, inputIndex int, prevOutput TXOutput, hashType SigHashType) error {
+	if !bytes.Equal(ExtractSchnorrPubKey(prevOutput.ScriptPubKey), key.XOnly()) {
+		return errors.New("musig key does not match the output being spent")
+	}
+
+	hash, err := SignatureHash(tx, inputIndex, prevOutput, hashType)
+	if err != nil {
+		return err
+	}
+
+	signature, err := musigSign(privKeys, key, hash)
+	if err != nil {
+		return err
+	}
+
+	tx.Vin[inputIndex].ScriptSig = NewScriptBuilder().AddData(append(signature, byte(hashType))).Script()
+
+	return nil
+}
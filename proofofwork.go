@@ -1,17 +1,35 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"math"
 	"math/big"
 )
 
-const targetBits = 24
+const (
+	// targetBits - difficulty of the genesis block
+	targetBits = 24
+	// powLimitBits - the easiest difficulty a retarget may fall to
+	powLimitBits = 8
+
+	// retargetInterval - number of blocks between difficulty adjustments
+	retargetInterval = 20
+	// targetSpacing - desired number of seconds between blocks
+	targetSpacing = 10
+	// targetTimespan - desired number of seconds for a whole retarget window
+	targetTimespan = retargetInterval * targetSpacing
+	// retargetClamp - max factor the target may move by in one retarget
+	retargetClamp = 4
+)
 
 var maxNonce = math.MaxInt64
 
+var (
+	powLimit    = new(big.Int).Lsh(big.NewInt(1), 256-powLimitBits)
+	initialBits = BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-targetBits))
+)
+
 // ProofofWork - used to calculate PoW
 type ProofofWork struct {
 	block  *Block
@@ -20,8 +38,7 @@ type ProofofWork struct {
 
 // NewProofOfWork - get the PoW for the block
 func NewProofOfWork(b *Block) *ProofofWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target := CompactToBig(b.Bits)
 
 	pow := &ProofofWork{
 		block:  b,
@@ -32,15 +49,10 @@ func NewProofOfWork(b *Block) *ProofofWork {
 }
 
 func (pow *ProofofWork) prepareData(nonce int) []byte {
-	data := bytes.Join([][]byte{
-		pow.block.PrevBlockHash,
-		pow.block.HashTransactions(),
-		IntToHex(pow.block.Timestamp),
-		IntToHex(int64(targetBits)),
-		IntToHex(int64(nonce)),
-	}, []byte{})
-
-	return data
+	header := pow.block.BlockHeader
+	header.Nonce = nonce
+
+	return header.Serialize()
 }
 
 // Run - this is where the PoW computes the nonce
@@ -69,15 +81,142 @@ func (pow *ProofofWork) Run() (int, []byte) {
 	return nonce, hash[:]
 }
 
-// Validate - ensure that the nonce is correct
-func (pow *ProofofWork) Validate() bool {
-	var hashInt big.Int
+// Validate - ensure that the nonce is correct and the block
+// carries the target expected by the chain
+func (pow *ProofofWork) Validate(bc *Blockchain) bool {
+	if pow.target.Sign() <= 0 || pow.target.Cmp(powLimit) > 0 {
+		return false
+	}
+
+	expectedBits := initialBits
+	if len(pow.block.PrevBlockHash) != 0 {
+		parent, err := bc.GetBlock(pow.block.PrevBlockHash)
+		if err != nil {
+			return false
+		}
+		expectedBits = bc.GetNextWorkRequired(&parent)
+	}
+
+	if pow.block.Bits != expectedBits {
+		return false
+	}
 
 	data := pow.prepareData(pow.block.Nonce)
 	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
 
-	isValid := hashInt.Cmp(pow.target) == -1
+	return pow.checkHash(hash[:])
+}
+
+// checkHash - reports whether hash meets the block's target
+func (pow *ProofofWork) checkHash(hash []byte) bool {
+	var hashInt big.Int
+	hashInt.SetBytes(hash)
+
+	return hashInt.Cmp(pow.target) == -1
+}
+
+// GetNextWorkRequired - returns the target bits for the block following parent.
+// The target only changes every retargetInterval blocks, scaled by how long
+// the previous window actually took and clamped to retargetClamp either way.
+func (bc *Blockchain) GetNextWorkRequired(parent *Block) uint32 {
+	if (parent.Height+1)%retargetInterval != 0 {
+		return parent.Bits
+	}
+
+	first := &parent.BlockHeader
+	for i := 0; i < retargetInterval-1 && len(first.PrevBlockHash) != 0; i++ {
+		prev, _, err := bc.GetBlockHeader(first.PrevBlockHash)
+		if err != nil {
+			return parent.Bits
+		}
+		first = prev
+	}
+
+	return calcRetarget(parent.Bits, parent.Timestamp-first.Timestamp)
+}
+
+// calcRetarget - returns the target bits following a window at bits that
+// took actualTimespan seconds
+func calcRetarget(bits uint32, actualTimespan int64) uint32 {
+	if actualTimespan < targetTimespan/retargetClamp {
+		actualTimespan = targetTimespan / retargetClamp
+	}
+	if actualTimespan > targetTimespan*retargetClamp {
+		actualTimespan = targetTimespan * retargetClamp
+	}
+
+	newTarget := CompactToBig(bits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget.Set(powLimit)
+	}
+
+	return BigToCompact(newTarget)
+}
+
+// CalcWork - returns the expected number of hashes needed to meet bits,
+// used to compare the cumulative work of competing branches
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+
+	return numerator.Div(numerator, denominator)
+}
+
+// CompactToBig - expands the compact bits representation of a target
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+
+	return bn
+}
+
+// BigToCompact - packs a target into its compact bits representation
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
 
-	return isValid
+	return compact
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// testBits - the compact bits of a target of 2^232, the default difficulty
+const testBits = 0x1e010000
+
+// scaledBits - the compact bits of the target of bits multiplied by num/den
+func scaledBits(bits uint32, num, den int64) uint32 {
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(num))
+	target.Div(target, big.NewInt(den))
+
+	return BigToCompact(target)
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		target  *big.Int
+	}{
+		{0, big.NewInt(0)},
+		{0x01003456, big.NewInt(0)},
+		{0x02008000, big.NewInt(0x80)},
+		{0x03123456, big.NewInt(0x123456)},
+		{0x04123456, big.NewInt(0x12345600)},
+		{0x1d00ffff, new(big.Int).Lsh(big.NewInt(0xffff), 208)},
+		{testBits, new(big.Int).Lsh(big.NewInt(1), 256-targetBits)},
+		{0x20010000, powLimit},
+	}
+
+	for _, test := range tests {
+		target := CompactToBig(test.compact)
+		if target.Cmp(test.target) != 0 {
+			t.Errorf("CompactToBig(%#08x) = %x, want %x", test.compact, target, test.target)
+		}
+		if test.target.Sign() == 0 {
+			continue
+		}
+		compact := BigToCompact(test.target)
+		if compact != test.compact {
+			t.Errorf("BigToCompact(%x) = %#08x, want %#08x", test.target, compact, test.compact)
+		}
+	}
+}
+
+func TestCalcRetarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		bits     uint32
+		timespan int64
+		want     uint32
+	}{
+		{"on target", testBits, targetTimespan, testBits},
+		{"twice as fast", testBits, targetTimespan / 2, scaledBits(testBits, 1, 2)},
+		{"twice as slow", testBits, targetTimespan * 2, scaledBits(testBits, 2, 1)},
+		{"fast at clamp", testBits, targetTimespan / retargetClamp, scaledBits(testBits, 1, retargetClamp)},
+		{"fast past clamp", testBits, 1, scaledBits(testBits, 1, retargetClamp)},
+		{"no time", testBits, 0, scaledBits(testBits, 1, retargetClamp)},
+		{"slow at clamp", testBits, targetTimespan * retargetClamp, scaledBits(testBits, retargetClamp, 1)},
+		{"slow past clamp", testBits, targetTimespan * 100, scaledBits(testBits, retargetClamp, 1)},
+		{"capped at limit", BigToCompact(powLimit), targetTimespan * retargetClamp, BigToCompact(powLimit)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bits := calcRetarget(test.bits, test.timespan)
+			if bits != test.want {
+				t.Fatalf("calcRetarget(%#08x, %d) = %#08x, want %#08x", test.bits, test.timespan, bits, test.want)
+			}
+		})
+	}
+}
+
+func TestGetNextWorkRequired(t *testing.T) {
+	// with no previous block the window spans no time, so a retarget
+	// takes the fast clamp without reading the chain
+	bc := &Blockchain{}
+	retargeted := scaledBits(testBits, 1, retargetClamp)
+
+	tests := []struct {
+		parentHeight int
+		want         uint32
+	}{
+		{0, testBits},
+		{retargetInterval - 2, testBits},
+		{retargetInterval - 1, retargeted},
+		{retargetInterval, testBits},
+		{2*retargetInterval - 2, testBits},
+		{2*retargetInterval - 1, retargeted},
+		{2 * retargetInterval, testBits},
+	}
+
+	for _, test := range tests {
+		parent := &Block{BlockHeader: BlockHeader{Timestamp: 1000, Bits: testBits}, Height: test.parentHeight}
+		bits := bc.GetNextWorkRequired(parent)
+		if bits != test.want {
+			t.Errorf("bits after height %d = %#08x, want %#08x", test.parentHeight, bits, test.want)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// psbtMagic - starts every serialized PSBT
+var psbtMagic = []byte("mpsbt")
+
+// PSBT - a partially signed transaction: the unsigned transaction along with
+// what is needed to sign each of its inputs without the chain, and the
+// signatures gathered so far. Each party signs a copy, the copies are
+// combined and, once every input has the signatures it needs, finalized into
+// the signed transaction. Inputs spending a musig key go through two such
+// rounds: the first gathers the public nonce of every signer, the second
+// their partial signatures
+type PSBT struct {
+	Tx     Transaction
+	Inputs []PSBTInput
+}
+
+// PSBTInput - the output an input spends, the redeem script of pay to script
+// hash outputs and the signatures made for it, keyed by hex public key. For
+// outputs locked to a musig key it also holds the keys behind it, the public
+// nonces of their holders and, as the signatures, their partial signatures
+type PSBTInput struct {
+	PrevOutput   TXOutput
+	RedeemScript []byte
+	PartialSigs  map[string][]byte
+	MuSigKeys    [][]byte
+	PubNonces    map[string][]byte
+}
+
+// NewPSBT - wraps the unsigned tx, looking up the outputs it spends on the
+// chain. redeemScript goes with every input paying to its hash, and
+// muSigKeys with every input paying to their aggregate key
+func NewPSBT(tx *Transaction, redeemScript []byte, muSigKeys [][]byte, bc *Blockchain) (*PSBT, error) {
+	p := PSBT{Tx: tx.TrimmedCopy()}
+
+	prevOutputs, err := bc.PrevOutputs(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var muSigKey *MuSigKey
+	if muSigKeys != nil {
+		muSigKey, err = AggregateKeys(muSigKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		// the nonces and partial signatures are told apart by key
+		for i := 1; i < len(muSigKey.PubKeys); i++ {
+			if bytes.Equal(muSigKey.PubKeys[i-1], muSigKey.PubKeys[i]) {
+				return nil, errors.New("musig keys must all differ to be signed over a PSBT")
+			}
+		}
+	}
+
+	for _, prevOutput := range prevOutputs {
+		input := PSBTInput{
+			PrevOutput:  prevOutput,
+			PartialSigs: make(map[string][]byte),
+			PubNonces:   make(map[string][]byte),
+		}
+		scriptHash := ExtractScriptHash(input.PrevOutput.ScriptPubKey)
+		if scriptHash != nil && redeemScript != nil && bytes.Equal(HashPubKey(redeemScript), scriptHash) {
+			input.RedeemScript = redeemScript
+		}
+		if muSigKey != nil && bytes.Equal(ExtractSchnorrPubKey(input.PrevOutput.ScriptPubKey), muSigKey.XOnly()) {
+			input.MuSigKeys = muSigKey.PubKeys
+		}
+
+		p.Inputs = append(p.Inputs, input)
+	}
+
+	return &p, nil
+}
+
+// Sign - adds the signatures privKey can make to the inputs, returning how
+// many signatures and musig nonces it added. Inputs it holds no key of, or
+// has signed already, are left alone. An input spending a musig key first
+// gets the public nonce of privKey, whose secret is kept in nonces, and once
+// every signer has added theirs, its partial signature
+func (p *PSBT) Sign(privKey ecdsa.PrivateKey, nonces map[string][]byte) (int, error) {
+	signed := 0
+
+	for i := range p.Inputs {
+		input := &p.Inputs[i]
+		scriptCode := input.PrevOutput
+		pubKey := pubKeyBytes(&privKey.PublicKey)
+		schnorr := false
+
+		switch GetScriptClass(input.PrevOutput.ScriptPubKey) {
+		case PubKeyHashTy:
+			if !bytes.Equal(HashPubKey(pubKey), ExtractPubKeyHash(input.PrevOutput.ScriptPubKey)) {
+				continue
+			}
+		case SchnorrPubKeyTy:
+			if input.MuSigKeys != nil {
+				if !containsKey(input.MuSigKeys, pubKey) {
+					continue
+				}
+
+				added, err := p.signMuSig(privKey, i, nonces)
+				if err != nil {
+					return signed, err
+				}
+				if added {
+					signed++
+				}
+				continue
+			}
+
+			if !schnorrKeyMatches(privKey, ExtractSchnorrPubKey(input.PrevOutput.ScriptPubKey)) {
+				continue
+			}
+			pubKey = schnorrPubKey(&privKey.PublicKey)
+			schnorr = true
+		case ScriptHashTy:
+			_, pubKeys, err := ExtractMultiSig(input.RedeemScript)
+			if err != nil || !containsKey(pubKeys, pubKey) {
+				continue
+			}
+			scriptCode = TXOutput{Value: input.PrevOutput.Value, ScriptPubKey: input.RedeemScript}
+		default:
+			continue
+		}
+
+		if input.PartialSigs[hex.EncodeToString(pubKey)] != nil {
+			continue
+		}
+
+		var signature []byte
+		var err error
+		if schnorr {
+			signature, err = p.Tx.schnorrSignature(privKey, i, scriptCode, SigHashAll)
+		} else {
+			signature, err = p.Tx.signature(privKey, i, scriptCode, SigHashAll)
+		}
+		if err != nil {
+			return signed, err
+		}
+
+		input.PartialSigs[hex.EncodeToString(pubKey)] = signature
+		signed++
+	}
+
+	return signed, nil
+}
+
+// signMuSig - the next step privKey takes in signing input inputIndex,
+// spending a musig key: adding its public nonce, or once every signer has,
+// its partial signature. Returns whether it added either
+func (p *PSBT) signMuSig(privKey ecdsa.PrivateKey, inputIndex int, nonces map[string][]byte) (bool, error) {
+	input := &p.Inputs[inputIndex]
+	pubKey := pubKeyBytes(&privKey.PublicKey)
+	pubKeyHex := hex.EncodeToString(pubKey)
+	if input.PartialSigs[pubKeyHex] != nil {
+		return false, nil
+	}
+
+	key, hash, err := p.muSigKey(inputIndex)
+	if err != nil {
+		return false, err
+	}
+	nonceID := hex.EncodeToString(hash) + pubKeyHex
+
+	public, ok := input.PubNonces[pubKeyHex]
+	if !ok {
+		nonce, err := NewMuSigNonce(privKey, key, hash)
+		if err != nil {
+			return false, err
+		}
+
+		nonces[nonceID] = nonce.Secret()
+		input.PubNonces[pubKeyHex] = nonce.Public
+		return true, nil
+	}
+
+	publicNonces := input.muSigNonces()
+	if publicNonces == nil {
+		return false, nil
+	}
+
+	secret, ok := nonces[nonceID]
+	if !ok {
+		return false, fmt.Errorf("input %d: the nonce of %s was not made here or has signed already", inputIndex, pubKeyHex)
+	}
+	nonce, err := MuSigNonceFromSecret(secret)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(nonce.Public, public) {
+		return false, fmt.Errorf("input %d: the nonce of %s is not the one made here", inputIndex, pubKeyHex)
+	}
+
+	session, err := NewMuSigSession(key, publicNonces, hash)
+	if err != nil {
+		return false, err
+	}
+
+	// a nonce signs once, whatever happens next
+	delete(nonces, nonceID)
+	partialSig, err := session.PartialSign(privKey, nonce)
+	if err != nil {
+		return false, err
+	}
+
+	input.PartialSigs[pubKeyHex] = partialSig
+	return true, nil
+}
+
+// muSigKey - the musig key input inputIndex spends, and the hash its
+// signers sign
+func (p *PSBT) muSigKey(inputIndex int) (*MuSigKey, []byte, error) {
+	input := p.Inputs[inputIndex]
+
+	key, err := AggregateKeys(input.MuSigKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(ExtractSchnorrPubKey(input.PrevOutput.ScriptPubKey), key.XOnly()) {
+		return nil, nil, fmt.Errorf("input %d: musig keys do not match the output being spent", inputIndex)
+	}
+
+	hash, err := SignatureHash(&p.Tx, inputIndex, input.PrevOutput, SigHashAll)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, hash, nil
+}
+
+// muSigNonces - the public nonces of the musig keys of input, in their
+// order, or nil while any is missing
+func (input *PSBTInput) muSigNonces() [][]byte {
+	var publicNonces [][]byte
+	for _, pubKey := range input.MuSigKeys {
+		public, ok := input.PubNonces[hex.EncodeToString(pubKey)]
+		if !ok {
+			return nil
+		}
+		publicNonces = append(publicNonces, public)
+	}
+
+	return publicNonces
+}
+
+// muSigSignature - the signature aggregated from the partial signatures of
+// input inputIndex, or nil while any is missing
+func (p *PSBT) muSigSignature(inputIndex int) ([]byte, error) {
+	input := p.Inputs[inputIndex]
+
+	publicNonces := input.muSigNonces()
+	if publicNonces == nil {
+		return nil, nil
+	}
+
+	var partialSigs [][]byte
+	for _, pubKey := range input.MuSigKeys {
+		partialSig := input.PartialSigs[hex.EncodeToString(pubKey)]
+		if partialSig == nil {
+			return nil, nil
+		}
+		partialSigs = append(partialSigs, partialSig)
+	}
+
+	key, hash, err := p.muSigKey(inputIndex)
+	if err != nil {
+		return nil, err
+	}
+	session, err := NewMuSigSession(key, publicNonces, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := session.Aggregate(partialSigs)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signature, byte(SigHashAll)), nil
+}
+
+// SignPSBT - signs p with every key held here, returning how many
+// signatures and musig nonces were added. Signing goes on while it adds
+// any, so musig keys all held here finish both rounds at once. The secret
+// nonces kept between rounds are only ever stored sealed, so a wallet that
+// is not encrypted refuses to begin a musig signature it cannot finish
+func (ws *Wallets) SignPSBT(p *PSBT) (int, error) {
+	if ws.IsLocked() {
+		return 0, ErrWalletLocked
+	}
+
+	nonces := ws.MuSigNonces
+	if !ws.IsEncrypted() {
+		nonces = make(map[string][]byte)
+	}
+
+	signed := 0
+	for {
+		added := 0
+		for _, wallet := range ws.Wallets {
+			n, err := p.Sign(wallet.PrivateKey, nonces)
+			if err != nil {
+				return signed + added, err
+			}
+			added += n
+		}
+
+		if added == 0 {
+			break
+		}
+		signed += added
+	}
+
+	if !ws.IsEncrypted() && len(nonces) > 0 {
+		return signed, errors.New("musig signing with other holders needs an encrypted wallet to keep its nonces in")
+	}
+
+	return signed, nil
+}
+
+// containsKey - whether pubKey is one of pubKeys
+func containsKey(pubKeys [][]byte, pubKey []byte) bool {
+	for _, key := range pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Combine - adds the signatures, redeem scripts, musig keys and nonces of
+// other, a copy of the same transaction signed elsewhere
+func (p *PSBT) Combine(other *PSBT) error {
+	if !bytes.Equal(p.Tx.Hash(), other.Tx.Hash()) || len(p.Inputs) != len(other.Inputs) {
+		return errors.New("PSBTs are not for the same transaction")
+	}
+
+	for i := range p.Inputs {
+		if p.Inputs[i].RedeemScript == nil {
+			p.Inputs[i].RedeemScript = other.Inputs[i].RedeemScript
+		}
+
+		if p.Inputs[i].MuSigKeys == nil {
+			p.Inputs[i].MuSigKeys = other.Inputs[i].MuSigKeys
+		}
+
+		for pubKey, signature := range other.Inputs[i].PartialSigs {
+			p.Inputs[i].PartialSigs[pubKey] = signature
+		}
+		for pubKey, public := range other.Inputs[i].PubNonces {
+			p.Inputs[i].PubNonces[pubKey] = public
+		}
+	}
+
+	return nil
+}
+
+// Finalize - builds the unlocking script of every input from its signatures
+// and returns the signed transaction, once every input checks out
+func (p *PSBT) Finalize() (*Transaction, error) {
+	tx := p.Tx.TrimmedCopy()
+
+	for i, input := range p.Inputs {
+		script := input.PrevOutput.ScriptPubKey
+
+		switch GetScriptClass(script) {
+		case PubKeyHashTy:
+			for pubKeyHex, signature := range input.PartialSigs {
+				pubKey, _ := hex.DecodeString(pubKeyHex)
+				if bytes.Equal(HashPubKey(pubKey), ExtractPubKeyHash(script)) {
+					tx.Vin[i].ScriptSig = pubKeyHashScriptSig(signature, pubKey)
+				}
+			}
+		case SchnorrPubKeyTy:
+			signature := input.PartialSigs[hex.EncodeToString(ExtractSchnorrPubKey(script))]
+			if input.MuSigKeys != nil {
+				var err error
+				signature, err = p.muSigSignature(i)
+				if err != nil {
+					return nil, fmt.Errorf("input %d: %v", i, err)
+				}
+			}
+			if signature != nil {
+				tx.Vin[i].ScriptSig = NewScriptBuilder().AddData(signature).Script()
+			}
+		case ScriptHashTy:
+			m, pubKeys, err := ExtractMultiSig(input.RedeemScript)
+			if err != nil {
+				return nil, fmt.Errorf("input %d has no multisig redeem script", i)
+			}
+
+			// OP_CHECKMULTISIG wants the signatures in the order of their keys
+			builder := NewScriptBuilder()
+			count := 0
+			for _, pubKey := range pubKeys {
+				signature := input.PartialSigs[hex.EncodeToString(pubKey)]
+				if signature != nil && count < m {
+					builder.AddData(signature)
+					count++
+				}
+			}
+			if count == m {
+				tx.Vin[i].ScriptSig = builder.AddData(input.RedeemScript).Script()
+			}
+		default:
+			return nil, fmt.Errorf("input %d spends script %s, which cannot be finalized", i, DisasmString(script))
+		}
+
+		if tx.Vin[i].ScriptSig == nil {
+			return nil, fmt.Errorf("input %d is missing signatures", i)
+		}
+
+		err := tx.VerifyInput(i, input.PrevOutput)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+	}
+
+	return &tx, nil
+}
+
+// Serialize - the encoding of a PSBT, in the style of Transaction.Serialize
+//
+//	psbt  = magic | tx:bytes | count:u32 | input*
+//	input = prevout | redeemscript:bytes | count:u32 | (pubkey:bytes | sig:bytes)* |
+//	        count:u32 | musigkey:bytes* | count:u32 | (pubkey:bytes | nonce:bytes)*
+//
+// The signatures and nonces of an input are sorted by public key
+func (p *PSBT) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	encoded.Write(psbtMagic)
+	writeBytes(&encoded, p.Tx.Serialize())
+
+	writeUint32(&encoded, uint32(len(p.Inputs)))
+	for _, input := range p.Inputs {
+		input.PrevOutput.serialize(&encoded)
+		writeBytes(&encoded, input.RedeemScript)
+
+		writeKeyedBytes(&encoded, input.PartialSigs)
+
+		writeUint32(&encoded, uint32(len(input.MuSigKeys)))
+		for _, pubKey := range input.MuSigKeys {
+			writeBytes(&encoded, pubKey)
+		}
+		writeKeyedBytes(&encoded, input.PubNonces)
+	}
+
+	return encoded.Bytes()
+}
+
+// writeKeyedBytes - writes the count of values, keyed by hex public key,
+// then each key and value sorted by key
+func writeKeyedBytes(w *bytes.Buffer, values map[string][]byte) {
+	var pubKeys []string
+	for pubKey := range values {
+		pubKeys = append(pubKeys, pubKey)
+	}
+	sort.Strings(pubKeys)
+
+	writeUint32(w, uint32(len(pubKeys)))
+	for _, pubKey := range pubKeys {
+		key, _ := hex.DecodeString(pubKey)
+		writeBytes(w, key)
+		writeBytes(w, values[pubKey])
+	}
+}
+
+// readKeyedBytes - reads values written by writeKeyedBytes
+func readKeyedBytes(br *byteReader) map[string][]byte {
+	values := make(map[string][]byte)
+
+	count := br.uint32()
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		pubKey := br.bytes()
+		values[hex.EncodeToString(pubKey)] = br.bytes()
+	}
+
+	return values
+}
+
+// DeserializePSBT - decodes a PSBT encoded by Serialize
+func DeserializePSBT(data []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(data, psbtMagic) {
+		return nil, errors.New("data is not a PSBT")
+	}
+	br := newByteReader(data[len(psbtMagic):])
+
+	var p PSBT
+	tx, err := DeserializeTransaction(br.bytes())
+	if br.err == nil && err != nil {
+		return nil, err
+	}
+	p.Tx = tx
+
+	count := br.uint32()
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		input := PSBTInput{
+			PrevOutput:   readTXOutput(br),
+			RedeemScript: br.bytes(),
+			PartialSigs:  readKeyedBytes(br),
+		}
+
+		keys := br.uint32()
+		for j := uint32(0); j < keys && br.err == nil; j++ {
+			input.MuSigKeys = append(input.MuSigKeys, br.bytes())
+		}
+		input.PubNonces = readKeyedBytes(br)
+
+		p.Inputs = append(p.Inputs, input)
+	}
+
+	if err := br.done(); err != nil {
+		return nil, err
+	}
+	if len(p.Inputs) != len(p.Tx.Vin) {
+		return nil, errors.New("PSBT does not describe every input")
+	}
+
+	return &p, nil
+}
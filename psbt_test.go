@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// roundTripPSBT - p serialized and decoded again
+func roundTripPSBT(t *testing.T, p *PSBT) *PSBT {
+	t.Helper()
+
+	decoded, err := DeserializePSBT(p.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Serialize(), p.Serialize()) {
+		t.Fatal("PSBT encodes differently once decoded")
+	}
+
+	return decoded
+}
+
+func TestPSBTSignCombineFinalize(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	redeemScript, err := MultiSigScript(2, [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevOutputs := []TXOutput{
+		{Value: 4, ScriptPubKey: PayToPubKeyHashScript(HashPubKey(wallets[0].PublicKey))},
+		{Value: 6, ScriptPubKey: PayToScriptHashScript(HashPubKey(redeemScript))},
+	}
+	tx := &Transaction{
+		Version: txVersion,
+		Vin: []TXInput{
+			{Txid: bytes.Repeat([]byte{1}, hashLen), Vout: 0, Sequence: MaxTxInSequenceNum},
+			{Txid: bytes.Repeat([]byte{2}, hashLen), Vout: 1, Sequence: MaxTxInSequenceNum},
+		},
+		Vout: []TXOutput{*NewTXOutput(9, string(NewWallet().GetAddress()))},
+	}
+	tx.SetID()
+
+	p := &PSBT{Tx: tx.TrimmedCopy()}
+	for i, prevOutput := range prevOutputs {
+		input := PSBTInput{PrevOutput: prevOutput, PartialSigs: make(map[string][]byte), PubNonces: make(map[string][]byte)}
+		if i == 1 {
+			input.RedeemScript = redeemScript
+		}
+		p.Inputs = append(p.Inputs, input)
+	}
+
+	// the first holder signs both inputs, the third only the multisig one
+	first := roundTripPSBT(t, p)
+	third := roundTripPSBT(t, p)
+
+	signed, err := first.Sign(wallets[0].PrivateKey, nil)
+	if err != nil || signed != 2 {
+		t.Fatalf("first holder made %d signatures: %v", signed, err)
+	}
+	if signed, _ = first.Sign(wallets[0].PrivateKey, nil); signed != 0 {
+		t.Fatalf("first holder signed again %d times", signed)
+	}
+	if _, err := first.Finalize(); err == nil {
+		t.Fatal("PSBT missing a multisig signature finalized")
+	}
+	first = roundTripPSBT(t, first)
+
+	signed, err = third.Sign(wallets[2].PrivateKey, nil)
+	if err != nil || signed != 1 {
+		t.Fatalf("third holder made %d signatures: %v", signed, err)
+	}
+	third = roundTripPSBT(t, third)
+
+	other := &PSBT{Tx: tx.TrimmedCopy(), Inputs: p.Inputs}
+	other.Tx.LockTime = 1
+	if err := first.Combine(other); err == nil {
+		t.Fatal("PSBTs of different transactions combined")
+	}
+
+	err = first.Combine(third)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Inputs[0].PartialSigs) != 1 || len(first.Inputs[1].PartialSigs) != 2 {
+		t.Fatalf("combined PSBT holds %d and %d signatures, want 1 and 2",
+			len(first.Inputs[0].PartialSigs), len(first.Inputs[1].PartialSigs))
+	}
+	first = roundTripPSBT(t, first)
+
+	final, err := first.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(final.ID, tx.ID) {
+		t.Fatalf("finalized transaction %x, want %x", final.ID, tx.ID)
+	}
+	for i, prevOutput := range prevOutputs {
+		if err := final.VerifyInput(i, prevOutput); err != nil {
+			t.Fatalf("input %d: %v", i, err)
+		}
+	}
+
+	// a signature spoiled in transit is caught before the transaction leaves
+	pubKey := hex.EncodeToString(wallets[0].PublicKey)
+	first.Inputs[0].PartialSigs[pubKey] = append([]byte{}, third.Inputs[1].PartialSigs[hex.EncodeToString(wallets[2].PublicKey)]...)
+	if _, err := first.Finalize(); err == nil {
+		t.Fatal("PSBT with a bad signature finalized")
+	}
+}
+
+func TestDeserializePSBTErrors(t *testing.T) {
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: bytes.Repeat([]byte{1}, hashLen), Vout: 0, Sequence: MaxTxInSequenceNum}},
+		Vout:    []TXOutput{{Value: 1, ScriptPubKey: []byte{Op1}}},
+	}
+	tx.SetID()
+	p := &PSBT{Tx: *tx, Inputs: []PSBTInput{{PrevOutput: TXOutput{Value: 2, ScriptPubKey: []byte{Op1}}}}}
+	data := p.Serialize()
+
+	if _, err := DeserializePSBT(data); err != nil {
+		t.Fatal(err)
+	}
+
+	noInputs := &PSBT{Tx: *tx}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no magic", data[len(psbtMagic):]},
+		{"trailing byte", append(append([]byte{}, data...), 0)},
+		{"truncated", data[:len(data)-1]},
+		{"input not described", noInputs.Serialize()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DeserializePSBT(test.data); err == nil {
+				t.Fatal("PSBT decoded")
+			}
+		})
+	}
+}
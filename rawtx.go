@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NewRawTransaction - an unsigned transaction spending inputs, given by their
+// txid and vout, into outputs. A non zero lockTime keeps it out of blocks
+// before that height or time
+func NewRawTransaction(inputs []TXInput, outputs []TXOutput, lockTime uint32) *Transaction {
+	// the lock time is only enforced while an input is not final
+	sequence := MaxTxInSequenceNum
+	if lockTime != 0 {
+		sequence = MaxTxInSequenceNum - 1
+	}
+
+	tx := Transaction{
+		Version:  txVersion,
+		Vout:     outputs,
+		LockTime: lockTime,
+	}
+	for _, in := range inputs {
+		tx.Vin = append(tx.Vin, TXInput{Txid: in.Txid, Vout: in.Vout, Sequence: sequence})
+	}
+	tx.SetID()
+
+	return &tx
+}
+
+// DecodeRawTransaction - the transaction txHex encodes, in hex, as made by
+// Serialize
+func DecodeRawTransaction(txHex string) (*Transaction, error) {
+	data, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, fmt.Errorf("transaction is not hex: %v", err)
+	}
+
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// ParseRawInputs - the inputs of a comma separated list of txid:vout
+func ParseRawInputs(arg string) ([]TXInput, error) {
+	var inputs []TXInput
+
+	for _, field := range strings.Split(arg, ",") {
+		parts := strings.Split(field, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("input %s is not txid:vout", field)
+		}
+
+		txid, err := hex.DecodeString(parts[0])
+		if err != nil || len(txid) != hashLen {
+			return nil, fmt.Errorf("input %s has a bad txid", field)
+		}
+
+		vout, err := strconv.ParseUint(parts[1], 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("input %s has a bad vout", field)
+		}
+
+		inputs = append(inputs, TXInput{Txid: txid, Vout: int(vout)})
+	}
+
+	return inputs, nil
+}
+
+// ParseRawOutputs - the outputs of a comma separated list of address:amount
+func ParseRawOutputs(arg string) ([]TXOutput, error) {
+	var outputs []TXOutput
+
+	for _, field := range strings.Split(arg, ",") {
+		parts := strings.Split(field, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("output %s is not address:amount", field)
+		}
+
+		if !ValidateAddress(parts[0]) {
+			return nil, fmt.Errorf("output %s has an invalid address", field)
+		}
+
+		amount, err := strconv.Atoi(parts[1])
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("output %s has a bad amount", field)
+		}
+
+		outputs = append(outputs, *NewTXOutput(amount, parts[0]))
+	}
+
+	return outputs, nil
+}
+
+// SignRawTransaction - signs every input of tx it holds the keys for, given
+// the outputs they spend. Inputs spending a multisig address of the wallet
+// get its redeem script, and keep the signatures already on them. Returns
+// whether every input now checks out
+func (ws *Wallets) SignRawTransaction(tx *Transaction, prevOutputs []TXOutput) (bool, error) {
+	if ws.IsLocked() {
+		return false, ErrWalletLocked
+	}
+
+	complete := true
+	for i, prevOutput := range prevOutputs {
+		if tx.VerifyInput(i, prevOutput) == nil {
+			continue
+		}
+
+		script := prevOutput.ScriptPubKey
+		switch GetScriptClass(script) {
+		case PubKeyHashTy:
+			if wallet := ws.GetWalletByKeyHash(ExtractPubKeyHash(script)); wallet != nil {
+				err := tx.SignInput(wallet.PrivateKey, i, prevOutput, SigHashAll)
+				if err != nil {
+					return false, err
+				}
+			}
+		case SchnorrPubKeyTy:
+			if wallet := ws.Wallets[ExtractAddress(script)]; wallet != nil {
+				err := tx.SignInput(wallet.PrivateKey, i, prevOutput, SigHashAll)
+				if err != nil {
+					return false, err
+				}
+			}
+		case ScriptHashTy:
+			redeemScript, ok := ws.RedeemScripts[ExtractAddress(script)]
+			if !ok {
+				break
+			}
+			if !bytes.Equal(redeemScriptOf(tx.Vin[i].ScriptSig), redeemScript) {
+				tx.Vin[i].ScriptSig = NewScriptBuilder().AddData(redeemScript).Script()
+			}
+
+			for _, privKey := range ws.GetMultiSigKeys(redeemScript) {
+				if tx.VerifyInput(i, prevOutput) == nil {
+					break
+				}
+				err := tx.SignInput(privKey, i, prevOutput, SigHashAll)
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+
+		if tx.VerifyInput(i, prevOutput) != nil {
+			complete = false
+		}
+	}
+
+	return complete, nil
+}
+
+// PrevOutputs - the outputs spent by the inputs of tx, found on the chain
+func (bc *Blockchain) PrevOutputs(tx *Transaction) ([]TXOutput, error) {
+	var prevOutputs []TXOutput
+
+	for _, vin := range tx.Vin {
+		prevTX, err := bc.FindTransaction(vin.Txid)
+		if err != nil {
+			return nil, err
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return nil, fmt.Errorf("transaction %x has no output %d", vin.Txid, vin.Vout)
+		}
+
+		prevOutputs = append(prevOutputs, prevTX.Vout[vin.Vout])
+	}
+
+	return prevOutputs, nil
+}
+
+type scriptJSON struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Type    string `json:"type,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+type txInputJSON struct {
+	TxID      string      `json:"txid,omitempty"`
+	Vout      int         `json:"vout"`
+	Coinbase  string      `json:"coinbase,omitempty"`
+	ScriptSig *scriptJSON `json:"scriptSig,omitempty"`
+	Sequence  uint32      `json:"sequence"`
+}
+
+type txOutputJSON struct {
+	Value        int        `json:"value"`
+	N            int        `json:"n"`
+	ScriptPubKey scriptJSON `json:"scriptPubKey"`
+}
+
+type txJSON struct {
+	TxID     string         `json:"txid"`
+	Version  int32          `json:"version"`
+	Size     int            `json:"size"`
+	Vin      []txInputJSON  `json:"vin"`
+	Vout     []txOutputJSON `json:"vout"`
+	LockTime uint32         `json:"locktime"`
+}
+
+// JSON - the transaction as String shows it, in indented JSON
+func (tx *Transaction) JSON() ([]byte, error) {
+	decoded := txJSON{
+		TxID:     hex.EncodeToString(tx.ID),
+		Version:  tx.Version,
+		Size:     len(tx.Serialize()),
+		Vin:      []txInputJSON{},
+		Vout:     []txOutputJSON{},
+		LockTime: tx.LockTime,
+	}
+
+	for _, vin := range tx.Vin {
+		input := txInputJSON{Vout: vin.Vout, Sequence: vin.Sequence}
+		if tx.IsCoinbase() {
+			input.Coinbase = hex.EncodeToString(vin.ScriptSig)
+		} else {
+			input.TxID = hex.EncodeToString(vin.Txid)
+			input.ScriptSig = &scriptJSON{Asm: DisasmString(vin.ScriptSig), Hex: hex.EncodeToString(vin.ScriptSig)}
+		}
+		decoded.Vin = append(decoded.Vin, input)
+	}
+
+	for i, vout := range tx.Vout {
+		decoded.Vout = append(decoded.Vout, txOutputJSON{
+			Value: vout.Value,
+			N:     i,
+			ScriptPubKey: scriptJSON{
+				Asm:     DisasmString(vout.ScriptPubKey),
+				Hex:     hex.EncodeToString(vout.ScriptPubKey),
+				Type:    GetScriptClass(vout.ScriptPubKey).String(),
+				Address: ExtractAddress(vout.ScriptPubKey),
+			},
+		})
+	}
+
+	return json.MarshalIndent(decoded, "", "  ")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRawTransaction(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	inputs, err := ParseRawInputs(strings.Repeat("ab", hashLen) + ":1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := ParseRawOutputs(address + ":5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := NewRawTransaction(inputs, outputs, 7)
+	// an unlocking script cut off in the middle of a push still decodes,
+	// and shows as broken
+	tx.Vin[0].ScriptSig = []byte{OpPushData1, 10, 1}
+	txHex := hex.EncodeToString(tx.Serialize())
+
+	decoded, err := DecodeRawTransaction(txHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.ID, tx.ID) || decoded.LockTime != 7 || decoded.Vin[0].Sequence != MaxTxInSequenceNum-1 {
+		t.Fatalf("transaction decodes as %v", decoded)
+	}
+	if !strings.Contains(decoded.String(), "[error:") {
+		t.Fatalf("broken unlocking script not shown:\n%v", decoded)
+	}
+
+	data, err := decoded.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["txid"] != hex.EncodeToString(tx.ID) {
+		t.Fatalf("JSON has txid %v, want %x", fields["txid"], tx.ID)
+	}
+
+	var hugeCount bytes.Buffer
+	writeUint32(&hugeCount, txVersion)
+	writeUint32(&hugeCount, 0xffffffff)
+
+	var hugeScript bytes.Buffer
+	writeUint32(&hugeScript, txVersion)
+	writeUint32(&hugeScript, 1)
+	writeUint32(&hugeScript, 0xffffffff)
+
+	tests := []struct {
+		name  string
+		txHex string
+	}{
+		{"empty", ""},
+		{"not hex", "zz" + txHex[2:]},
+		{"odd length", txHex[1:]},
+		{"truncated", txHex[:len(txHex)-2]},
+		{"trailing byte", txHex + "00"},
+		{"unknown version", "00000009" + txHex[8:]},
+		{"huge input count", hex.EncodeToString(hugeCount.Bytes())},
+		{"huge txid length", hex.EncodeToString(hugeScript.Bytes())},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if tx, err := DecodeRawTransaction(test.txHex); err == nil {
+				t.Fatalf("decoded %v", tx)
+			}
+		})
+	}
+}
+
+func TestParseRawErrors(t *testing.T) {
+	txid := strings.Repeat("ab", hashLen)
+	address := string(NewWallet().GetAddress())
+
+	inputs := []string{"", txid, txid + ":", txid + ":-1", txid + ":x", txid[2:] + ":0", "zz" + txid[2:] + ":0", txid + ":0:1", txid + ":0,"}
+	for _, arg := range inputs {
+		if _, err := ParseRawInputs(arg); err == nil {
+			t.Errorf("inputs %q parsed", arg)
+		}
+	}
+
+	outputs := []string{"", address, address + ":0", address + ":-5", address + ":x", "1abc:5", address + ":5,"}
+	for _, arg := range outputs {
+		if _, err := ParseRawOutputs(arg); err == nil {
+			t.Errorf("outputs %q parsed", arg)
+		}
+	}
+}
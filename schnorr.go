@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+const (
+	// schnorrPubKeyLen - BIP340 public keys are the x coordinate alone, the
+	// point with the even y being meant
+	schnorrPubKeyLen = 32
+	// schnorrSigLen - BIP340 signatures are the x coordinate of R and s
+	schnorrSigLen = 64
+)
+
+// taggedHash - the BIP340 hash of msgs under tag, which keeps hashes made for
+// one purpose from being reused for another
+func taggedHash(tag string, msgs ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+
+	var hash [32]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// schnorrPubKey - the x-only public key of a secp256k1 key
+func schnorrPubKey(pub *ecdsa.PublicKey) []byte {
+	pubKey := make([]byte, schnorrPubKeyLen)
+	pub.X.FillBytes(pubKey)
+
+	return pubKey
+}
+
+// liftX - the point with the x coordinate pubKey and an even y
+func liftX(pubKey []byte) (*secp256k1.JacobianPoint, error) {
+	if len(pubKey) != schnorrPubKeyLen {
+		return nil, errors.New("schnorr public key must be 32 bytes")
+	}
+
+	var p secp256k1.JacobianPoint
+	if p.X.SetByteSlice(pubKey) {
+		return nil, errors.New("schnorr public key is not below the field size")
+	}
+	if !secp256k1.DecompressY(&p.X, false, &p.Y) {
+		return nil, errors.New("schnorr public key is not on the curve")
+	}
+	p.Z.SetInt(1)
+
+	return &p, nil
+}
+
+// schnorrChallenge - e = hash(R.x || P.x || m) mod n
+func schnorrChallenge(r, pubKey, hash []byte) secp256k1.ModNScalar {
+	var e secp256k1.ModNScalar
+	challenge := taggedHash("BIP0340/challenge", r, pubKey, hash)
+	e.SetBytes(&challenge)
+
+	return e
+}
+
+// schnorrSign - signs a 32 byte hash as in BIP340. The auxiliary randomness is
+// left at zero, so the nonce only depends on the key and the hash, as with
+// signHash
+func schnorrSign(privKey ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	var d secp256k1.ModNScalar
+	if d.SetByteSlice(privKey.D.Bytes()) || d.IsZero() {
+		return nil, errors.New("private key is out of range")
+	}
+
+	var p secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&d, &p)
+	p.ToAffine()
+	if p.Y.IsOdd() {
+		d.Negate()
+	}
+	pubKey := p.X.Bytes()
+
+	// t = d xor hash(aux), with aux all zeros
+	dBytes := d.Bytes()
+	aux := taggedHash("BIP0340/aux", make([]byte, 32))
+	t := make([]byte, 32)
+	for i := range t {
+		t[i] = dBytes[i] ^ aux[i]
+	}
+
+	var k secp256k1.ModNScalar
+	nonce := taggedHash("BIP0340/nonce", t, pubKey[:], hash)
+	k.SetBytes(&nonce)
+	if k.IsZero() {
+		return nil, errors.New("schnorr nonce is zero")
+	}
+
+	var r secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&k, &r)
+	r.ToAffine()
+	if r.Y.IsOdd() {
+		k.Negate()
+	}
+	rBytes := r.X.Bytes()
+
+	// s = k + e*d
+	e := schnorrChallenge(rBytes[:], pubKey[:], hash)
+	s := new(secp256k1.ModNScalar).Mul2(&e, &d).Add(&k)
+	sBytes := s.Bytes()
+
+	signature := append(rBytes[:], sBytes[:]...)
+	if !schnorrVerify(pubKey[:], signature, hash) {
+		return nil, errors.New("schnorr signature did not verify")
+	}
+
+	return signature, nil
+}
+
+// parseSchnorrSig - splits a signature into R, lifted from its x coordinate,
+// and s, failing for any encoding out of range
+func parseSchnorrSig(signature []byte) (*secp256k1.JacobianPoint, *secp256k1.ModNScalar, error) {
+	if len(signature) != schnorrSigLen {
+		return nil, nil, errors.New("schnorr signature must be 64 bytes")
+	}
+
+	r, err := liftX(signature[:32])
+	if err != nil {
+		return nil, nil, errors.New("schnorr signature R is not a point")
+	}
+
+	var s secp256k1.ModNScalar
+	if s.SetByteSlice(signature[32:]) {
+		return nil, nil, errors.New("schnorr signature s is out of range")
+	}
+
+	return r, &s, nil
+}
+
+// schnorrVerify - checks a BIP340 signature: s*G - e*P must be a point with an
+// even y and the x coordinate of R
+func schnorrVerify(pubKey, signature, hash []byte) bool {
+	p, err := liftX(pubKey)
+	if err != nil || len(signature) != schnorrSigLen {
+		return false
+	}
+
+	var r secp256k1.FieldVal
+	if r.SetByteSlice(signature[:32]) {
+		return false
+	}
+
+	var s secp256k1.ModNScalar
+	if s.SetByteSlice(signature[32:]) {
+		return false
+	}
+
+	e := schnorrChallenge(signature[:32], pubKey, hash)
+	e.Negate()
+
+	var sG, eP, result secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&s, &sG)
+	secp256k1.ScalarMultNonConst(&e, p, &eP)
+	secp256k1.AddNonConst(&sG, &eP, &result)
+
+	if isInfinity(&result) {
+		return false
+	}
+	result.ToAffine()
+
+	return !result.Y.IsOdd() && result.X.Equals(&r)
+}
+
+// SchnorrBatch - Schnorr signatures put aside to be checked together, which
+// takes far fewer point multiplications than checking them one at a time
+type SchnorrBatch struct {
+	pubKeys    [][]byte
+	signatures [][]byte
+	hashes     [][]byte
+}
+
+// Add - puts a signature in the batch. Signatures that cannot be parsed are
+// turned away here, the rest are only known to be good once Verify passes
+func (b *SchnorrBatch) Add(pubKey, signature, hash []byte) error {
+	_, err := liftX(pubKey)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = parseSchnorrSig(signature)
+	if err != nil {
+		return err
+	}
+
+	b.pubKeys = append(b.pubKeys, pubKey)
+	b.signatures = append(b.signatures, signature)
+	b.hashes = append(b.hashes, hash)
+
+	return nil
+}
+
+// Len - the number of signatures in the batch
+func (b *SchnorrBatch) Len() int {
+	return len(b.signatures)
+}
+
+// Verify - checks every signature in the batch at once. Each equation
+// s*G = R + e*P is weighted by a random a, drawn from a hash of the whole
+// batch so no signer can pick it, and the weighted sum
+//
+//	(a1*s1 + a2*s2 + ...)*G - a1*R1 - a1*e1*P1 - a2*R2 - a2*e2*P2 - ...
+//
+// must be the point at infinity
+func (b *SchnorrBatch) Verify() bool {
+	if len(b.signatures) == 0 {
+		return true
+	}
+
+	seed := sha256.New()
+	for i := range b.signatures {
+		seed.Write(b.pubKeys[i])
+		seed.Write(b.hashes[i])
+		seed.Write(b.signatures[i])
+	}
+	seedHash := seed.Sum(nil)
+
+	var sum secp256k1.ModNScalar
+	scalars := make([]secp256k1.ModNScalar, 0, 2*len(b.signatures))
+	points := make([]secp256k1.JacobianPoint, 0, 2*len(b.signatures))
+
+	for i := range b.signatures {
+		p, err := liftX(b.pubKeys[i])
+		if err != nil {
+			return false
+		}
+		r, s, err := parseSchnorrSig(b.signatures[i])
+		if err != nil {
+			return false
+		}
+
+		// the first weight can be one without weakening the check
+		var a secp256k1.ModNScalar
+		a.SetInt(1)
+		if i > 0 {
+			index := make([]byte, 4)
+			binary.BigEndian.PutUint32(index, uint32(i))
+			weight := taggedHash("mblah/batch", seedHash, index)
+			a.SetBytes(&weight)
+		}
+
+		e := schnorrChallenge(b.signatures[i][:32], b.pubKeys[i], b.hashes[i])
+
+		sum.Add(new(secp256k1.ModNScalar).Mul2(&a, s))
+		scalars = append(scalars, *new(secp256k1.ModNScalar).NegateVal(&a))
+		points = append(points, *r)
+		scalars = append(scalars, *new(secp256k1.ModNScalar).Mul2(&a, &e).Negate())
+		points = append(points, *p)
+	}
+
+	var sumG, rest, result secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&sum, &sumG)
+	multiScalarMult(scalars, points, &rest)
+	secp256k1.AddNonConst(&sumG, &rest, &result)
+
+	return isInfinity(&result)
+}
+
+// multiScalarMult - the sum of scalars[i]*points[i], by Pippenger's bucket
+// method: each window of scalar bits sorts the points into buckets by the
+// value of the window, and the buckets are summed with one running total
+func multiScalarMult(scalars []secp256k1.ModNScalar, points []secp256k1.JacobianPoint, result *secp256k1.JacobianPoint) {
+	window := 4
+	if len(points) >= 64 {
+		window = 6
+	}
+	if len(points) >= 512 {
+		window = 8
+	}
+
+	scalarBytes := make([][32]byte, len(scalars))
+	for i := range scalars {
+		scalarBytes[i] = scalars[i].Bytes()
+	}
+
+	var acc, tmp secp256k1.JacobianPoint
+	buckets := make([]secp256k1.JacobianPoint, 1<<uint(window)-1)
+
+	for start := (255 / window) * window; start >= 0; start -= window {
+		for i := 0; i < window; i++ {
+			secp256k1.DoubleNonConst(&acc, &tmp)
+			acc.Set(&tmp)
+		}
+
+		for i := range buckets {
+			buckets[i] = secp256k1.JacobianPoint{}
+		}
+
+		for i := range points {
+			digit := scalarWindow(&scalarBytes[i], start, window)
+			if digit == 0 {
+				continue
+			}
+			secp256k1.AddNonConst(&buckets[digit-1], &points[i], &tmp)
+			buckets[digit-1].Set(&tmp)
+		}
+
+		// running holds the buckets from the top down, so adding it to total
+		// once per bucket counts each bucket by its value
+		var running, total secp256k1.JacobianPoint
+		for i := len(buckets) - 1; i >= 0; i-- {
+			secp256k1.AddNonConst(&running, &buckets[i], &tmp)
+			running.Set(&tmp)
+			secp256k1.AddNonConst(&total, &running, &tmp)
+			total.Set(&tmp)
+		}
+
+		secp256k1.AddNonConst(&acc, &total, &tmp)
+		acc.Set(&tmp)
+	}
+
+	result.Set(&acc)
+}
+
+// scalarWindow - the count bits of a big-endian scalar from bit start up
+func scalarWindow(scalar *[32]byte, start, count int) int {
+	value := 0
+	for bit := start + count - 1; bit >= start; bit-- {
+		value <<= 1
+		if bit < 256 {
+			value |= int(scalar[31-bit/8]>>uint(bit%8)) & 1
+		}
+	}
+
+	return value
+}
+
+// isInfinity - checks for the point at infinity
+func isInfinity(p *secp256k1.JacobianPoint) bool {
+	return (p.X.IsZero() && p.Y.IsZero()) || p.Z.IsZero()
+}
+
+// schnorrKeyMatches - checks whether a private key signs for an x-only key
+func schnorrKeyMatches(privKey ecdsa.PrivateKey, pubKey []byte) bool {
+	return privKey.Curve == secp256k1.S256() && bytes.Equal(schnorrPubKey(&privKey.PublicKey), pubKey)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// bip340Vectors - the test vectors of BIP340. schnorrSign always uses zero
+// auxiliary randomness, so it can only reproduce those signed with it
+var bip340Vectors = []struct {
+	key, pubKey, aux, msg, sig string
+	valid                      bool
+}{
+	{"0000000000000000000000000000000000000000000000000000000000000003",
+		"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0", true},
+	{"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A", true},
+	{"C90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B14E5C9",
+		"DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+		"C87AA53824B4D7AE2EB035A2B5BBBCCC080E76CDC6D1692C4B0B62D798E6D906",
+		"7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+		"5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7", true},
+	{"0B432B2677937381AEF05BB02A66ECD012773062CF3FA2549E44F58ED2401710",
+		"25D1DFF95105F5253C4022F628A996AD3A0D95FBF21D468A1B33F8C160D8F517",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		"7EB0509757E246F19449885651611CB965ECC1A187DD51B64FDA1EDC9637D5EC97582B9CB13DB3933705B32BA982AF5AF25FD78881EBB32771FC5922EFC66EA3", true},
+	{"", "D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9", "",
+		"4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703",
+		"00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4", true},
+	// public key not on the curve
+	{"", "EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B", false},
+	// R has an odd y
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"FFF97BD5755EEEA420453A14355235D382F6472F8568A18B2F057A14602975563CC27944640AC607CD107AE10923D9EF7A73C643E166BE5EBEAFA34B1AC553E2", false},
+	// negated message
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"1FA62E331EDBC21C394792D2AB1100A7B432B013DF3F6FF4F99FCB33E0E1515F28890B3EDB6E7189B630448B515CE4F8622A954CFE545735AAEA5134FCCDB2BD", false},
+	// negated s
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769961764B3AA9B2FFCB6EF947B6887A226E8D7C93E00C5ED0C1834FF0D0C2E6DA6", false},
+	// s*G - e*P is infinity
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"0000000000000000000000000000000000000000000000000000000000000000123DDA8328AF9C23A94C1FEECFD123BA4FB73476F0D594DCB65C6425BD186051", false},
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"00000000000000000000000000000000000000000000000000000000000000017615FBAF5AE28864013C099742DEADB4DBA87F11AC6754F93780D5A1837CF197", false},
+	// r is not the x coordinate of a point
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"4A298DACAE57395A15D0795DDBFD1DCB564DA82B0F269BC70A74F8220429BA1D69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B", false},
+	// r is the field size
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B", false},
+	// s is the curve order
+	{"", "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", false},
+	// public key is not a field element
+	{"", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30", "",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B", false},
+}
+
+func TestSchnorrSignBIP340(t *testing.T) {
+	zeroAux := strings.Repeat("0", 64)
+
+	for i, test := range bip340Vectors {
+		if test.key == "" || test.aux != zeroAux {
+			continue
+		}
+
+		privKey := testPrivKey(t, secp256k1.S256(), test.key)
+		if pubKey := schnorrPubKey(&privKey.PublicKey); !bytes.Equal(pubKey, mustHex(t, test.pubKey)) {
+			t.Fatalf("vector %d: public key %X, want %s", i, pubKey, test.pubKey)
+		}
+
+		signature, err := schnorrSign(privKey, mustHex(t, test.msg))
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if !bytes.Equal(signature, mustHex(t, test.sig)) {
+			t.Fatalf("vector %d: signature %X, want %s", i, signature, test.sig)
+		}
+	}
+}
+
+func TestSchnorrVerifyBIP340(t *testing.T) {
+	for i, test := range bip340Vectors {
+		valid := schnorrVerify(mustHex(t, test.pubKey), mustHex(t, test.sig), mustHex(t, test.msg))
+		if valid != test.valid {
+			t.Errorf("vector %d: verified %v, want %v", i, valid, test.valid)
+		}
+	}
+}
+
+func TestSchnorrBatch(t *testing.T) {
+	var batch SchnorrBatch
+	for i, test := range bip340Vectors {
+		if !test.valid {
+			continue
+		}
+
+		err := batch.Add(mustHex(t, test.pubKey), mustHex(t, test.sig), mustHex(t, test.msg))
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+	}
+
+	for i := 0; i < 40; i++ {
+		wallet := NewSchnorrWallet()
+		hash := make([]byte, 32)
+		hash[0] = byte(i)
+
+		signature, err := schnorrSign(wallet.PrivateKey, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = batch.Add(schnorrPubKey(&wallet.PrivateKey.PublicKey), signature, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !batch.Verify() {
+		t.Fatal("batch of good signatures failed")
+	}
+
+	for i := 0; i < batch.Len(); i += 11 {
+		bad := SchnorrBatch{
+			pubKeys:    batch.pubKeys,
+			signatures: batch.signatures,
+			hashes:     append([][]byte{}, batch.hashes...),
+		}
+		bad.hashes[i] = make([]byte, 32)
+		bad.hashes[i][31] = 1
+
+		if bad.Verify() {
+			t.Fatalf("batch with signature %d over the wrong hash verified", i)
+		}
+	}
+
+	// signatures that cannot be parsed never make it into the batch
+	for i, test := range bip340Vectors[11:] {
+		err := batch.Add(mustHex(t, test.pubKey), mustHex(t, test.sig), mustHex(t, test.msg))
+		if err == nil {
+			t.Errorf("vector %d added", 11+i)
+		}
+	}
+}
+
+// muSigSigners - n secp256k1 keys and their compressed public keys
+func muSigSigners(n int) ([]ecdsa.PrivateKey, [][]byte) {
+	var privKeys []ecdsa.PrivateKey
+	var pubKeys [][]byte
+	for i := 0; i < n; i++ {
+		wallet := NewWallet()
+		privKeys = append(privKeys, wallet.PrivateKey)
+		pubKeys = append(pubKeys, wallet.PublicKey)
+	}
+
+	return privKeys, pubKeys
+}
+
+func TestMuSig(t *testing.T) {
+	privKeys, pubKeys := muSigSigners(3)
+	hash := mustHex(t, strings.Repeat("42", 32))
+
+	key, err := AggregateKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed, err := AggregateKeys([][]byte{pubKeys[2], pubKeys[1], pubKeys[0]})
+	if err != nil || !bytes.Equal(reversed.XOnly(), key.XOnly()) {
+		t.Fatal("order of the keys changed the aggregate key")
+	}
+
+	// signers go in the order of the sorted keys
+	var sorted []ecdsa.PrivateKey
+	for _, pubKey := range key.PubKeys {
+		for _, privKey := range privKeys {
+			if bytes.Equal(pubKeyBytes(&privKey.PublicKey), pubKey) {
+				sorted = append(sorted, privKey)
+			}
+		}
+	}
+
+	signature, err := musigSign(sorted, key, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !schnorrVerify(key.XOnly(), signature, hash) {
+		t.Fatal("musig signature does not verify")
+	}
+
+	_, err = musigSign(sorted[:2], key, hash)
+	if err == nil {
+		t.Fatal("signed without every key")
+	}
+}
+
+func TestMuSigRounds(t *testing.T) {
+	privKeys, pubKeys := muSigSigners(2)
+	hash := mustHex(t, strings.Repeat("42", 32))
+
+	key, err := AggregateKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nonces []*MuSigNonce
+	var publicNonces [][]byte
+	for _, privKey := range privKeys {
+		nonce, err := NewMuSigNonce(privKey, key, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the nonce is kept between the rounds by its secret
+		kept, err := MuSigNonceFromSecret(nonce.Secret())
+		if err != nil || !bytes.Equal(kept.Public, nonce.Public) {
+			t.Fatalf("kept nonce %v", err)
+		}
+
+		nonces = append(nonces, kept)
+		publicNonces = append(publicNonces, nonce.Public)
+	}
+
+	tests := []struct {
+		name         string
+		publicNonces [][]byte
+	}{
+		{"too few", publicNonces[:1]},
+		{"too many", append(append([][]byte{}, publicNonces...), publicNonces[0])},
+		{"short", [][]byte{publicNonces[0], publicNonces[1][1:]}},
+		{"not a point", [][]byte{publicNonces[0], make([]byte, len(publicNonces[1]))}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewMuSigSession(key, test.publicNonces, hash)
+			if err == nil {
+				t.Fatal("session made")
+			}
+		})
+	}
+
+	session, err := NewMuSigSession(key, publicNonces, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var partialSigs [][]byte
+	for i, privKey := range privKeys {
+		partialSig, err := session.PartialSign(privKey, nonces[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		partialSigs = append(partialSigs, partialSig)
+	}
+
+	signature, err := session.Aggregate(partialSigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !schnorrVerify(key.XOnly(), signature, hash) {
+		t.Fatal("musig signature does not verify")
+	}
+
+	_, err = session.Aggregate(partialSigs[:1])
+	if err == nil {
+		t.Fatal("aggregated without every partial signature")
+	}
+
+	_, err = MuSigNonceFromSecret(make([]byte, 64))
+	if err == nil {
+		t.Fatal("zero nonce accepted")
+	}
+	_, err = MuSigNonceFromSecret(nonces[0].Secret()[1:])
+	if err == nil {
+		t.Fatal("short nonce accepted")
+	}
+
+	if hex.EncodeToString(nonces[0].Secret()) == hex.EncodeToString(nonces[1].Secret()) {
+		t.Fatal("signers drew the same nonce")
+	}
+}
@@ -0,0 +1,998 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// opcodes understood by the script engine. The numbering follows Bitcoin so
+// scripts read the same in both
+const (
+	Op0         = 0x00
+	OpPushData1 = 0x4c
+	OpPushData2 = 0x4d
+	Op1Negate   = 0x4f
+	OpReserved  = 0x50
+	Op1         = 0x51
+	Op16        = 0x60
+
+	OpNop    = 0x61
+	OpIf     = 0x63
+	OpNotIf  = 0x64
+	OpElse   = 0x67
+	OpEndIf  = 0x68
+	OpVerify = 0x69
+	OpReturn = 0x6a
+
+	OpDrop = 0x75
+	OpDup  = 0x76
+	OpSwap = 0x7c
+	OpSize = 0x82
+
+	OpEqual       = 0x87
+	OpEqualVerify = 0x88
+
+	Op1Add               = 0x8b
+	Op1Sub               = 0x8c
+	OpNot                = 0x91
+	OpAdd                = 0x93
+	OpSub                = 0x94
+	OpBoolAnd            = 0x9a
+	OpBoolOr             = 0x9b
+	OpNumEqual           = 0x9c
+	OpNumEqualVerify     = 0x9d
+	OpLessThan           = 0x9f
+	OpGreaterThan        = 0xa0
+	OpLessThanOrEqual    = 0xa1
+	OpGreaterThanOrEqual = 0xa2
+	OpMin                = 0xa3
+	OpMax                = 0xa4
+	OpWithin             = 0xa5
+
+	OpSHA256              = 0xa8
+	OpHash160             = 0xa9
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultiSig       = 0xae
+	OpCheckMultiSigVerify = 0xaf
+
+	OpCheckLockTimeVerify = 0xb1
+	OpCheckSequenceVerify = 0xb2
+)
+
+const (
+	maxScriptSize         = 10000
+	maxScriptElementSize  = 520
+	maxOpsPerScript       = 201
+	maxStackSize          = 1000
+	maxPubKeysPerMultiSig = 20
+	// maxScriptNumLen - arithmetic works on numbers of up to 4 bytes
+	maxScriptNumLen = 4
+)
+
+var opcodeNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	Op1Negate:             "OP_1NEGATE",
+	OpNop:                 "OP_NOP",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpSwap:                "OP_SWAP",
+	OpSize:                "OP_SIZE",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	Op1Add:                "OP_1ADD",
+	Op1Sub:                "OP_1SUB",
+	OpNot:                 "OP_NOT",
+	OpAdd:                 "OP_ADD",
+	OpSub:                 "OP_SUB",
+	OpBoolAnd:             "OP_BOOLAND",
+	OpBoolOr:              "OP_BOOLOR",
+	OpNumEqual:            "OP_NUMEQUAL",
+	OpNumEqualVerify:      "OP_NUMEQUALVERIFY",
+	OpLessThan:            "OP_LESSTHAN",
+	OpGreaterThan:         "OP_GREATERTHAN",
+	OpLessThanOrEqual:     "OP_LESSTHANOREQUAL",
+	OpGreaterThanOrEqual:  "OP_GREATERTHANOREQUAL",
+	OpMin:                 "OP_MIN",
+	OpMax:                 "OP_MAX",
+	OpWithin:              "OP_WITHIN",
+	OpSHA256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+var (
+	errScriptTruncated = errors.New("script ends in the middle of a push")
+	errScriptFailed    = errors.New("script finished with a false value on the stack")
+)
+
+// parsedOp - a single opcode and the data it pushes, if any
+type parsedOp struct {
+	opcode byte
+	data   []byte
+}
+
+// isPush - checks whether the op only pushes data onto the stack
+func (op *parsedOp) isPush() bool {
+	return op.opcode <= Op16 && op.opcode != OpReserved
+}
+
+// parseScript - splits a script into its ops
+func parseScript(script []byte) ([]parsedOp, error) {
+	var ops []parsedOp
+
+	for i := 0; i < len(script); {
+		op := parsedOp{opcode: script[i]}
+		i++
+
+		var dataLen int
+		switch {
+		case op.opcode > Op0 && op.opcode < OpPushData1:
+			dataLen = int(op.opcode)
+		case op.opcode == OpPushData1:
+			if i+1 > len(script) {
+				return nil, errScriptTruncated
+			}
+			dataLen = int(script[i])
+			i++
+		case op.opcode == OpPushData2:
+			if i+2 > len(script) {
+				return nil, errScriptTruncated
+			}
+			dataLen = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+
+		if i+dataLen > len(script) {
+			return nil, errScriptTruncated
+		}
+		if dataLen > 0 {
+			op.data = script[i : i+dataLen]
+			i += dataLen
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// IsPushOnly - checks whether the script does nothing but push data
+func IsPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+
+	for _, op := range ops {
+		if !op.isPush() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DisasmString - the human readable form of a script
+func DisasmString(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[error: %v] %x", err, script)
+	}
+
+	var words []string
+	for _, op := range ops {
+		if op.data != nil {
+			words = append(words, hex.EncodeToString(op.data))
+		} else {
+			words = append(words, opName(op.opcode))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// ScriptBuilder - builds a script one op at a time, using the shortest push
+// for each piece of data
+type ScriptBuilder struct {
+	script []byte
+}
+
+// NewScriptBuilder - get an empty builder
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+// AddOp - append an opcode
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// AddData - append a push of data
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	dataLen := len(data)
+
+	switch {
+	case dataLen == 0:
+		b.script = append(b.script, Op0)
+	case dataLen < OpPushData1:
+		b.script = append(b.script, byte(dataLen))
+	case dataLen <= 0xff:
+		b.script = append(b.script, OpPushData1, byte(dataLen))
+	default:
+		b.script = append(b.script, OpPushData2, byte(dataLen), byte(dataLen>>8))
+	}
+	b.script = append(b.script, data...)
+
+	return b
+}
+
+// AddInt64 - append a push of a number, using the small integer opcodes
+// where they fit
+func (b *ScriptBuilder) AddInt64(n int64) *ScriptBuilder {
+	switch {
+	case n == 0:
+		b.script = append(b.script, Op0)
+	case n == -1:
+		b.script = append(b.script, Op1Negate)
+	case n >= 1 && n <= 16:
+		b.script = append(b.script, byte(Op1-1+n))
+	default:
+		b.AddData(scriptNumBytes(n))
+	}
+
+	return b
+}
+
+// Script - the script built so far
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// scriptNumBytes - encodes a number as little-endian sign-magnitude, the way
+// numbers live on the stack
+func scriptNumBytes(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// makeScriptNum - decodes a number from the stack, which must be minimally
+// encoded and no longer than maxLen
+func makeScriptNum(v []byte, maxLen int) (int64, error) {
+	if len(v) > maxLen {
+		return 0, fmt.Errorf("number of %d bytes is longer than %d", len(v), maxLen)
+	}
+
+	if len(v) == 0 {
+		return 0, nil
+	}
+
+	// the last byte may only be 0x00 or 0x80 if it is needed for the sign
+	if v[len(v)-1]&0x7f == 0 {
+		if len(v) == 1 || v[len(v)-2]&0x80 == 0 {
+			return 0, errors.New("number is not minimally encoded")
+		}
+	}
+
+	var result int64
+	for i, b := range v {
+		result |= int64(b) << uint(8*i)
+	}
+
+	if v[len(v)-1]&0x80 != 0 {
+		result &= ^(int64(0x80) << uint(8*(len(v)-1)))
+		return -result, nil
+	}
+
+	return result, nil
+}
+
+// asBool - the truth value of a stack element. Zero and negative zero are false
+func asBool(v []byte) bool {
+	for i, b := range v {
+		if b != 0 {
+			// negative zero
+			if i == len(v)-1 && b == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// scriptEngine - runs the unlocking script of an input followed by the
+// locking script of the output it spends
+type scriptEngine struct {
+	tx         *Transaction
+	inputIndex int
+	prevOutput TXOutput
+	// scriptCode - the script signatures are checked against, the locking
+	// script or, for pay-to-script-hash, the redeem script
+	scriptCode []byte
+	// batch - when set, Schnorr signatures checked by OP_CHECKSIG are put
+	// here to be verified with the rest of the block instead of one by one
+	batch *SchnorrBatch
+
+	stack     [][]byte
+	condStack []bool
+	numOps    int
+}
+
+// VerifyScript - checks that the unlocking script of input inputIndex satisfies
+// prevOutput's locking script. A pay-to-script-hash lock is also checked
+// against the redeem script that the unlocking script pushed last, run on
+// what the unlocking script left behind
+func VerifyScript(tx *Transaction, inputIndex int, prevOutput TXOutput) error {
+	return verifyScript(tx, inputIndex, prevOutput, nil)
+}
+
+// verifyScript - VerifyScript, leaving the Schnorr signatures of OP_CHECKSIG
+// to batch if it is set
+func verifyScript(tx *Transaction, inputIndex int, prevOutput TXOutput, batch *SchnorrBatch) error {
+	if inputIndex < 0 || inputIndex >= len(tx.Vin) {
+		return errors.New("input index out of range")
+	}
+
+	scriptSig := tx.Vin[inputIndex].ScriptSig
+	if !IsPushOnly(scriptSig) {
+		return errors.New("unlocking script is not push only")
+	}
+
+	vm := scriptEngine{
+		tx:         tx,
+		inputIndex: inputIndex,
+		prevOutput: prevOutput,
+		batch:      batch,
+	}
+
+	err := vm.execute(scriptSig)
+	if err != nil {
+		return err
+	}
+	scriptSigStack := append([][]byte{}, vm.stack...)
+
+	err = vm.run(prevOutput.ScriptPubKey)
+	if err != nil {
+		return err
+	}
+
+	if ExtractScriptHash(prevOutput.ScriptPubKey) == nil {
+		return nil
+	}
+
+	vm.stack = scriptSigStack
+	redeemScript, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	return vm.run(redeemScript)
+}
+
+// run - executes script as a lock, leaving true on the stack if it is satisfied
+func (vm *scriptEngine) run(script []byte) error {
+	// nothing left open by the script before may leak into this one
+	vm.condStack = nil
+	vm.numOps = 0
+	vm.scriptCode = script
+
+	err := vm.execute(script)
+	if err != nil {
+		return err
+	}
+
+	if len(vm.stack) == 0 || !asBool(vm.stack[len(vm.stack)-1]) {
+		return errScriptFailed
+	}
+
+	return nil
+}
+
+func (vm *scriptEngine) execute(script []byte) error {
+	if len(script) > maxScriptSize {
+		return fmt.Errorf("script of %d bytes is larger than %d", len(script), maxScriptSize)
+	}
+
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if len(op.data) > maxScriptElementSize {
+			return fmt.Errorf("push of %d bytes is larger than %d", len(op.data), maxScriptElementSize)
+		}
+
+		if op.opcode > Op16 {
+			vm.numOps++
+			if vm.numOps > maxOpsPerScript {
+				return fmt.Errorf("script has more than %d operations", maxOpsPerScript)
+			}
+		}
+
+		err := vm.step(&op)
+		if err != nil {
+			return fmt.Errorf("%s: %v", opName(op.opcode), err)
+		}
+
+		if len(vm.stack) > maxStackSize {
+			return fmt.Errorf("stack holds more than %d elements", maxStackSize)
+		}
+	}
+
+	if len(vm.condStack) != 0 {
+		return errors.New("OP_IF without OP_ENDIF")
+	}
+
+	return nil
+}
+
+// executing - false while inside a branch that was not taken
+func (vm *scriptEngine) executing() bool {
+	for _, cond := range vm.condStack {
+		if !cond {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *scriptEngine) step(op *parsedOp) error {
+	// branches not taken are skipped, apart from tracking nested conditionals
+	if !vm.executing() {
+		switch op.opcode {
+		case OpIf, OpNotIf:
+			vm.condStack = append(vm.condStack, false)
+		case OpElse, OpEndIf:
+			return vm.stepConditional(op)
+		}
+		return nil
+	}
+
+	switch {
+	case op.opcode <= OpPushData2:
+		vm.push(op.data)
+		return nil
+	case op.opcode == Op1Negate:
+		vm.pushNum(-1)
+		return nil
+	case op.opcode >= Op1 && op.opcode <= Op16:
+		vm.pushNum(int64(op.opcode - Op1 + 1))
+		return nil
+	}
+
+	switch op.opcode {
+	case OpNop:
+	case OpIf, OpNotIf, OpElse, OpEndIf:
+		return vm.stepConditional(op)
+	case OpVerify:
+		return vm.verify()
+	case OpReturn:
+		return errors.New("script is unspendable")
+
+	case OpDrop:
+		_, err := vm.pop()
+		return err
+	case OpDup:
+		v, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		vm.push(v)
+	case OpSwap:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(a)
+		vm.push(b)
+	case OpSize:
+		v, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		vm.pushNum(int64(len(v)))
+
+	case OpEqual, OpEqualVerify:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(fromBool(bytes.Equal(a, b)))
+		if op.opcode == OpEqualVerify {
+			return vm.verify()
+		}
+
+	case Op1Add, Op1Sub, OpNot:
+		return vm.stepUnaryNum(op)
+	case OpAdd, OpSub, OpBoolAnd, OpBoolOr, OpNumEqual, OpNumEqualVerify,
+		OpLessThan, OpGreaterThan, OpLessThanOrEqual, OpGreaterThanOrEqual, OpMin, OpMax:
+		return vm.stepBinaryNum(op)
+	case OpWithin:
+		upper, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		lower, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		x, err := vm.popNum()
+		if err != nil {
+			return err
+		}
+		vm.push(fromBool(lower <= x && x < upper))
+
+	case OpSHA256:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(v)
+		vm.push(hash[:])
+	case OpHash160:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(HashPubKey(v))
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		valid, err := vm.checkSig(sig, pubKey, true)
+		if err != nil {
+			return err
+		}
+		vm.push(fromBool(valid))
+		if op.opcode == OpCheckSigVerify {
+			return vm.verify()
+		}
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		err := vm.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		if op.opcode == OpCheckMultiSigVerify {
+			return vm.verify()
+		}
+
+	case OpCheckLockTimeVerify:
+		return vm.checkLockTime()
+	case OpCheckSequenceVerify:
+		return vm.checkSequence()
+
+	default:
+		return errors.New("unknown opcode")
+	}
+
+	return nil
+}
+
+func (vm *scriptEngine) stepConditional(op *parsedOp) error {
+	switch op.opcode {
+	case OpIf, OpNotIf:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		cond := asBool(v)
+		if op.opcode == OpNotIf {
+			cond = !cond
+		}
+		vm.condStack = append(vm.condStack, cond)
+	case OpElse:
+		if len(vm.condStack) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+		last := len(vm.condStack) - 1
+		// only flip if the enclosing branches are being executed
+		if vm.outerExecuting() {
+			vm.condStack[last] = !vm.condStack[last]
+		}
+	case OpEndIf:
+		if len(vm.condStack) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+		vm.condStack = vm.condStack[:len(vm.condStack)-1]
+	}
+
+	return nil
+}
+
+// outerExecuting - whether every conditional but the innermost is true
+func (vm *scriptEngine) outerExecuting() bool {
+	for _, cond := range vm.condStack[:len(vm.condStack)-1] {
+		if !cond {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *scriptEngine) stepUnaryNum(op *parsedOp) error {
+	n, err := vm.popNum()
+	if err != nil {
+		return err
+	}
+
+	switch op.opcode {
+	case Op1Add:
+		vm.pushNum(n + 1)
+	case Op1Sub:
+		vm.pushNum(n - 1)
+	case OpNot:
+		vm.push(fromBool(n == 0))
+	}
+
+	return nil
+}
+
+func (vm *scriptEngine) stepBinaryNum(op *parsedOp) error {
+	b, err := vm.popNum()
+	if err != nil {
+		return err
+	}
+	a, err := vm.popNum()
+	if err != nil {
+		return err
+	}
+
+	switch op.opcode {
+	case OpAdd:
+		vm.pushNum(a + b)
+	case OpSub:
+		vm.pushNum(a - b)
+	case OpBoolAnd:
+		vm.push(fromBool(a != 0 && b != 0))
+	case OpBoolOr:
+		vm.push(fromBool(a != 0 || b != 0))
+	case OpNumEqual, OpNumEqualVerify:
+		vm.push(fromBool(a == b))
+		if op.opcode == OpNumEqualVerify {
+			return vm.verify()
+		}
+	case OpLessThan:
+		vm.push(fromBool(a < b))
+	case OpGreaterThan:
+		vm.push(fromBool(a > b))
+	case OpLessThanOrEqual:
+		vm.push(fromBool(a <= b))
+	case OpGreaterThanOrEqual:
+		vm.push(fromBool(a >= b))
+	case OpMin:
+		if b < a {
+			a = b
+		}
+		vm.pushNum(a)
+	case OpMax:
+		if b > a {
+			a = b
+		}
+		vm.pushNum(a)
+	}
+
+	return nil
+}
+
+// checkSig - checks a signature, whose last byte is its hash type, against
+// the input being verified. An empty signature just fails the check, but one
+// that is not canonical fails the script, so nobody can change a signature
+// into another that passes.
+//
+// A 32 byte key takes a BIP340 Schnorr signature. Where the result is not
+// just tried, as it is by OP_CHECKMULTISIG, a Schnorr signature that is not
+// empty must be valid, so it can go into the batch and count as good for now
+func (vm *scriptEngine) checkSig(sig, pubKey []byte, final bool) (bool, error) {
+	if len(sig) == 0 {
+		return false, nil
+	}
+
+	hashType := SigHashType(sig[len(sig)-1])
+	if !hashType.IsValid() {
+		return false, fmt.Errorf("invalid signature hash type %x", byte(hashType))
+	}
+
+	scriptCode := TXOutput{Value: vm.prevOutput.Value, ScriptPubKey: vm.scriptCode}
+
+	if len(pubKey) == schnorrPubKeyLen {
+		if len(sig)-1 != schnorrSigLen {
+			return false, errors.New("schnorr signature must be 64 bytes")
+		}
+
+		if !final {
+			return vm.tx.checkSignature(vm.inputIndex, scriptCode, sig, pubKey), nil
+		}
+
+		hash, err := SignatureHash(vm.tx, vm.inputIndex, scriptCode, hashType)
+		if err != nil {
+			return false, err
+		}
+
+		if vm.batch != nil {
+			return true, vm.batch.Add(pubKey, sig[:len(sig)-1], hash)
+		}
+
+		if !schnorrVerify(pubKey, sig[:len(sig)-1], hash) {
+			return false, errors.New("schnorr signature is not valid")
+		}
+		return true, nil
+	}
+
+	pub, err := parsePubKey(pubKey)
+	if err != nil {
+		return false, err
+	}
+
+	err = checkSignatureEncoding(sig[:len(sig)-1], pub.Curve)
+	if err != nil {
+		return false, err
+	}
+
+	return vm.tx.checkSignature(vm.inputIndex, scriptCode, sig, pubKey), nil
+}
+
+// checkMultiSig - pops n, n public keys, m and m signatures and pushes
+// whether the signatures match a subset of the keys in the same order
+func (vm *scriptEngine) checkMultiSig() error {
+	numKeys, err := vm.popNum()
+	if err != nil {
+		return err
+	}
+	if numKeys < 0 || numKeys > maxPubKeysPerMultiSig {
+		return fmt.Errorf("%d public keys is out of range", numKeys)
+	}
+
+	vm.numOps += int(numKeys)
+	if vm.numOps > maxOpsPerScript {
+		return fmt.Errorf("script has more than %d operations", maxOpsPerScript)
+	}
+
+	pubKeys := make([][]byte, numKeys)
+	for i := range pubKeys {
+		pubKeys[i], err = vm.pop()
+		if err != nil {
+			return err
+		}
+	}
+
+	numSigs, err := vm.popNum()
+	if err != nil {
+		return err
+	}
+	if numSigs < 0 || numSigs > numKeys {
+		return fmt.Errorf("%d signatures is out of range for %d keys", numSigs, numKeys)
+	}
+
+	sigs := make([][]byte, numSigs)
+	for i := range sigs {
+		sigs[i], err = vm.pop()
+		if err != nil {
+			return err
+		}
+	}
+
+	// keys and signatures were pushed in order, so both are popped reversed
+	success := true
+	keyIdx := 0
+	for sigIdx := 0; sigIdx < len(sigs); {
+		if len(sigs)-sigIdx > len(pubKeys)-keyIdx {
+			success = false
+			break
+		}
+
+		valid, err := vm.checkSig(sigs[sigIdx], pubKeys[keyIdx], false)
+		if err != nil {
+			return err
+		}
+		if valid {
+			sigIdx++
+		}
+		keyIdx++
+	}
+
+	vm.push(fromBool(success))
+
+	return nil
+}
+
+// checkLockTime - fails unless the lock time of the transaction is at or past
+// the height or time on top of the stack, which is left in place. The lock
+// time itself is checked against the block the transaction goes in
+func (vm *scriptEngine) checkLockTime() error {
+	v, err := vm.peek(0)
+	if err != nil {
+		return err
+	}
+
+	// lock times go past the 4 byte arithmetic limit
+	lockTime, err := makeScriptNum(v, 5)
+	if err != nil {
+		return err
+	}
+
+	if lockTime < 0 {
+		return errors.New("negative lock time")
+	}
+
+	txLockTime := int64(vm.tx.LockTime)
+	if (lockTime < lockTimeThreshold) != (txLockTime < lockTimeThreshold) {
+		return errors.New("lock time and transaction lock time are of different kinds")
+	}
+
+	if lockTime > txLockTime {
+		return fmt.Errorf("locked until %d, transaction lock time is %d", lockTime, txLockTime)
+	}
+
+	// a final input would let the transaction skip its lock time
+	if vm.tx.Vin[vm.inputIndex].Sequence == MaxTxInSequenceNum {
+		return errors.New("input is final")
+	}
+
+	return nil
+}
+
+// checkSequence - fails unless the relative lock of the input is at least
+// the one on top of the stack, which is left in place. The relative lock
+// itself is checked against the age of the output being spent
+func (vm *scriptEngine) checkSequence() error {
+	v, err := vm.peek(0)
+	if err != nil {
+		return err
+	}
+
+	sequence, err := makeScriptNum(v, 5)
+	if err != nil {
+		return err
+	}
+
+	if sequence < 0 {
+		return errors.New("negative sequence")
+	}
+
+	// with the disable flag set the op does nothing, leaving room for new meanings
+	if uint32(sequence)&SequenceLockTimeDisabled != 0 {
+		return nil
+	}
+
+	txSequence := vm.tx.Vin[vm.inputIndex].Sequence
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return errors.New("input has no relative lock")
+	}
+
+	if uint32(sequence)&SequenceLockTimeIsSeconds != txSequence&SequenceLockTimeIsSeconds {
+		return errors.New("relative lock and input sequence are of different kinds")
+	}
+
+	if uint32(sequence)&SequenceLockTimeMask > txSequence&SequenceLockTimeMask {
+		return fmt.Errorf("relative lock of %d, input sequence is %d", sequence&int64(SequenceLockTimeMask),
+			txSequence&SequenceLockTimeMask)
+	}
+
+	return nil
+}
+
+func (vm *scriptEngine) verify() error {
+	v, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	if !asBool(v) {
+		return errors.New("verify failed")
+	}
+
+	return nil
+}
+
+func (vm *scriptEngine) push(v []byte) {
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *scriptEngine) pushNum(n int64) {
+	vm.push(scriptNumBytes(n))
+}
+
+func (vm *scriptEngine) pop() ([]byte, error) {
+	v, err := vm.peek(0)
+	if err != nil {
+		return nil, err
+	}
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return v, nil
+}
+
+func (vm *scriptEngine) popNum() (int64, error) {
+	v, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return makeScriptNum(v, maxScriptNumLen)
+}
+
+// peek - the element depth places below the top of the stack
+func (vm *scriptEngine) peek(depth int) ([]byte, error) {
+	if depth >= len(vm.stack) {
+		return nil, errors.New("stack underflow")
+	}
+
+	return vm.stack[len(vm.stack)-1-depth], nil
+}
+
+func opName(opcode byte) string {
+	if opcode >= Op1 && opcode <= Op16 {
+		return fmt.Sprintf("OP_%d", opcode-Op1+1)
+	}
+	if name := opcodeNames[opcode]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("OP_UNKNOWN%d", opcode)
+}
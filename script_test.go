@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// spendScript - a transaction spending an output locked by lock, changed by
+// change and then given the unlocking script unlock returns, along with the
+// previous transactions Verify takes
+func spendScript(lock []byte, change func(tx *Transaction), unlock func(tx *Transaction, prevOut TXOutput) []byte) (*Transaction, map[string]Transaction) {
+	prev := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: []byte{1}, Vout: 0, Sequence: MaxTxInSequenceNum}},
+		Vout:    []TXOutput{{Value: 10, ScriptPubKey: lock}},
+	}
+	prev.SetID()
+
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: prev.ID, Vout: 0, Sequence: MaxTxInSequenceNum}},
+		Vout:    []TXOutput{{Value: 10, ScriptPubKey: []byte{Op1}}},
+	}
+	if change != nil {
+		change(tx)
+	}
+	tx.SetID()
+	tx.Vin[0].ScriptSig = unlock(tx, prev.Vout[0])
+
+	return tx, map[string]Transaction{hex.EncodeToString(prev.ID): *prev}
+}
+
+// pushes - an unlocking script that pushes each of data
+func pushes(data ...[]byte) func(tx *Transaction, prevOut TXOutput) []byte {
+	return func(tx *Transaction, prevOut TXOutput) []byte {
+		builder := NewScriptBuilder()
+		for _, datum := range data {
+			builder.AddData(datum)
+		}
+
+		return builder.Script()
+	}
+}
+
+// signedBy - an unlocking script of the signature of wallet followed by the
+// pushes of data, with scriptCode the script the signature is checked against
+func signedBy(t *testing.T, wallet *Wallet, scriptCode []byte, data ...[]byte) func(tx *Transaction, prevOut TXOutput) []byte {
+	return func(tx *Transaction, prevOut TXOutput) []byte {
+		sig, err := tx.signature(wallet.PrivateKey, 0, TXOutput{Value: prevOut.Value, ScriptPubKey: scriptCode}, SigHashAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return pushes(append([][]byte{sig}, data...)...)(tx, prevOut)
+	}
+}
+
+func TestVerifyScripts(t *testing.T) {
+	wallet := NewWallet()
+	otherWallet := NewWallet()
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	p2pkh := PayToPubKeyHashScript(pubKeyHash)
+
+	addFive := NewScriptBuilder().AddOp(OpAdd).AddInt64(5).AddOp(OpEqual).Script()
+	p2shAddFive := PayToScriptHashScript(HashPubKey(addFive))
+
+	checkSig := NewScriptBuilder().AddData(wallet.PublicKey).AddOp(OpCheckSig).Script()
+	p2shCheckSig := PayToScriptHashScript(HashPubKey(checkSig))
+
+	branches := NewScriptBuilder().AddOp(OpIf).AddInt64(2).AddOp(OpElse).AddInt64(3).AddOp(OpEndIf).
+		AddOp(OpEqual).Script()
+
+	num := func(n int64) []byte { return scriptNumBytes(n) }
+
+	tests := []struct {
+		name   string
+		lock   []byte
+		unlock func(tx *Transaction, prevOut TXOutput) []byte
+		valid  bool
+	}{
+		{"pay to pubkey hash", p2pkh, signedBy(t, wallet, p2pkh, wallet.PublicKey), true},
+		{"pay to pubkey hash, other key", p2pkh, signedBy(t, otherWallet, p2pkh, otherWallet.PublicKey), false},
+		{"pay to pubkey hash, other signer", p2pkh, signedBy(t, otherWallet, p2pkh, wallet.PublicKey), false},
+		{"pay to pubkey hash, no signature", p2pkh, pushes(wallet.PublicKey), false},
+		{"bare script", addFive, pushes(num(2), num(3)), true},
+		{"bare script, wrong sum", addFive, pushes(num(2), num(2)), false},
+		{"empty unlocking script", addFive, pushes(), false},
+		{"unlocking script not push only", addFive, func(tx *Transaction, prevOut TXOutput) []byte {
+			return NewScriptBuilder().AddInt64(2).AddInt64(3).AddOp(OpAdd).Script()
+		}, false},
+		{"if branch", branches, pushes(num(2), num(1)), true},
+		{"else branch", branches, pushes(num(3), []byte{}), true},
+		{"branch not taken", branches, pushes(num(2), []byte{}), false},
+		{"op return", []byte{OpReturn}, pushes(num(1)), false},
+		{"pay to script hash", p2shAddFive, pushes(num(2), num(3), addFive), true},
+		// the lock alone only checks the hash, so the redeem script must run too
+		{"pay to script hash, redeem script fails", p2shAddFive, pushes(num(2), num(2), addFive), false},
+		{"pay to script hash, other redeem script", p2shAddFive, pushes(num(2), num(3), branches), false},
+		{"pay to script hash, no redeem script", p2shAddFive, pushes(), false},
+		{"pay to script hash signed", p2shCheckSig, signedBy(t, wallet, checkSig, checkSig), true},
+		{"pay to script hash signed against the lock", p2shCheckSig, signedBy(t, wallet, p2shCheckSig, checkSig), false},
+		{"pay to script hash, other signer", p2shCheckSig, signedBy(t, otherWallet, checkSig, checkSig), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, prevTXs := spendScript(test.lock, nil, test.unlock)
+			if tx.Verify(prevTXs) != test.valid {
+				t.Fatalf("Verify = %v, want %v", !test.valid, test.valid)
+			}
+		})
+	}
+}
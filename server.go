@@ -9,11 +9,13 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sync"
 )
 
 const protocol = "tcp"
 const nodeVersion = 1
 const commandLength = 12
+const maxHeadersPerMsg = 2000
 
 var nodeAddress string
 var miningAddress string
@@ -21,6 +23,14 @@ var knownNodes = []string{"localhost:3000"}
 var blocksInTransit = [][]byte{}
 var mempool = make(map[string]Transaction)
 
+// mempoolSpends - the mempool transaction spending each outpoint, so a
+// second spend of it is turned away
+var mempoolSpends = make(map[string]string)
+
+// mempoolMutex - guards mempool and mempoolSpends, which the connections are
+// handled on goroutines of their own to change
+var mempoolMutex sync.Mutex
+
 type addr struct {
 	AddrList []string
 }
@@ -34,6 +44,16 @@ type getblocks struct {
 	AddrFrom string
 }
 
+type getheaders struct {
+	AddrFrom string
+	Locator  [][]byte
+}
+
+type headers struct {
+	AddrFrom string
+	Headers  [][]byte
+}
+
 type getdata struct {
 	AddrFrom string
 	Type     string
@@ -145,6 +165,20 @@ func sendGetBlocks(address string) {
 	sendData(address, request)
 }
 
+func sendGetHeaders(address string, bc *Blockchain) {
+	payload := gobEncode(getheaders{nodeAddress, bc.GetBlockLocator()})
+	request := append(commandToBytes("getheaders"), payload...)
+
+	sendData(address, request)
+}
+
+func sendHeaders(address string, items [][]byte) {
+	payload := gobEncode(headers{nodeAddress, items})
+	request := append(commandToBytes("headers"), payload...)
+
+	sendData(address, request)
+}
+
 func sendGetData(address, kind string, id []byte) {
 	payload := gobEncode(getdata{nodeAddress, kind, id})
 	request := append(commandToBytes("getdata"), payload...)
@@ -198,9 +232,22 @@ func handleBlock(request []byte, bc *Blockchain) {
 
 	blockData := payload.Block
 	block := DeserializeBlock(blockData)
+	if block == nil {
+		return
+	}
 
 	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
+	err = bc.AddBlock(block)
+	if err == errOrphanBlock {
+		fmt.Printf("Block %x is an orphan, asking for its branch\n", block.Hash)
+		sendGetHeaders(payload.AddrFrom, bc)
+		return
+	} else if err != nil {
+		fmt.Printf("Rejected block %x: %v\n", block.Hash, err)
+		// the rest of the batch builds on it and would be rejected too
+		blocksInTransit = [][]byte{}
+		return
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
 
@@ -209,9 +256,6 @@ func handleBlock(request []byte, bc *Blockchain) {
 		sendGetData(payload.AddrFrom, "block", blockHash)
 
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
 	}
 }
 
@@ -229,24 +273,33 @@ func handleInv(request []byte, bc *Blockchain) {
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
-
+		// inventory lists the tip first; fetch the missing blocks oldest
+		// first so every block arrives after its parent
 		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if !bc.HasBlock(payload.Items[i]) {
+				newInTransit = append(newInTransit, payload.Items[i])
 			}
 		}
-		blocksInTransit = newInTransit
+
+		if len(newInTransit) == 0 {
+			return
+		}
+
+		blockHash := newInTransit[0]
+		sendGetData(payload.AddrFrom, "block", blockHash)
+
+		blocksInTransit = newInTransit[1:]
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
+		mempoolMutex.Lock()
+		_, known := mempool[hex.EncodeToString(txID)]
+		mempoolMutex.Unlock()
+
+		if !known {
 			sendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
@@ -267,6 +320,73 @@ func handleGetBlocks(request []byte, bc *Blockchain) {
 	sendInv(payload.AddrFrom, "block", blocks)
 }
 
+func handleGetHeaders(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload getheaders
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	sendHeaders(payload.AddrFrom, bc.GetHeadersAfter(payload.Locator, maxHeadersPerMsg))
+}
+
+func handleHeaders(request []byte, bc *Blockchain) {
+	var buff bytes.Buffer
+	var payload headers
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Recevied %d headers\n", len(payload.Headers))
+
+	// headers arrive oldest first; check their work and linkage before
+	// asking for any of the bodies
+	var prevHash []byte
+	newInTransit := [][]byte{}
+	for _, data := range payload.Headers {
+		header, err := DeserializeBlockHeader(data)
+		if err != nil {
+			fmt.Printf("Rejected headers: %v\n", err)
+			return
+		}
+
+		err = CheckBlockHeaderSanity(header)
+		if err != nil {
+			fmt.Printf("Rejected header %x: %v\n", header.Hash(), err)
+			return
+		}
+
+		if prevHash == nil && !bc.HasBlock(header.PrevBlockHash) {
+			fmt.Printf("Headers do not connect to our chain\n")
+			return
+		}
+		if prevHash != nil && !bytes.Equal(header.PrevBlockHash, prevHash) {
+			fmt.Printf("Headers are not a chain\n")
+			return
+		}
+		prevHash = header.Hash()
+
+		if !bc.HasBlock(prevHash) {
+			newInTransit = append(newInTransit, prevHash)
+		}
+	}
+
+	if len(newInTransit) == 0 {
+		return
+	}
+
+	sendGetData(payload.AddrFrom, "block", newInTransit[0])
+	blocksInTransit = newInTransit[1:]
+}
+
 func handleGetData(request []byte, bc *Blockchain) {
 	var buff bytes.Buffer
 	var payload getdata
@@ -289,13 +409,135 @@ func handleGetData(request []byte, bc *Blockchain) {
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
+		mempoolMutex.Lock()
 		tx := mempool[txID]
+		mempoolMutex.Unlock()
 
 		sendTx(payload.AddrFrom, &tx)
 		// delete(mempool, txID)
 	}
 }
 
+// acceptToMempool - checks tx could go in the next block, the way the block
+// would be checked, and adds it to the mempool unless one of its inputs is
+// already spent by a transaction there
+func acceptToMempool(bc *Blockchain, tx *Transaction) error {
+	err := checkMempoolTx(bc, tx)
+	if err != nil {
+		return err
+	}
+
+	mempoolMutex.Lock()
+	defer mempoolMutex.Unlock()
+
+	return addToMempool(tx)
+}
+
+// checkMempoolTx - checks tx could go in the next block, the way the block
+// would be checked
+func checkMempoolTx(bc *Blockchain, tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return fmt.Errorf("transaction %x is a coinbase", tx.ID)
+	}
+	err := CheckTransactionSanity(tx)
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := UTXOSet{Blockchain: bc}
+	_, err = UTXOSet.CalculateFee(tx)
+	if err != nil {
+		return err
+	}
+	if !bc.VerifyTransaction(tx) {
+		return fmt.Errorf("transaction %x has invalid signatures", tx.ID)
+	}
+
+	return nil
+}
+
+// addToMempool - adds tx to the mempool unless one of its inputs is
+// already spent by a transaction there. mempoolMutex must be held
+func addToMempool(tx *Transaction) error {
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mempool[txID]; ok {
+		return fmt.Errorf("transaction %s is already in the mempool", txID)
+	}
+
+	for _, vin := range tx.Vin {
+		outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+		if spender, ok := mempoolSpends[outpoint]; ok {
+			return fmt.Errorf("transaction %s spends %s, already spent by %s", txID, outpoint, spender)
+		}
+	}
+
+	for _, vin := range tx.Vin {
+		mempoolSpends[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = txID
+	}
+	mempool[txID] = *tx
+
+	return nil
+}
+
+// removeFromMempool - drops tx and the outpoints it spends from the mempool.
+// mempoolMutex must be held
+func removeFromMempool(tx *Transaction) {
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mempool[txID]; !ok {
+		return
+	}
+
+	for _, vin := range tx.Vin {
+		outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+		if mempoolSpends[outpoint] == txID {
+			delete(mempoolSpends, outpoint)
+		}
+	}
+	delete(mempool, txID)
+}
+
+// syncMempool - drops the transactions of the connected blocks from the
+// mempool along with those spending the same outputs, then brings back the
+// transactions of the disconnected blocks still valid on the new chain and
+// drops any that no longer are
+func syncMempool(bc *Blockchain, disconnected, connected []*Block) {
+	mempoolMutex.Lock()
+	defer mempoolMutex.Unlock()
+
+	for _, block := range connected {
+		for _, tx := range block.Transactions {
+			removeFromMempool(tx)
+
+			for _, vin := range tx.Vin {
+				if spender, ok := mempoolSpends[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)]; ok {
+					conflict := mempool[spender]
+					removeFromMempool(&conflict)
+				}
+			}
+		}
+	}
+
+	if len(disconnected) == 0 {
+		return
+	}
+
+	UTXOSet := UTXOSet{Blockchain: bc}
+	for id := range mempool {
+		tx := mempool[id]
+		if _, err := UTXOSet.CalculateFee(&tx); err != nil {
+			removeFromMempool(&tx)
+		}
+	}
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions[1:] {
+			if checkMempoolTx(bc, tx) == nil {
+				addToMempool(tx)
+			}
+		}
+	}
+}
+
 func handleTx(request []byte, bc *Blockchain) {
 	var buff bytes.Buffer
 	var payload tx
@@ -308,8 +550,17 @@ func handleTx(request []byte, bc *Blockchain) {
 	}
 
 	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	tx, err := DeserializeTransaction(txData)
+	if err != nil {
+		fmt.Printf("Rejected transaction: %v\n", err)
+		return
+	}
+	// only take and relay transactions that could go in the next block
+	err = acceptToMempool(bc, &tx)
+	if err != nil {
+		fmt.Printf("Rejected transaction %x: %v\n", tx.ID, err)
+		return
+	}
 
 	if nodeAddress == knownNodes[0] {
 		for _, node := range knownNodes {
@@ -318,43 +569,74 @@ func handleTx(request []byte, bc *Blockchain) {
 			}
 		}
 	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
+		mempoolMutex.Lock()
+		pending := len(mempool)
+		mempoolMutex.Unlock()
+
+		if pending >= 2 && len(miningAddress) > 0 {
+			UTXOSet := UTXOSet{Blockchain: bc}
+
 		MineTransactions:
 			var txs []*Transaction
+			fees := 0
+			// outpoints spent by the block so far, as the UTXO set only
+			// knows what the chain spent
+			spent := make(map[string]bool)
+
+			// the lock is let go before mining, as adding the block
+			// syncs the mempool
+			mempoolMutex.Lock()
 
+		SelectTransactions:
 			for id := range mempool {
 				tx := mempool[id]
+				fee, err := UTXOSet.CalculateFee(&tx)
+				if err != nil || fees+fee > maxMoney {
+					continue
+				}
+				for _, vin := range tx.Vin {
+					if spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] {
+						continue SelectTransactions
+					}
+				}
+
 				if bc.VerifyTransaction(&tx) {
+					for _, vin := range tx.Vin {
+						spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+					}
 					txs = append(txs, &tx)
+					fees += fee
 				}
 			}
+			mempoolMutex.Unlock()
 
 			if len(txs) == 0 {
 				fmt.Println("All transactions are invalid! Waiting for new ones...")
 				return
 			}
 
-			cbTx := NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
+			cbTx := NewCoinbaseTX(miningAddress, "", bc.GetBestHeight()+1, fees)
+			txs = append([]*Transaction{cbTx}, txs...)
 
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.Reindex()
+			newBlock, err := bc.MineBlock(txs)
+			if err != nil {
+				fmt.Printf("Could not mine a block: %v\n", err)
+				return
+			}
 
 			fmt.Println("New block is mined!")
 
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
-			}
-
 			for _, node := range knownNodes {
 				if node != nodeAddress {
 					sendInv(node, "block", [][]byte{newBlock.Hash})
 				}
 			}
 
-			if len(mempool) > 0 {
+			mempoolMutex.Lock()
+			pending = len(mempool)
+			mempoolMutex.Unlock()
+
+			if pending > 0 {
 				goto MineTransactions
 			}
 		}
@@ -376,7 +658,7 @@ func handleVersion(request []byte, bc *Blockchain) {
 	foreignerBestHeight := payload.BestHeight
 
 	if myBestHeight < foreignerBestHeight {
-		sendGetBlocks(payload.AddrFrom)
+		sendGetHeaders(payload.AddrFrom, bc)
 	} else if myBestHeight > foreignerBestHeight {
 		sendVersion(payload.AddrFrom, bc)
 	}
@@ -404,12 +686,22 @@ func handleConnection(conn net.Conn, bc *Blockchain) {
 		handleInv(request, bc)
 	case "getblocks":
 		handleGetBlocks(request, bc)
+	case "getheaders":
+		handleGetHeaders(request, bc)
+	case "headers":
+		handleHeaders(request, bc)
 	case "getdata":
 		handleGetData(request, bc)
 	case "tx":
 		handleTx(request, bc)
 	case "version":
 		handleVersion(request, bc)
+	case "walletunlock":
+		handleWalletUnlock(request, conn)
+	case "walletlock":
+		handleWalletLock(request, conn)
+	case "walletkey":
+		handleWalletKey(request, conn)
 	default:
 		fmt.Println("Unknown command!")
 	}
@@ -429,6 +721,11 @@ func StartServer(nodeID, minerAddress string) {
 
 	bc := NewBlockchain(nodeID)
 
+	err = initNodeWallet(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	if nodeAddress != knownNodes[0] {
 		sendVersion(knownNodes[0], bc)
 	}
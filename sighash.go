@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// SigHashType - selects which parts of a transaction a signature commits to.
+// It is appended to every signature as its last byte
+type SigHashType uint8
+
+// signature hash types
+const (
+	// SigHashAll - commit to every input and output
+	SigHashAll SigHashType = 0x1
+	// SigHashNone - commit to the inputs only, anyone may change the outputs
+	SigHashNone SigHashType = 0x2
+	// SigHashSingle - commit to the inputs and the output at the same index
+	SigHashSingle SigHashType = 0x3
+	// SigHashAnyOneCanPay - modifier committing to the signed input only,
+	// so others may add inputs of their own
+	SigHashAnyOneCanPay SigHashType = 0x80
+
+	sigHashMask = 0x1f
+)
+
+var errSigHashSingle = errors.New("SIGHASH_SINGLE input has no matching output")
+
+// IsValid - checks that the hash type is one of the defined combinations
+func (hashType SigHashType) IsValid() bool {
+	base := hashType &^ SigHashAnyOneCanPay
+	return base >= SigHashAll && base <= SigHashSingle
+}
+
+// SignatureHash - returns the hash that the signature of input inputIndex
+// commits to. prevOutput is the output the input spends; its locking script,
+// or the redeem script for pay-to-script-hash, takes the place of the input's
+// unlocking script, which is not known until signing, and its value is
+// committed to as well
+func SignatureHash(tx *Transaction, inputIndex int, prevOutput TXOutput, hashType SigHashType) ([]byte, error) {
+	if inputIndex < 0 || inputIndex >= len(tx.Vin) {
+		return nil, errors.New("input index out of range")
+	}
+
+	if !hashType.IsValid() {
+		return nil, errors.New("invalid signature hash type")
+	}
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inputIndex].ScriptSig = prevOutput.ScriptPubKey
+
+	// without all the outputs, the other inputs may be updated by their owners
+	if hashType&sigHashMask == SigHashNone || hashType&sigHashMask == SigHashSingle {
+		for i := range txCopy.Vin {
+			if i != inputIndex {
+				txCopy.Vin[i].Sequence = 0
+			}
+		}
+	}
+
+	switch hashType & sigHashMask {
+	case SigHashNone:
+		txCopy.Vout = nil
+	case SigHashSingle:
+		if inputIndex >= len(txCopy.Vout) {
+			return nil, errSigHashSingle
+		}
+
+		txCopy.Vout = txCopy.Vout[:inputIndex+1]
+		for i := 0; i < inputIndex; i++ {
+			txCopy.Vout[i] = TXOutput{Value: -1}
+		}
+	}
+
+	if hashType&SigHashAnyOneCanPay != 0 {
+		txCopy.Vin = txCopy.Vin[inputIndex : inputIndex+1]
+	}
+
+	var buff bytes.Buffer
+
+	buff.Write(txCopy.serialize(true))
+	writeUint64(&buff, uint64(prevOutput.Value))
+	writeUint32(&buff, uint32(hashType))
+
+	hash := sha256.Sum256(buff.Bytes())
+
+	return hash[:], nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// sigHashTx - a transaction of three inputs and three outputs
+func sigHashTx() *Transaction {
+	tx := &Transaction{Version: txVersion, LockTime: 9}
+	for i := 0; i < 3; i++ {
+		tx.Vin = append(tx.Vin, TXInput{Txid: bytes.Repeat([]byte{byte(i + 1)}, hashLen), Vout: i, Sequence: uint32(i)})
+		tx.Vout = append(tx.Vout, TXOutput{Value: i + 1, ScriptPubKey: []byte{byte(i)}})
+	}
+	tx.SetID()
+
+	return tx
+}
+
+func TestSignatureHashCommitments(t *testing.T) {
+	prevOut := TXOutput{Value: 10, ScriptPubKey: []byte{Op1}}
+
+	changes := []struct {
+		name   string
+		change func(tx *Transaction, prevOut *TXOutput)
+	}{
+		{"earlier output", func(tx *Transaction, prevOut *TXOutput) { tx.Vout[0].Value++ }},
+		{"matching output", func(tx *Transaction, prevOut *TXOutput) { tx.Vout[1].Value++ }},
+		{"later output", func(tx *Transaction, prevOut *TXOutput) { tx.Vout[2].ScriptPubKey = nil }},
+		{"new output", func(tx *Transaction, prevOut *TXOutput) { tx.Vout = append(tx.Vout, tx.Vout[0]) }},
+		{"other sequence", func(tx *Transaction, prevOut *TXOutput) { tx.Vin[0].Sequence++ }},
+		{"other outpoint", func(tx *Transaction, prevOut *TXOutput) { tx.Vin[2].Vout++ }},
+		{"new input", func(tx *Transaction, prevOut *TXOutput) { tx.Vin = append(tx.Vin, tx.Vin[0]) }},
+		{"other unlocking script", func(tx *Transaction, prevOut *TXOutput) { tx.Vin[0].ScriptSig = []byte{1} }},
+		{"own sequence", func(tx *Transaction, prevOut *TXOutput) { tx.Vin[1].Sequence++ }},
+		{"own outpoint", func(tx *Transaction, prevOut *TXOutput) { tx.Vin[1].Vout++ }},
+		{"spent value", func(tx *Transaction, prevOut *TXOutput) { prevOut.Value++ }},
+		{"spent script", func(tx *Transaction, prevOut *TXOutput) { prevOut.ScriptPubKey = []byte{Op1, Op1} }},
+		{"lock time", func(tx *Transaction, prevOut *TXOutput) { tx.LockTime++ }},
+	}
+
+	// which of the changes, in order, each hash type commits input 1 to
+	tests := []struct {
+		hashType SigHashType
+		commits  []bool
+	}{
+		{SigHashAll, []bool{true, true, true, true, true, true, true, false, true, true, true, true, true}},
+		{SigHashNone, []bool{false, false, false, false, false, true, true, false, true, true, true, true, true}},
+		{SigHashSingle, []bool{false, true, false, false, false, true, true, false, true, true, true, true, true}},
+		{SigHashAll | SigHashAnyOneCanPay, []bool{true, true, true, true, false, false, false, false, true, true, true, true, true}},
+		{SigHashNone | SigHashAnyOneCanPay, []bool{false, false, false, false, false, false, false, false, true, true, true, true, true}},
+		{SigHashSingle | SigHashAnyOneCanPay, []bool{false, true, false, false, false, false, false, false, true, true, true, true, true}},
+	}
+
+	for _, test := range tests {
+		base, err := SignatureHash(sigHashTx(), 1, prevOut, test.hashType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, change := range changes {
+			tx := sigHashTx()
+			changedOut := prevOut
+			change.change(tx, &changedOut)
+
+			hash, err := SignatureHash(tx, 1, changedOut, test.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if committed := !bytes.Equal(hash, base); committed != test.commits[i] {
+				t.Errorf("hash type %#x: %s committed %v, want %v", byte(test.hashType), change.name, committed, test.commits[i])
+			}
+		}
+	}
+}
+
+func TestSignatureHashErrors(t *testing.T) {
+	prevOut := TXOutput{Value: 10, ScriptPubKey: []byte{Op1}}
+	tx := sigHashTx()
+	tx.Vout = tx.Vout[:1]
+
+	tests := []struct {
+		name       string
+		inputIndex int
+		hashType   SigHashType
+		valid      bool
+	}{
+		{"single with a matching output", 0, SigHashSingle, true},
+		{"single past the outputs", 1, SigHashSingle, false},
+		{"single anyone can pay past the outputs", 2, SigHashSingle | SigHashAnyOneCanPay, false},
+		{"none past the outputs", 2, SigHashNone, true},
+		{"negative input", -1, SigHashAll, false},
+		{"input out of range", 3, SigHashAll, false},
+		{"hash type 0", 0, 0, false},
+		{"hash type 4", 0, 4, false},
+		{"anyone can pay alone", 0, SigHashAnyOneCanPay, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := SignatureHash(tx, test.inputIndex, prevOut, test.hashType)
+			if (err == nil) != test.valid {
+				t.Fatalf("error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestSignatureHashTypesVerify(t *testing.T) {
+	wallet := NewWallet()
+	prevOut := TXOutput{Value: 10, ScriptPubKey: PayToPubKeyHashScript(HashPubKey(wallet.PublicKey))}
+
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		change   func(tx *Transaction)
+		valid    bool
+	}{
+		{"none, outputs changed", SigHashNone, func(tx *Transaction) { tx.Vout[0].Value = 5 }, true},
+		{"all, outputs changed", SigHashAll, func(tx *Transaction) { tx.Vout[0].Value = 5 }, false},
+		{"single, other output changed", SigHashSingle, func(tx *Transaction) { tx.Vout[2].Value = 5 }, true},
+		{"single, own output changed", SigHashSingle, func(tx *Transaction) { tx.Vout[1].Value = 5 }, false},
+		{"anyone can pay, input added", SigHashAll | SigHashAnyOneCanPay, func(tx *Transaction) {
+			tx.Vin = append(tx.Vin, TXInput{Txid: bytes.Repeat([]byte{9}, hashLen), Sequence: MaxTxInSequenceNum})
+		}, true},
+		{"all, input added", SigHashAll, func(tx *Transaction) {
+			tx.Vin = append(tx.Vin, TXInput{Txid: bytes.Repeat([]byte{9}, hashLen), Sequence: MaxTxInSequenceNum})
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := sigHashTx()
+			err := tx.SignInput(wallet.PrivateKey, 1, prevOut, test.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.VerifyInput(1, prevOut); err != nil {
+				t.Fatal(err)
+			}
+
+			test.change(tx)
+			if err := tx.VerifyInput(1, prevOut); (err == nil) != test.valid {
+				t.Fatalf("error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// signHash - signs a 32 byte hash with the private key. The nonce is derived
+// from the key and the hash as in RFC 6979, so signing is deterministic, and s
+// is kept in the lower half of the curve order so the signature cannot be
+// changed into another valid one. r and s are padded to the size of the curve
+func signHash(privKey ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	curve := privKey.Curve
+	if curve == secp256k1.S256() {
+		return signHashSecp256k1(privKey, hash), nil
+	}
+
+	// legacy P-256 keys, signed in variable time with math/big
+	n := curve.Params().N
+	e := hashToInt(hash, curve)
+
+	nonces := newNonceRFC6979(privKey.D, hash, curve)
+	for {
+		k := nonces.next()
+
+		x, _ := curve.ScalarBaseMult(k.Bytes())
+		r := new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		// s = (e + r*d) / k
+		s := new(big.Int).Mul(r, privKey.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		if s.Cmp(halfOrder(curve)) > 0 {
+			s.Sub(n, s)
+		}
+
+		size := curveSize(curve)
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+
+		return signature, nil
+	}
+}
+
+// signHashSecp256k1 - signs hash with a secp256k1 key in constant time. dcrd
+// derives the nonce as in RFC 6979 and keeps s in the lower half of the order
+func signHashSecp256k1(privKey ecdsa.PrivateKey, hash []byte) []byte {
+	keyBytes := make([]byte, 32)
+	privKey.D.FillBytes(keyBytes)
+	key := secp256k1.PrivKeyFromBytes(keyBytes)
+	zeroBytes(keyBytes)
+	defer key.Zero()
+
+	sig := secpecdsa.Sign(key, hash)
+	r, s := sig.R(), sig.S()
+
+	signature := make([]byte, 64)
+	r.PutBytesUnchecked(signature[:32])
+	s.PutBytesUnchecked(signature[32:])
+
+	return signature
+}
+
+// verifySignature - checks a signature made by signHash against the
+// serialized public key. Signatures that are not in canonical form fail
+func verifySignature(pubKey, signature, hash []byte) bool {
+	pub, err := parsePubKey(pubKey)
+	if err != nil || checkSignatureEncoding(signature, pub.Curve) != nil {
+		return false
+	}
+
+	size := curveSize(pub.Curve)
+
+	if pub.Curve == secp256k1.S256() {
+		var r, s secp256k1.ModNScalar
+		r.SetByteSlice(signature[:size])
+		s.SetByteSlice(signature[size:])
+
+		key, err := secp256k1.ParsePubKey(pubKey)
+		if err != nil {
+			return false
+		}
+
+		return secpecdsa.NewSignature(&r, &s).Verify(hash, key)
+	}
+
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+
+	return ecdsa.Verify(pub, hash, r, s)
+}
+
+// checkSignatureEncoding - checks that a signature, without its hash type, is
+// in the one form signHash produces for curve: r and s each exactly the size
+// of the curve, both in range, and s in the lower half of the order
+func checkSignatureEncoding(signature []byte, curve elliptic.Curve) error {
+	size := curveSize(curve)
+
+	if len(signature) != 2*size {
+		return fmt.Errorf("signature is %d bytes, not %d", len(signature), 2*size)
+	}
+
+	n := curve.Params().N
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+
+	if r.Sign() == 0 || r.Cmp(n) >= 0 {
+		return errors.New("signature r is out of range")
+	}
+
+	if s.Sign() == 0 || s.Cmp(n) >= 0 {
+		return errors.New("signature s is out of range")
+	}
+
+	if s.Cmp(halfOrder(curve)) > 0 {
+		return errors.New("signature s is not in the lower half of the order")
+	}
+
+	return nil
+}
+
+// curveSize - the size in bytes of a scalar or coordinate of the curve
+func curveSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+// halfOrder - the largest s a canonical signature may have
+func halfOrder(curve elliptic.Curve) *big.Int {
+	return new(big.Int).Rsh(curve.Params().N, 1)
+}
+
+// hashToInt - the integer of the leftmost bits of hash, as many as the curve
+// order has
+func hashToInt(hash []byte, curve elliptic.Curve) *big.Int {
+	orderBits := curve.Params().N.BitLen()
+	orderBytes := (orderBits + 7) / 8
+	if len(hash) > orderBytes {
+		hash = hash[:orderBytes]
+	}
+
+	ret := new(big.Int).SetBytes(hash)
+	excess := len(hash)*8 - orderBits
+	if excess > 0 {
+		ret.Rsh(ret, uint(excess))
+	}
+
+	return ret
+}
+
+// nonceRFC6979 - the HMAC-SHA256 DRBG of RFC 6979 section 3.2, yielding the
+// nonce candidates for a legacy P-256 private key and a message hash
+type nonceRFC6979 struct {
+	n *big.Int
+	k []byte
+	v []byte
+}
+
+func newNonceRFC6979(d *big.Int, hash []byte, curve elliptic.Curve) *nonceRFC6979 {
+	n := curve.Params().N
+	rolen := (n.BitLen() + 7) / 8
+
+	// int2octets(x) and bits2octets(h1)
+	x := make([]byte, rolen)
+	d.FillBytes(x)
+	h := make([]byte, rolen)
+	new(big.Int).Mod(hashToInt(hash, curve), n).FillBytes(h)
+
+	drbg := &nonceRFC6979{
+		n: n,
+		k: make([]byte, sha256.Size),
+		v: make([]byte, sha256.Size),
+	}
+	for i := range drbg.v {
+		drbg.v[i] = 0x01
+	}
+
+	drbg.k = drbg.mac(drbg.v, []byte{0x00}, x, h)
+	drbg.v = drbg.mac(drbg.v)
+	drbg.k = drbg.mac(drbg.v, []byte{0x01}, x, h)
+	drbg.v = drbg.mac(drbg.v)
+
+	return drbg
+}
+
+// next - the next nonce candidate, in [1, n-1]
+func (drbg *nonceRFC6979) next() *big.Int {
+	qlen := drbg.n.BitLen()
+
+	for {
+		var t []byte
+		for len(t)*8 < qlen {
+			drbg.v = drbg.mac(drbg.v)
+			t = append(t, drbg.v...)
+		}
+
+		// bits2int(T)
+		k := new(big.Int).SetBytes(t)
+		if excess := len(t)*8 - qlen; excess > 0 {
+			k.Rsh(k, uint(excess))
+		}
+
+		// step the generator on, whether or not this candidate is used
+		drbg.k = drbg.mac(drbg.v, []byte{0x00})
+		drbg.v = drbg.mac(drbg.v)
+
+		if k.Sign() > 0 && k.Cmp(drbg.n) < 0 {
+			return k
+		}
+	}
+}
+
+func (drbg *nonceRFC6979) mac(data ...[]byte) []byte {
+	h := hmac.New(sha256.New, drbg.k)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// testPrivKey - the private key hexKey on curve
+func testPrivKey(t *testing.T, curve elliptic.Curve, hexKey string) ecdsa.PrivateKey {
+	t.Helper()
+
+	d, ok := new(big.Int).SetString(hexKey, 16)
+	if !ok {
+		t.Fatalf("bad private key %s", hexKey)
+	}
+
+	privKey := ecdsa.PrivateKey{D: d}
+	privKey.Curve = curve
+	privKey.X, privKey.Y = curve.ScalarBaseMult(d.Bytes())
+
+	return privKey
+}
+
+// mustHex - the bytes of s, failing the test if it is not hex
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestNonceRFC6979(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve elliptic.Curve
+		key   string
+		msg   string
+		nonce string
+	}{
+		// RFC 6979 A.2.5
+		{"p256 sample", elliptic.P256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721", "sample",
+			"a6e3c57dd01abe90086538398355dd4c3b17aa873382b0f24d6129493d8aad60"},
+		{"p256 test", elliptic.P256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721", "test",
+			"d16b6ae827f17175e040871a1c7ec3500192c4c92677336ec2537acaee0008e0"},
+		// the secp256k1 vectors of Trezor and CoreBitcoin
+		{"secp256k1 sample", secp256k1.S256(), "cca9fbcc1b41e5a95d369eaa6ddcff73b61a4efaa279cfc6567e8daa39cbaf50", "sample",
+			"2df40ca70e639d89528a6b670d9d48d9165fdc0febc0974056bdce192b8e16a3"},
+		{"secp256k1 key 1", secp256k1.S256(), "1", "Satoshi Nakamoto",
+			"8f8a276c19f4149656b280621e358cce24f5f52542772691ee69063b74f15d15"},
+		{"secp256k1 key n-1", secp256k1.S256(), "fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140", "Satoshi Nakamoto",
+			"33a19b60e25fb6f4435af53a3d42d493644827367e6453928554f43e49aa6f90"},
+		{"secp256k1 turing", secp256k1.S256(), "f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181", "Alan Turing",
+			"525a82b70e67874398067543fd84c83d30c175fdc45fdeee082fe13b1d7cfdf1"},
+		{"secp256k1 tears", secp256k1.S256(), "1", "All those moments will be lost in time, like tears in rain. Time to die...",
+			"38aa22d72376b4dbc472e06c3ba403ee0a394da63fc58d88686c611aba98d6b3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			privKey := testPrivKey(t, test.curve, test.key)
+			hash := sha256.Sum256([]byte(test.msg))
+
+			k := newNonceRFC6979(privKey.D, hash[:], test.curve).next()
+			if want := mustHex(t, test.nonce); k.Cmp(new(big.Int).SetBytes(want)) != 0 {
+				t.Fatalf("nonce %x, want %s", k, test.nonce)
+			}
+		})
+	}
+}
+
+func TestSignHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve elliptic.Curve
+		key   string
+		msg   string
+		r, s  string
+	}{
+		// RFC 6979 A.2.5, whose s is lowered below the half order
+		{"p256 sample", elliptic.P256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721", "sample",
+			"efd48b2aacb6a8fd1140dd9cd45e81d69d2c877b56aaf991c34d0ea84eaf3716", "f7cb1c942d657c41d436c7a1b6e29f65f3e900dbb9aff4064dc4ab2f843acda8"},
+		{"p256 test", elliptic.P256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721", "test",
+			"f1abb023518351cd71d881567b1ea663ed3efcf6c5132b354f28d3b0b7d38367", "019f4113742a2b14bd25926b49c649155f267e60d3814b4c0cc84250e46f0083"},
+		{"secp256k1 key 1", secp256k1.S256(), "1", "Satoshi Nakamoto",
+			"934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8", "2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5"},
+		{"secp256k1 tears", secp256k1.S256(), "1", "All those moments will be lost in time, like tears in rain. Time to die...",
+			"8600dbd41e348fe5c9465ab92d23e3db8b98b873beecd930736488696438cb6b", "547fe64427496db33bf66019dacbf0039c04199abb0122918601db38a72cfc21"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			privKey := testPrivKey(t, test.curve, test.key)
+			hash := sha256.Sum256([]byte(test.msg))
+
+			signature, err := signHash(privKey, hash[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := new(big.Int).SetBytes(mustHex(t, test.s))
+			if s.Cmp(halfOrder(test.curve)) > 0 {
+				s.Sub(test.curve.Params().N, s)
+			}
+			want := make([]byte, 2*curveSize(test.curve))
+			new(big.Int).SetBytes(mustHex(t, test.r)).FillBytes(want[:len(want)/2])
+			s.FillBytes(want[len(want)/2:])
+
+			if hex.EncodeToString(signature) != hex.EncodeToString(want) {
+				t.Fatalf("signature %x, want %x", signature, want)
+			}
+
+			again, err := signHash(privKey, hash[:])
+			if err != nil || hex.EncodeToString(again) != hex.EncodeToString(signature) {
+				t.Fatalf("signing again gave %x", again)
+			}
+
+			if !verifySignature(pubKeyBytes(&privKey.PublicKey), signature, hash[:]) {
+				t.Fatal("signature does not verify")
+			}
+		})
+	}
+}
+
+func TestVerifySignatureRejectsMalleated(t *testing.T) {
+	privKey := testPrivKey(t, secp256k1.S256(), "1")
+	pubKey := pubKeyBytes(&privKey.PublicKey)
+	hash := sha256.Sum256([]byte("Satoshi Nakamoto"))
+
+	signature, err := signHash(privKey, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := secp256k1.S256().Params().N
+
+	// withScalar - signature with its r or s, at offset, replaced by value
+	withScalar := func(offset int, value *big.Int) []byte {
+		changed := append([]byte{}, signature...)
+		value.FillBytes(changed[offset : offset+32])
+		return changed
+	}
+
+	tests := []struct {
+		name      string
+		signature []byte
+	}{
+		{"high s", withScalar(32, new(big.Int).Sub(n, new(big.Int).SetBytes(signature[32:])))},
+		{"zero r", withScalar(0, big.NewInt(0))},
+		{"zero s", withScalar(32, big.NewInt(0))},
+		{"r of n", withScalar(0, n)},
+		{"short", signature[1:]},
+		{"long", append([]byte{0}, signature...)},
+		{"empty", nil},
+		{"other hash", withScalar(0, new(big.Int).Add(new(big.Int).SetBytes(signature[:32]), big.NewInt(1)))},
+	}
+
+	if !verifySignature(pubKey, signature, hash[:]) {
+		t.Fatal("signature does not verify")
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if verifySignature(pubKey, test.signature, hash[:]) {
+				t.Fatalf("signature %x verified", test.signature)
+			}
+		})
+	}
+}
+
+func TestSignInputRejectsMalleated(t *testing.T) {
+	wallet := NewWallet()
+	prevOutput := TXOutput{Value: 5, ScriptPubKey: AddressScript(string(wallet.GetAddress()))}
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: []byte{1}, Vout: 0, Sequence: MaxTxInSequenceNum}},
+		Vout:    []TXOutput{{Value: 4}},
+	}
+
+	err := tx.SignInput(wallet.PrivateKey, 0, prevOutput, SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.VerifyInput(0, prevOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := parseScript(tx.Vin[0].ScriptSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := append([]byte{}, ops[0].data...)
+	n := wallet.PrivateKey.Curve.Params().N
+	new(big.Int).Sub(n, new(big.Int).SetBytes(signature[32:64])).FillBytes(signature[32:64])
+	tx.Vin[0].ScriptSig = pubKeyHashScriptSig(signature, ops[1].data)
+
+	if tx.VerifyInput(0, prevOutput) == nil {
+		t.Fatal("input with a high s signature verified")
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// htlcSecretSize - contract secrets are 32 bytes, so a secret cannot be
+// made to match on one chain and not on another
+const htlcSecretSize = 32
+
+// ScriptClass - the kinds of locking scripts the wallet knows how to spend
+type ScriptClass int
+
+// script classes
+const (
+	NonStandardTy ScriptClass = iota
+	PubKeyHashTy
+	ScriptHashTy
+	MultiSigTy
+	HTLCTy
+	SchnorrPubKeyTy
+)
+
+var scriptClassNames = map[ScriptClass]string{
+	NonStandardTy:   "nonstandard",
+	PubKeyHashTy:    "pubkeyhash",
+	ScriptHashTy:    "scripthash",
+	MultiSigTy:      "multisig",
+	HTLCTy:          "htlc",
+	SchnorrPubKeyTy: "schnorrpubkey",
+}
+
+// String - returns the name of the script class
+func (c ScriptClass) String() string {
+	return scriptClassNames[c]
+}
+
+// GetScriptClass - works out which template a locking script follows
+func GetScriptClass(script []byte) ScriptClass {
+	if ExtractPubKeyHash(script) != nil {
+		return PubKeyHashTy
+	}
+
+	if ExtractScriptHash(script) != nil {
+		return ScriptHashTy
+	}
+
+	if ExtractSchnorrPubKey(script) != nil {
+		return SchnorrPubKeyTy
+	}
+
+	if _, _, err := ExtractMultiSig(script); err == nil {
+		return MultiSigTy
+	}
+
+	if _, err := ExtractHTLC(script); err == nil {
+		return HTLCTy
+	}
+
+	return NonStandardTy
+}
+
+// PayToPubKeyHashScript - the locking script paying to the hash of a public key:
+// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OpDup).
+		AddOp(OpHash160).
+		AddData(pubKeyHash).
+		AddOp(OpEqualVerify).
+		AddOp(OpCheckSig).
+		Script()
+}
+
+// ExtractPubKeyHash - returns the hash a pay-to-pubkey-hash script locks to,
+// or nil for any other script
+func ExtractPubKeyHash(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 5 {
+		return nil
+	}
+
+	if ops[0].opcode != OpDup ||
+		ops[1].opcode != OpHash160 ||
+		len(ops[2].data) != 20 ||
+		ops[3].opcode != OpEqualVerify ||
+		ops[4].opcode != OpCheckSig {
+		return nil
+	}
+
+	return ops[2].data
+}
+
+// pubKeyHashScriptSig - the unlocking script for a pay-to-pubkey-hash output
+func pubKeyHashScriptSig(signature, pubKey []byte) []byte {
+	return NewScriptBuilder().AddData(signature).AddData(pubKey).Script()
+}
+
+// extractScriptSigPubKey - returns the public key pushed last by a
+// pay-to-pubkey-hash unlocking script, or nil
+func extractScriptSigPubKey(scriptSig []byte) []byte {
+	ops, err := parseScript(scriptSig)
+	if err != nil || len(ops) != 2 {
+		return nil
+	}
+
+	return ops[1].data
+}
+
+// PayToScriptHashScript - the locking script paying to the hash of a redeem
+// script, which the spender reveals and satisfies: OP_HASH160 <hash> OP_EQUAL
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OpHash160).
+		AddData(scriptHash).
+		AddOp(OpEqual).
+		Script()
+}
+
+// ExtractScriptHash - returns the hash a pay-to-script-hash script locks to,
+// or nil for any other script
+func ExtractScriptHash(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 3 {
+		return nil
+	}
+
+	if ops[0].opcode != OpHash160 ||
+		len(ops[1].data) != 20 ||
+		ops[2].opcode != OpEqual {
+		return nil
+	}
+
+	return ops[1].data
+}
+
+// PayToSchnorrPubKeyScript - the locking script paying to an x-only key, which
+// signs with BIP340 Schnorr signatures: <pubkey> OP_CHECKSIG
+func PayToSchnorrPubKeyScript(pubKey []byte) []byte {
+	return NewScriptBuilder().AddData(pubKey).AddOp(OpCheckSig).Script()
+}
+
+// ExtractSchnorrPubKey - returns the key a pay-to-schnorr-pubkey script locks
+// to, or nil for any other script
+func ExtractSchnorrPubKey(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 2 {
+		return nil
+	}
+
+	if len(ops[0].data) != schnorrPubKeyLen || ops[1].opcode != OpCheckSig {
+		return nil
+	}
+
+	return ops[0].data
+}
+
+// MultiSigScript - the redeem script needing m signatures from pubKeys:
+// m <pubkey>... n OP_CHECKMULTISIG
+func MultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) < 1 || len(pubKeys) > 16 {
+		return nil, fmt.Errorf("multisig needs between 1 and 16 keys, got %d", len(pubKeys))
+	}
+
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("cannot require %d signatures from %d keys", m, len(pubKeys))
+	}
+
+	builder := NewScriptBuilder().AddInt64(int64(m))
+	for i, pubKey := range pubKeys {
+		if _, err := parsePubKey(pubKey); err != nil {
+			return nil, fmt.Errorf("public key %d is not valid: %v", i, err)
+		}
+		for _, other := range pubKeys[:i] {
+			if bytes.Equal(pubKey, other) {
+				return nil, fmt.Errorf("public key %x appears twice", pubKey)
+			}
+		}
+		builder.AddData(pubKey)
+	}
+	script := builder.AddInt64(int64(len(pubKeys))).AddOp(OpCheckMultiSig).Script()
+
+	// the redeem script is pushed whole when it is spent
+	if len(script) > maxScriptElementSize {
+		return nil, fmt.Errorf("multisig script of %d bytes is larger than %d", len(script), maxScriptElementSize)
+	}
+
+	return script, nil
+}
+
+// ExtractMultiSig - returns the number of signatures and the keys of a
+// multisig script
+func ExtractMultiSig(script []byte) (int, [][]byte, error) {
+	errNotMultiSig := errors.New("not a multisig script")
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 {
+		return 0, nil, errNotMultiSig
+	}
+
+	numKeys := len(ops) - 3
+	m := smallInt(ops[0].opcode)
+	if m < 1 || m > numKeys ||
+		smallInt(ops[len(ops)-2].opcode) != numKeys ||
+		ops[len(ops)-1].opcode != OpCheckMultiSig {
+		return 0, nil, errNotMultiSig
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if len(op.data) == 0 {
+			return 0, nil, errNotMultiSig
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+
+	return m, pubKeys, nil
+}
+
+// HTLC - the terms of a hash time-locked contract
+type HTLC struct {
+	// RecipientHash - the key hash that can redeem with the secret
+	RecipientHash []byte
+	// RefundHash - the key hash that can take the coins back after LockTime
+	RefundHash []byte
+	// SecretHash - the SHA-256 of the secret
+	SecretHash []byte
+	// LockTime - the block height or unix time the refund opens at
+	LockTime uint32
+}
+
+// HTLCScript - the redeem script of a hash time-locked contract. The
+// recipient takes the coins by revealing the secret, or the refund key takes
+// them back once the lock time has passed:
+//
+//	OP_IF
+//		OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <secret hash> OP_EQUALVERIFY
+//		OP_DUP OP_HASH160 <recipient hash>
+//	OP_ELSE
+//		<lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//		OP_DUP OP_HASH160 <refund hash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func HTLCScript(htlc *HTLC) ([]byte, error) {
+	if len(htlc.RecipientHash) != 20 || len(htlc.RefundHash) != 20 {
+		return nil, errors.New("key hashes must be 20 bytes")
+	}
+
+	if len(htlc.SecretHash) != sha256.Size {
+		return nil, fmt.Errorf("secret hash must be %d bytes", sha256.Size)
+	}
+
+	if htlc.LockTime == 0 {
+		return nil, errors.New("contract needs a lock time")
+	}
+
+	return NewScriptBuilder().
+		AddOp(OpIf).
+		AddOp(OpSize).AddInt64(htlcSecretSize).AddOp(OpEqualVerify).
+		AddOp(OpSHA256).AddData(htlc.SecretHash).AddOp(OpEqualVerify).
+		AddOp(OpDup).AddOp(OpHash160).AddData(htlc.RecipientHash).
+		AddOp(OpElse).
+		AddInt64(int64(htlc.LockTime)).AddOp(OpCheckLockTimeVerify).AddOp(OpDrop).
+		AddOp(OpDup).AddOp(OpHash160).AddData(htlc.RefundHash).
+		AddOp(OpEndIf).
+		AddOp(OpEqualVerify).
+		AddOp(OpCheckSig).
+		Script(), nil
+}
+
+// IsSecret - whether secret is the one the contract is redeemed with, of
+// the size its script takes and hashing to its secret hash
+func (h *HTLC) IsSecret(secret []byte) bool {
+	secretHash := sha256.Sum256(secret)
+
+	return len(secret) == htlcSecretSize && bytes.Equal(secretHash[:], h.SecretHash)
+}
+
+// ExtractHTLC - returns the terms of a script made by HTLCScript
+func ExtractHTLC(script []byte) (*HTLC, error) {
+	errNotHTLC := errors.New("not a hash time-locked contract")
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 20 {
+		return nil, errNotHTLC
+	}
+
+	template := []byte{
+		OpIf, OpSize, Op0, OpEqualVerify, OpSHA256, Op0, OpEqualVerify, OpDup, OpHash160, Op0,
+		OpElse, Op0, OpCheckLockTimeVerify, OpDrop, OpDup, OpHash160, Op0, OpEndIf, OpEqualVerify, OpCheckSig,
+	}
+	for i, opcode := range template {
+		// the pushes are checked below
+		if opcode != Op0 && ops[i].opcode != opcode {
+			return nil, errNotHTLC
+		}
+	}
+
+	secretSize, err := makeScriptNum(ops[2].data, maxScriptNumLen)
+	if err != nil || secretSize != htlcSecretSize {
+		return nil, errNotHTLC
+	}
+
+	lockTime := int64(smallInt(ops[11].opcode))
+	if lockTime == 0 {
+		lockTime, err = makeScriptNum(ops[11].data, 5)
+		if err != nil || lockTime <= 0 || lockTime > math.MaxUint32 {
+			return nil, errNotHTLC
+		}
+	}
+
+	htlc := HTLC{
+		RecipientHash: ops[9].data,
+		RefundHash:    ops[16].data,
+		SecretHash:    ops[5].data,
+		LockTime:      uint32(lockTime),
+	}
+	if len(htlc.RecipientHash) != 20 || len(htlc.RefundHash) != 20 || len(htlc.SecretHash) != sha256.Size {
+		return nil, errNotHTLC
+	}
+
+	return &htlc, nil
+}
+
+// smallInt - the value pushed by OP_1 to OP_16, or 0
+func smallInt(opcode byte) int {
+	if opcode < Op1 || opcode > Op16 {
+		return 0
+	}
+	return int(opcode-Op1) + 1
+}
+
+// scriptSigPushes - the data pushed by an unlocking script
+func scriptSigPushes(scriptSig []byte) ([][]byte, error) {
+	ops, err := parseScript(scriptSig)
+	if err != nil {
+		return nil, err
+	}
+
+	var pushes [][]byte
+	for _, op := range ops {
+		if !op.isPush() || op.opcode > OpPushData2 {
+			return nil, errors.New("unlocking script is not made of data pushes")
+		}
+		pushes = append(pushes, op.data)
+	}
+
+	return pushes, nil
+}
+
+// redeemScriptOf - the redeem script a pay-to-script-hash unlocking script
+// ends with, or nil
+func redeemScriptOf(scriptSig []byte) []byte {
+	ops, err := parseScript(scriptSig)
+	if err != nil || len(ops) == 0 {
+		return nil
+	}
+
+	return ops[len(ops)-1].data
+}
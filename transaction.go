@@ -3,40 +3,43 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
+	"sort"
 	"strings"
 )
 
-const subsidy = 10
+const txVersion = 1
 
-// TXOutput - output of a transaction
+// TXOutput - output of a transaction, locked by a script that the input
+// spending it has to satisfy
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
-	//ScriptPubKey string
+	Value        int
+	ScriptPubKey []byte
 }
 
-// TXInput - input of a transaction
+// TXInput - input of a transaction. For a coinbase the ScriptSig holds
+// arbitrary data and is never run. Sequence holds the relative lock of the
+// input, see MaxTxInSequenceNum
 type TXInput struct {
 	Txid      []byte
 	Vout      int
-	Signature []byte
-	PubKey    []byte
-	//ScriptSig string
+	ScriptSig []byte
+	Sequence  uint32
 }
 
 // UsesKey - checks whether the address initiated this transaction
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := HashPubKey(in.PubKey)
+	pubKey := extractScriptSigPubKey(in.ScriptSig)
+	if pubKey == nil {
+		return false
+	}
 
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
+	return bytes.Compare(HashPubKey(pubKey), pubKeyHash) == 0
 }
 
 // CanUnlockOutputWith - used to unlock input transactions
@@ -44,42 +47,68 @@ func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
 //	return tin.ScriptSig == unlockingData
 //}
 
-// Lock - used to set the pubkeyhash
+// Lock - lock the output to an address
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-
-	out.PubKeyHash = pubKeyHash
+	out.ScriptPubKey = AddressScript(string(address))
 }
 
-// IsLockedWithKey - used to verify the signature/lock
+// IsLockedWithKey - checks whether the output pays to the hash of a public key
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	lockingHash := ExtractPubKeyHash(out.ScriptPubKey)
+
+	return lockingHash != nil && bytes.Compare(lockingHash, pubKeyHash) == 0
 }
 
 // NewTXOutput - creates a new TXOutput
 func NewTXOutput(value int, address string) *TXOutput {
 	txo := &TXOutput{
-		Value:      value,
-		PubKeyHash: nil,
+		Value:        value,
+		ScriptPubKey: nil,
 	}
 	txo.Lock([]byte(address))
 	return txo
 }
 
-// TXOutputs - collection of outputs
+// TXOutputs - collection of outputs keyed by their index in the transaction,
+// along with the height of the block that created them, the median time past
+// of its parent, which relative time locks count from, and the kind of the
+// transaction that created them
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs  map[int]TXOutput
+	Height   int
+	Time     int64
+	Coinbase bool
+}
+
+// IsMature - checks whether the outputs can be spent in a block at spendHeight
+func (outs *TXOutputs) IsMature(spendHeight int) bool {
+	return !outs.Coinbase || spendHeight-outs.Height >= activeParams.CoinbaseMaturity
 }
 
-// Serialize - serialize the outputs
+// Serialize - serialize the outputs: the height, the time, the coinbase flag,
+// then each output prefixed with its index, in index order
 func (outs *TXOutputs) Serialize() []byte {
 	var buff bytes.Buffer
 
-	encoder := gob.NewEncoder(&buff)
-	err := encoder.Encode(outs)
-	if err != nil {
-		log.Panic(err)
+	writeUint64(&buff, uint64(outs.Height))
+	writeUint64(&buff, uint64(outs.Time))
+	if outs.Coinbase {
+		buff.WriteByte(1)
+	} else {
+		buff.WriteByte(0)
+	}
+
+	var indexes []int
+	for outIdx := range outs.Outputs {
+		indexes = append(indexes, outIdx)
+	}
+	sort.Ints(indexes)
+
+	writeUint32(&buff, uint32(len(indexes)))
+	for _, outIdx := range indexes {
+		out := outs.Outputs[outIdx]
+		writeUint32(&buff, uint32(outIdx))
+		out.serialize(&buff)
 	}
 
 	return buff.Bytes()
@@ -87,10 +116,20 @@ func (outs *TXOutputs) Serialize() []byte {
 
 // DeSerializeOutputs - deserialize the TXOutputs
 func DeSerializeOutputs(data []byte) TXOutputs {
-	var outputs TXOutputs
+	outputs := TXOutputs{Outputs: make(map[int]TXOutput)}
+	br := newByteReader(data)
+
+	outputs.Height = int(br.uint64())
+	outputs.Time = int64(br.uint64())
+	outputs.Coinbase = br.uint8() == 1
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&outputs)
+	count := br.uint32()
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		outIdx := int(br.uint32())
+		outputs.Outputs[outIdx] = readTXOutput(br)
+	}
+
+	err := br.done()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -98,44 +137,95 @@ func DeSerializeOutputs(data []byte) TXOutputs {
 	return outputs
 }
 
-// CanBeUnlockedWith - used to unlock output transactions
-//func (tout *TXOutput) CanBeUnlockedWith(unlockingData string) bool {
-//	return tout.ScriptPubKey == unlockingData
-//}
+func (out *TXOutput) serialize(buff *bytes.Buffer) {
+	writeUint64(buff, uint64(out.Value))
+	writeBytes(buff, out.ScriptPubKey)
+}
 
-// Transaction - makes the life of a block worthwhile
+func readTXOutput(br *byteReader) TXOutput {
+	return TXOutput{
+		Value:        int(int64(br.uint64())),
+		ScriptPubKey: br.bytes(),
+	}
+}
+
+func (in *TXInput) serialize(buff *bytes.Buffer, withScriptSig bool) {
+	writeBytes(buff, in.Txid)
+	writeUint32(buff, uint32(int32(in.Vout)))
+	if withScriptSig {
+		writeBytes(buff, in.ScriptSig)
+	} else {
+		writeBytes(buff, nil)
+	}
+	writeUint32(buff, in.Sequence)
+}
+
+func readTXInput(br *byteReader) TXInput {
+	return TXInput{
+		Txid:      br.bytes(),
+		Vout:      int(int32(br.uint32())),
+		ScriptSig: br.bytes(),
+		Sequence:  br.uint32(),
+	}
+}
+
+// Transaction - makes the life of a block worthwhile. It may not go in a
+// block before LockTime, see IsFinalTx
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID       []byte
+	Version  int32
+	Vin      []TXInput
+	Vout     []TXOutput
+	LockTime uint32
 }
 
 // IsCoinbase - identify the coinbase transaction
 func (tx *Transaction) IsCoinbase() bool {
-	return len(tx.Vin) == 1 && len(tx.Vout) == 0 && tx.Vin[0].Vout == -1
+	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// Serialize - serialize a transaction
+// Serialize - the canonical encoding of a transaction, used for storage and
+// the wire. The ID is not encoded, it is derived from the rest.
+//
+//	tx     = version:u32 | count:u32 | input* | count:u32 | output* | locktime:u32
+//	input  = txid:bytes | vout:i32 | scriptsig:bytes | sequence:u32
+//	output = value:i64 | scriptpubkey:bytes
+//	bytes  = length:u32 | data
+//
+// All integers are big-endian.
 func (tx *Transaction) Serialize() []byte {
+	return tx.serialize(true)
+}
+
+func (tx *Transaction) serialize(withSignatures bool) []byte {
 	var encoded bytes.Buffer
 
-	encoder := gob.NewEncoder(&encoded)
-	err := encoder.Encode(tx)
-	if err != nil {
-		log.Panic(err)
+	writeUint32(&encoded, uint32(tx.Version))
+
+	// the coinbase data is what keeps coinbase IDs apart, so it is always kept
+	withScriptSigs := withSignatures || tx.IsCoinbase()
+
+	writeUint32(&encoded, uint32(len(tx.Vin)))
+	for _, vin := range tx.Vin {
+		vin.serialize(&encoded, withScriptSigs)
 	}
 
+	writeUint32(&encoded, uint32(len(tx.Vout)))
+	for _, vout := range tx.Vout {
+		vout.serialize(&encoded)
+	}
+
+	writeUint32(&encoded, tx.LockTime)
+
 	return encoded.Bytes()
 }
 
-// Hash - return the hash of the transaction
+// Hash - return the hash of the transaction. Unlocking scripts are left out so
+// the ID, which is set to this hash, stays the same once the inputs are signed
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
-
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(tx.serialize(false))
 
 	return hash[:]
 }
@@ -150,16 +240,25 @@ func (tx *Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("    Input   %d: ", i))
 		lines = append(lines, fmt.Sprintf("      TXID      %x: ", vin.Txid))
 		lines = append(lines, fmt.Sprintf("      Out       %d: ", vin.Vout))
-		lines = append(lines, fmt.Sprintf("      Signature %x: ", vin.Signature))
-		lines = append(lines, fmt.Sprintf("      PubKey    %x: ", vin.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("      Coinbase  %x: ", vin.ScriptSig))
+		} else {
+			lines = append(lines, fmt.Sprintf("      ScriptSig %s: ", DisasmString(vin.ScriptSig)))
+		}
+		lines = append(lines, fmt.Sprintf("      Sequence  %08x: ", vin.Sequence))
 	}
 
 	for i, vout := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("    Output  %d: ", i))
 		lines = append(lines, fmt.Sprintf("      Value  %d: ", vout.Value))
-		lines = append(lines, fmt.Sprintf("      Script %x: ", vout.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("      Script %s: ", DisasmString(vout.ScriptPubKey)))
+		if address := ExtractAddress(vout.ScriptPubKey); address != "" {
+			lines = append(lines, fmt.Sprintf("      Address %s: ", address))
+		}
 	}
 
+	lines = append(lines, fmt.Sprintf("    LockTime %d: ", tx.LockTime))
+
 	return strings.Join(lines, "\n")
 }
 
@@ -172,22 +271,24 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 		inputs = append(inputs, TXInput{
 			Txid:      vin.Txid,
 			Vout:      vin.Vout,
-			Signature: nil,
-			PubKey:    nil,
+			ScriptSig: nil,
+			Sequence:  vin.Sequence,
 		})
 	}
 
 	for _, vout := range tx.Vout {
 		outputs = append(outputs, TXOutput{
-			Value:      vout.Value,
-			PubKeyHash: vout.PubKeyHash,
+			Value:        vout.Value,
+			ScriptPubKey: vout.ScriptPubKey,
 		})
 	}
 
 	txCopy := Transaction{
-		ID:   tx.ID,
-		Vin:  inputs,
-		Vout: outputs,
+		ID:       tx.ID,
+		Version:  tx.Version,
+		Vin:      inputs,
+		Vout:     outputs,
+		LockTime: tx.LockTime,
 	}
 
 	return txCopy
@@ -195,23 +296,12 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 
 // SetID - set the tx id
 func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-
-	if err != nil {
-		log.Panic(err)
-	}
-
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
-
+	tx.ID = tx.Hash()
 }
 
-// NewCoinbaseTX - generate a new coinbase tx
-func NewCoinbaseTX(to, data string) *Transaction {
+// NewCoinbaseTX - generate a new coinbase tx for the block at height, paying
+// the subsidy plus the fees collected from the other transactions in the block
+func NewCoinbaseTX(to, data string, height, fees int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -224,35 +314,57 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	txin := TXInput{
 		Txid:      []byte{},
 		Vout:      -1,
-		Signature: nil,
-		PubKey:    []byte(data),
+		ScriptSig: []byte(data),
+		Sequence:  MaxTxInSequenceNum,
 	}
 
-	txout := NewTXOutput(subsidy, to)
+	txout := NewTXOutput(activeParams.CalcBlockSubsidy(height)+fees, to)
 
 	tx := Transaction{
-		ID:   nil,
-		Vin:  []TXInput{txin},
-		Vout: []TXOutput{*txout},
+		ID:      nil,
+		Version: txVersion,
+		Vin:     []TXInput{txin},
+		Vout:    []TXOutput{*txout},
 	}
 
-	//tx.SetID()
-	tx.ID = tx.Hash()
+	tx.SetID()
 
 	return &tx
 }
 
-// NewUTXOTransaction - create a new UTXO
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
+// NewUTXOTransaction - create a new UTXO, leaving fee for the miner. A non
+// zero lockTime keeps it out of blocks before that height or time
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, lockTime uint32, UTXOSet *UTXOSet) (*Transaction, error) {
+	from := fmt.Sprintf("%s", wallet.GetAddress())
+
+	tx, err := newSpendTransaction(from, to, amount, fee, lockTime, UTXOSet)
+	if err != nil {
+		return nil, err
+	}
+	UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx, nil
+}
+
+// newSpendTransaction - build an unsigned transaction paying amount from the
+// outputs locked to from, sending the change back to from
+func newSpendTransaction(from, to string, amount, fee int, lockTime uint32, UTXOSet *UTXOSet) (*Transaction, error) {
 	var inputs []TXInput
 	var outputs []TXOutput
 
-	pubKeyHash := HashPubKey(wallet.PublicKey)
+	acc, validOutputs, err := UTXOSet.FindSpendableOutputs(AddressScript(from), amount+fee)
+	if err != nil {
+		return nil, err
+	}
 
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	if acc < amount+fee {
+		return nil, errors.New("not enough funds")
+	}
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+	// the lock time is only enforced while an input is not final
+	sequence := MaxTxInSequenceNum
+	if lockTime != 0 {
+		sequence = MaxTxInSequenceNum - 1
 	}
 
 	// build the inputs
@@ -266,34 +378,35 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet)
 			input := TXInput{
 				Txid:      txID,
 				Vout:      out,
-				Signature: nil,
-				PubKey:    wallet.PublicKey,
+				ScriptSig: nil,
+				Sequence:  sequence,
 			}
 			inputs = append(inputs, input)
 		}
 
 	}
 
-	from := fmt.Sprintf("%s", wallet.GetAddress())
 	// build the outputs
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if change := acc - amount - fee; change > 0 && !avoidsChange(UTXOSet.Selector, change) {
+		outputs = append(outputs, *NewTXOutput(change, from))
 	}
 
 	tx := Transaction{
-		ID:   nil,
-		Vin:  inputs,
-		Vout: outputs,
+		ID:       nil,
+		Version:  txVersion,
+		Vin:      inputs,
+		Vout:     outputs,
+		LockTime: lockTime,
 	}
-	//tx.SetID()
-	tx.ID = tx.Hash()
-	UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+	tx.SetID()
 
-	return &tx
+	return &tx, nil
 }
 
-// Sign - sign each input of the specified transaction
+// Sign - sign each input of the specified transaction with SigHashAll. Inputs
+// spending a multisig output keep the signatures already on them, so each
+// holder of a key signs in turn
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
 	if tx.IsCoinbase() {
 		return
@@ -305,89 +418,164 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inID, vin := range txCopy.Vin {
+	for inID, vin := range tx.Vin {
 		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTX.Vout[vin.Vout].PubKeyHash
-		//txCopy.ID = txCopy.Hash()
-		//txCopy.Vin[inID].PubKey = nil
-
-		//r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+
+		err := tx.SignInput(privKey, inID, prevTX.Vout[vin.Vout], SigHashAll)
 		if err != nil {
 			log.Panic(err)
 		}
+	}
 
-		signature := append(r.Bytes(), s.Bytes()...)
+}
 
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+// SignInput - sign a single input, committing to the parts of the
+// transaction selected by hashType, and set its unlocking script
+func (tx *Transaction) SignInput(privKey ecdsa.PrivateKey, inputIndex int, prevOutput TXOutput, hashType SigHashType) error {
+	if inputIndex < 0 || inputIndex >= len(tx.Vin) {
+		return errors.New("input index out of range")
 	}
 
+	switch GetScriptClass(prevOutput.ScriptPubKey) {
+	case PubKeyHashTy:
+		pubKey := pubKeyBytes(&privKey.PublicKey)
+		if !bytes.Equal(HashPubKey(pubKey), ExtractPubKeyHash(prevOutput.ScriptPubKey)) {
+			return errors.New("key does not match the output being spent")
+		}
+
+		signature, err := tx.signature(privKey, inputIndex, prevOutput, hashType)
+		if err != nil {
+			return err
+		}
+		tx.Vin[inputIndex].ScriptSig = pubKeyHashScriptSig(signature, pubKey)
+	case SchnorrPubKeyTy:
+		if !schnorrKeyMatches(privKey, ExtractSchnorrPubKey(prevOutput.ScriptPubKey)) {
+			return errors.New("key does not match the output being spent")
+		}
+
+		signature, err := tx.schnorrSignature(privKey, inputIndex, prevOutput, hashType)
+		if err != nil {
+			return err
+		}
+		tx.Vin[inputIndex].ScriptSig = NewScriptBuilder().AddData(signature).Script()
+	case ScriptHashTy:
+		if GetScriptClass(redeemScriptOf(tx.Vin[inputIndex].ScriptSig)) == HTLCTy {
+			return tx.signHTLCInput(privKey, inputIndex, prevOutput, hashType)
+		}
+		return tx.signMultiSigInput(privKey, inputIndex, prevOutput, hashType)
+	default:
+		return fmt.Errorf("cannot sign for script %s", DisasmString(prevOutput.ScriptPubKey))
+	}
+
+	return nil
 }
 
-// Verify - verify the signatures of transaction inputs
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
+// signature - signs input inputIndex against scriptCode, the output it spends
+// with the script its signatures are checked against, and appends hashType
+func (tx *Transaction) signature(privKey ecdsa.PrivateKey, inputIndex int, scriptCode TXOutput, hashType SigHashType) ([]byte, error) {
+	dataToSign, err := SignatureHash(tx, inputIndex, scriptCode, hashType)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("Err : Previous transaction is incorrect.")
-		}
+	signature, err := signHash(privKey, dataToSign)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signature, byte(hashType)), nil
+}
+
+// schnorrSignature - signature, with a BIP340 Schnorr signature
+func (tx *Transaction) schnorrSignature(privKey ecdsa.PrivateKey, inputIndex int, scriptCode TXOutput, hashType SigHashType) ([]byte, error) {
+	dataToSign, err := SignatureHash(tx, inputIndex, scriptCode, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := schnorrSign(privKey, dataToSign)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signature, byte(hashType)), nil
+}
+
+// checkSignature - checks a signature made by signature or schnorrSignature
+// against pubKey, telling the two apart by the length of the key
+func (tx *Transaction) checkSignature(inputIndex int, scriptCode TXOutput, signature, pubKey []byte) bool {
+	if len(signature) < 1 {
+		return false
+	}
+
+	hashType := SigHashType(signature[len(signature)-1])
+	hash, err := SignatureHash(tx, inputIndex, scriptCode, hashType)
+	if err != nil {
+		return false
+	}
+
+	if len(pubKey) == schnorrPubKeyLen {
+		return schnorrVerify(pubKey, signature[:len(signature)-1], hash)
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	return verifySignature(pubKey, signature[:len(signature)-1], hash)
+}
+
+// Verify - run the scripts of the transaction inputs. An input whose
+// previous transaction or output is missing fails
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
 
 	for inID, vin := range tx.Vin {
 		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTX.Vout[vin.Vout].PubKeyHash
-		//txCopy.ID = txCopy.Hash()
-		//txCopy.Vin[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		rawPubKey := ecdsa.PublicKey{
-			Curve: curve,
-			X:     &x,
-			Y:     &y,
-		}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		if prevTX.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
 			return false
 		}
 
+		if tx.VerifyInput(inID, prevTX.Vout[vin.Vout]) != nil {
+			return false
+		}
 	}
 
 	return true
 }
 
-// DeserializeTransaction - deserialize transaction
-func DeserializeTransaction(data []byte) Transaction {
+// VerifyInput - run the unlocking script of a single input against the
+// locking script of the output it spends
+func (tx *Transaction) VerifyInput(inputIndex int, prevOutput TXOutput) error {
+	return VerifyScript(tx, inputIndex, prevOutput)
+}
+
+// DeserializeTransaction - deserialize transaction and derive its ID
+func DeserializeTransaction(data []byte) (Transaction, error) {
 	var transaction Transaction
+	br := newByteReader(data)
+
+	transaction.Version = int32(br.uint32())
+	if br.err == nil && (transaction.Version < 1 || transaction.Version > txVersion) {
+		return transaction, fmt.Errorf("unknown transaction version %d", transaction.Version)
+	}
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	count := br.uint32()
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		transaction.Vin = append(transaction.Vin, readTXInput(br))
+	}
+
+	count = br.uint32()
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		transaction.Vout = append(transaction.Vout, readTXOutput(br))
+	}
+
+	transaction.LockTime = br.uint32()
+
+	err := br.done()
 	if err != nil {
-		log.Panic(err)
+		return Transaction{}, err
 	}
 
-	return transaction
+	transaction.SetID()
+
+	return transaction, nil
 }
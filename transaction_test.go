@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTransactionRoundTrip(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+	}{
+		{"coinbase", NewCoinbaseTX(address, "", 3, 2)},
+		{"spend", &Transaction{
+			Version: txVersion,
+			Vin: []TXInput{
+				{Txid: bytes.Repeat([]byte{1}, hashLen), Vout: 0, ScriptSig: []byte{1, 2, 3}, Sequence: MaxTxInSequenceNum},
+				{Txid: bytes.Repeat([]byte{2}, hashLen), Vout: 7, ScriptSig: []byte{}, Sequence: 5},
+			},
+			Vout: []TXOutput{
+				*NewTXOutput(4, address),
+				{Value: maxMoney, ScriptPubKey: []byte{OpReturn}},
+			},
+			LockTime: lockTimeThreshold + 1,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.tx.SetID()
+			data := test.tx.Serialize()
+
+			decoded, err := DeserializeTransaction(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded.ID, test.tx.ID) {
+				t.Fatalf("ID decodes as %x, want %x", decoded.ID, test.tx.ID)
+			}
+			if !bytes.Equal(decoded.Serialize(), data) {
+				t.Fatal("transaction encodes differently once decoded")
+			}
+			if decoded.LockTime != test.tx.LockTime || len(decoded.Vin) != len(test.tx.Vin) || len(decoded.Vout) != len(test.tx.Vout) {
+				t.Fatalf("transaction decodes as %v, want %v", &decoded, test.tx)
+			}
+			for i := range decoded.Vout {
+				if !reflect.DeepEqual(decoded.Vout[i], test.tx.Vout[i]) {
+					t.Fatalf("output %d decodes as %+v, want %+v", i, decoded.Vout[i], test.tx.Vout[i])
+				}
+			}
+
+			if _, err := DeserializeTransaction(append(data, 0)); err == nil {
+				t.Fatal("transaction with a trailing byte decoded")
+			}
+			for n := 0; n < len(data); n++ {
+				if _, err := DeserializeTransaction(data[:n]); err == nil {
+					t.Fatalf("transaction cut to %d of %d bytes decoded", n, len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionEncoding(t *testing.T) {
+	tx := &Transaction{
+		Version:  1,
+		Vin:      []TXInput{{Txid: []byte{0xaa}, Vout: -1, ScriptSig: []byte{0xbb}, Sequence: 2}},
+		Vout:     []TXOutput{{Value: 3, ScriptPubKey: []byte{0xcc}}},
+		LockTime: 4,
+	}
+
+	want := []byte{
+		0, 0, 0, 1, // version
+		0, 0, 0, 1, // inputs
+		0, 0, 0, 1, 0xaa, // txid
+		0xff, 0xff, 0xff, 0xff, // vout
+		0, 0, 0, 1, 0xbb, // scriptsig
+		0, 0, 0, 2, // sequence
+		0, 0, 0, 1, // outputs
+		0, 0, 0, 0, 0, 0, 0, 3, // value
+		0, 0, 0, 1, 0xcc, // scriptpubkey
+		0, 0, 0, 4, // locktime
+	}
+	if data := tx.Serialize(); !bytes.Equal(data, want) {
+		t.Fatalf("transaction encodes as %x, want %x", data, want)
+	}
+
+	// the ID leaves the unlocking scripts out, so signing does not change it
+	tx.SetID()
+	id := tx.ID
+	tx.Vin[0].ScriptSig = []byte{0xdd, 0xee}
+	tx.SetID()
+	if !bytes.Equal(tx.ID, id) {
+		t.Fatal("ID changed with the unlocking script")
+	}
+	tx.Vout[0].Value = 2
+	tx.SetID()
+	if bytes.Equal(tx.ID, id) {
+		t.Fatal("ID kept when an output changed")
+	}
+
+	for _, version := range []uint32{0, uint32(txVersion + 1)} {
+		data := tx.Serialize()
+		data[0], data[1], data[2], data[3] = byte(version>>24), byte(version>>16), byte(version>>8), byte(version)
+		if _, err := DeserializeTransaction(data); err == nil {
+			t.Errorf("transaction of version %d decoded", version)
+		}
+	}
+}
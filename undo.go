@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	undoBucket = "undo"
+	// undoVersion - leads every undo record, so its encoding can change
+	undoVersion = byte(1)
+)
+
+// SpentOutput - an output consumed by a block, kept so it can be restored
+type SpentOutput struct {
+	Txid     []byte
+	Vout     int
+	Output   TXOutput
+	Height   int
+	Time     int64
+	Coinbase bool
+}
+
+// BlockUndo - the outputs a block spent, in the order it spent them
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+// Serialize - used to store in BoltDB, in the encoding of TXOutputs
+//
+//	undo  = version:u8 | count:u32 | spent*
+//	spent = txid:bytes | vout:u32 | output | height:u64 | time:u64 | coinbase:u8
+func (u *BlockUndo) Serialize() []byte {
+	var buff bytes.Buffer
+
+	buff.WriteByte(undoVersion)
+	writeUint32(&buff, uint32(len(u.Spent)))
+	for _, spent := range u.Spent {
+		writeBytes(&buff, spent.Txid)
+		writeUint32(&buff, uint32(spent.Vout))
+		spent.Output.serialize(&buff)
+		writeUint64(&buff, uint64(spent.Height))
+		writeUint64(&buff, uint64(spent.Time))
+		if spent.Coinbase {
+			buff.WriteByte(1)
+		} else {
+			buff.WriteByte(0)
+		}
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeBlockUndo - returns an undo record previously serialized
+func DeserializeBlockUndo(data []byte) (BlockUndo, error) {
+	var undo BlockUndo
+	br := newByteReader(data)
+
+	version := br.uint8()
+	if br.err == nil && version != undoVersion {
+		return undo, fmt.Errorf("undo record has unknown version %d", version)
+	}
+
+	count := br.uint32()
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		undo.Spent = append(undo.Spent, SpentOutput{
+			Txid:     br.bytes(),
+			Vout:     int(br.uint32()),
+			Output:   readTXOutput(br),
+			Height:   int(br.uint64()),
+			Time:     int64(br.uint64()),
+			Coinbase: br.uint8() == 1,
+		})
+	}
+
+	return undo, br.done()
+}
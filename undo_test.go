@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestDisconnectRestoresUTXOSet(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+
+	bc := testChain(t, wallet)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := NewCoinbaseTX(address, "", 1, 0)
+	split := spendTx(t, wallet, genesis.Transactions[0], 0, address, 4, 6)
+	parent, err := bc.MineBlock([]*Transaction{coinbase, split})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := utxoSet(t, bc)
+
+	// join spends both outputs of split, and chained spends an output of
+	// join in the same block, so disconnecting has to undo the spends in
+	// reverse to restore split
+	join := &Transaction{
+		Version: txVersion,
+		Vin: []TXInput{
+			{Txid: split.ID, Vout: 0, Sequence: MaxTxInSequenceNum},
+			{Txid: split.ID, Vout: 1, Sequence: MaxTxInSequenceNum},
+		},
+		Vout: []TXOutput{*NewTXOutput(3, address), *NewTXOutput(7, address)},
+	}
+	signTx(t, wallet, join, split.Vout[0])
+	err = join.SignInput(wallet.PrivateKey, 1, split.Vout[1], SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chained := spendTx(t, wallet, join, 0, other, 3)
+	matured := spendTx(t, wallet, coinbase, 0, other, coinbase.Vout[0].Value)
+
+	txs := []*Transaction{NewCoinbaseTX(address, "", 2, 0), join, chained, matured}
+	block := NewBlock(txs, parent.Hash, parent.Height+1, bc.GetNextWorkRequired(parent))
+
+	UTXOSet := UTXOSet{Blockchain: bc}
+	UTXOSet.Update(block)
+
+	connected := utxoSet(t, bc)
+	for _, tx := range []*Transaction{coinbase, split} {
+		if _, ok := connected[hex.EncodeToString(tx.ID)]; ok {
+			t.Fatalf("outputs of transaction %x left after they were spent", tx.ID)
+		}
+	}
+	outs := DeSerializeOutputs(mustHex(t, connected[hex.EncodeToString(join.ID)]))
+	if _, ok := outs.Outputs[0]; ok || len(outs.Outputs) != 1 {
+		t.Fatalf("join has outputs %v, want only output 1", outs.Outputs)
+	}
+
+	UTXOSet.Disconnect(block)
+
+	restored := utxoSet(t, bc)
+	if !reflect.DeepEqual(restored, before) {
+		t.Fatalf("UTXO set after disconnect\n%v\nwant\n%v", restored, before)
+	}
+
+	tests := []struct {
+		tx       *Transaction
+		coinbase bool
+	}{
+		{coinbase, true},
+		{split, false},
+	}
+	for _, test := range tests {
+		outs := DeSerializeOutputs(mustHex(t, restored[hex.EncodeToString(test.tx.ID)]))
+		if outs.Height != parent.Height || outs.Time != genesis.Timestamp || outs.Coinbase != test.coinbase {
+			t.Errorf("restored outputs of %x at height %d, time %d, coinbase %v, want %d, %d, %v",
+				test.tx.ID, outs.Height, outs.Time, outs.Coinbase, parent.Height, genesis.Timestamp, test.coinbase)
+		}
+		if len(outs.Outputs) != len(test.tx.Vout) {
+			t.Errorf("restored %d outputs of %x, want %d", len(outs.Outputs), test.tx.ID, len(test.tx.Vout))
+		}
+	}
+
+	err = bc.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(undoBucket)).Get(block.Hash) != nil {
+			t.Error("undo record of the disconnected block is left")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
 	"log"
 )
 
@@ -23,3 +25,88 @@ func ReverseBytes(data []byte) {
 		data[i], data[j] = data[j], data[i]
 	}
 }
+
+// writeUint32 - appends num to buff in big-endian order
+func writeUint32(buff *bytes.Buffer, num uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], num)
+	buff.Write(b[:])
+}
+
+// writeUint64 - appends num to buff in big-endian order
+func writeUint64(buff *bytes.Buffer, num uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], num)
+	buff.Write(b[:])
+}
+
+// writeBytes - appends data to buff prefixed with its length
+func writeBytes(buff *bytes.Buffer, data []byte) {
+	writeUint32(buff, uint32(len(data)))
+	buff.Write(data)
+}
+
+// byteReader - reads back what the write helpers produced, remembering the
+// first error so callers can check once at the end
+type byteReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newByteReader(data []byte) *byteReader {
+	return &byteReader{r: bytes.NewReader(data)}
+}
+
+func (br *byteReader) uint32() uint32 {
+	var num uint32
+	if br.err == nil {
+		br.err = binary.Read(br.r, binary.BigEndian, &num)
+	}
+
+	return num
+}
+
+func (br *byteReader) uint64() uint64 {
+	var num uint64
+	if br.err == nil {
+		br.err = binary.Read(br.r, binary.BigEndian, &num)
+	}
+
+	return num
+}
+
+func (br *byteReader) uint8() uint8 {
+	var num uint8
+	if br.err == nil {
+		br.err = binary.Read(br.r, binary.BigEndian, &num)
+	}
+
+	return num
+}
+
+// bytes - reads a length-prefixed byte slice, returning nil when it is empty
+func (br *byteReader) bytes() []byte {
+	n := br.uint32()
+	if br.err != nil || n == 0 {
+		return nil
+	}
+
+	if int64(n) > int64(br.r.Len()) {
+		br.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	data := make([]byte, n)
+	_, br.err = io.ReadFull(br.r, data)
+
+	return data
+}
+
+// done - reports the first error, or an error if unread bytes remain
+func (br *byteReader) done() error {
+	if br.err == nil && br.r.Len() != 0 {
+		br.err = errors.New("trailing bytes after value")
+	}
+
+	return br.err
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/boltdb/bolt"
@@ -11,30 +13,36 @@ const (
 	utxoBucket = "chainstate"
 )
 
-// UTXOSet - set of UTXOs
+// UTXOSet - set of UTXOs. Selector and Control pick the outputs spends are
+// funded with; without a Selector outputs are taken in the order of the set
 type UTXOSet struct {
 	Blockchain *Blockchain
+	Selector   CoinSelector
+	Control    *CoinControl
 }
 
-// FindSpendableOutputs - collects unspent outputs to reference in input
-func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-
-	accumulated := 0
+// FindSpendableOutputs - collects unspent outputs locked by lockingScript to
+// reference in input
+func (u *UTXOSet) FindSpendableOutputs(lockingScript []byte, amount int) (int, map[string][]int, error) {
+	var coins []Coin
 	db := u.Blockchain.db
+	spendHeight := u.Blockchain.GetBestHeight() + 1
 
 	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
 			outs := DeSerializeOutputs(v)
 
+			if !outs.IsMature(spendHeight) {
+				continue
+			}
+
 			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated = accumulated + out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+				if bytes.Equal(out.ScriptPubKey, lockingScript) {
+					txID := append([]byte{}, k...)
+					coins = append(coins, Coin{TxID: txID, Vout: outIdx, Value: out.Value})
 				}
 			}
 		}
@@ -44,23 +52,23 @@ func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[
 		log.Panic(err)
 	}
 
-	return accumulated, unspentOutputs
+	return SelectCoins(coins, amount, u.Selector, u.Control)
 }
 
-// FindUTXO - find the UTXO for a public key hash
-func (u *UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
+// FindUTXO - find the UTXO locked by lockingScript
+func (u *UTXOSet) FindUTXO(lockingScript []byte) []TXOutput {
 	var UTXOs []TXOutput
 	db := u.Blockchain.db
 
 	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
 			outs := DeSerializeOutputs(v)
 
 			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
+				if bytes.Equal(out.ScriptPubKey, lockingScript) {
 					UTXOs = append(UTXOs, out)
 				}
 			}
@@ -74,13 +82,123 @@ func (u *UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return UTXOs
 }
 
+// GetBalance - returns the spendable and the immature balance of the outputs
+// locked by lockingScript
+func (u *UTXOSet) GetBalance(lockingScript []byte) (int, int) {
+	db := u.Blockchain.db
+	spendHeight := u.Blockchain.GetBestHeight() + 1
+	balance := 0
+	immature := 0
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := DeSerializeOutputs(v)
+
+			for _, out := range outs.Outputs {
+				if !bytes.Equal(out.ScriptPubKey, lockingScript) {
+					continue
+				}
+
+				if outs.IsMature(spendHeight) {
+					balance += out.Value
+				} else {
+					immature += out.Value
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return balance, immature
+}
+
+// CalculateFee - returns the inputs minus the outputs of tx, looking the
+// spent outputs up in the UTXO set. Spends that could not go in the next
+// block, such as those of immature coinbase outputs or those still under a
+// lock time, are reported as errors
+func (u *UTXOSet) CalculateFee(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	db := u.Blockchain.db
+	spendHeight := u.Blockchain.GetBestHeight() + 1
+	totalIn := 0
+
+	err := db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(utxoBucket))
+
+		// the next block builds on the tip, so its time locks are held
+		// against the median time past of the tip
+		spendTime := medianTimePastTx(dbtx, u.Blockchain.tip)
+		if !IsFinalTx(tx, spendHeight, spendTime) {
+			return fmt.Errorf("transaction %x is locked until %d", tx.ID, tx.LockTime)
+		}
+
+		for inID, vin := range tx.Vin {
+			outsBytes := b.Get(vin.Txid)
+			if outsBytes == nil {
+				return fmt.Errorf("transaction %x spends unknown output %x:%d", tx.ID, vin.Txid, vin.Vout)
+			}
+
+			outs := DeSerializeOutputs(outsBytes)
+			out, ok := outs.Outputs[vin.Vout]
+			if !ok {
+				return fmt.Errorf("transaction %x spends spent output %x:%d", tx.ID, vin.Txid, vin.Vout)
+			}
+			if !outs.IsMature(spendHeight) {
+				return fmt.Errorf("transaction %x spends immature coinbase %x", tx.ID, vin.Txid)
+			}
+			err := checkSequenceLock(tx, inID, outs.Height, outs.Time, spendHeight, spendTime)
+			if err != nil {
+				return err
+			}
+			if out.Value < 0 || out.Value > maxMoney {
+				return fmt.Errorf("transaction %x spends output %x:%d of value %d", tx.ID, vin.Txid, vin.Vout, out.Value)
+			}
+			totalIn += out.Value
+			if totalIn > maxMoney {
+				return fmt.Errorf("transaction %x inputs add up to more than %d", tx.ID, maxMoney)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	totalOut := 0
+	for i, out := range tx.Vout {
+		if out.Value < 0 || out.Value > maxMoney {
+			return 0, fmt.Errorf("transaction %x output %d has value %d", tx.ID, i, out.Value)
+		}
+		totalOut += out.Value
+		if totalOut > maxMoney {
+			return 0, fmt.Errorf("transaction %x outputs add up to more than %d", tx.ID, maxMoney)
+		}
+	}
+
+	if totalOut > totalIn {
+		return 0, fmt.Errorf("transaction %x spends more than its inputs", tx.ID)
+	}
+
+	return totalIn - totalOut, nil
+}
+
 // CountTransactions - return the no of transactions in the utxo set
 func (u *UTXOSet) CountTransactions() int {
 	db := u.Blockchain.db
 	count := 0
 
 	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
 		for k, _ := c.First(); k != nil; k, _ = c.Next() {
@@ -99,9 +217,9 @@ func (u *UTXOSet) CountTransactions() int {
 // Reindex - rebuilds the utxo set
 func (u *UTXOSet) Reindex() {
 	db := u.Blockchain.db
-	bucketName := []byte(blocksBucket)
+	bucketName := []byte(utxoBucket)
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.Update(func(tx *bolt.Tx) error {
 		err := tx.DeleteBucket(bucketName)
 		if err != nil && err != bolt.ErrBucketNotFound {
 			log.Panic(err)
@@ -147,50 +265,208 @@ func (u *UTXOSet) Update(block *Block) {
 	db := u.Blockchain.db
 
 	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+		return u.connectBlock(tx, block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
 
-		for _, txn := range block.Transactions {
-			if !txn.IsCoinbase() {
-				for _, vin := range txn.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeSerializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
+// Disconnect - reverses Update for the specified block using its undo record
+// the block is considered to be the tip of the blockchain
+func (u *UTXOSet) Disconnect(block *Block) {
+	db := u.Blockchain.db
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		return u.disconnectBlock(tx, block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// connectBlock - spends the inputs and adds the outputs of block
+// within an open bolt transaction, recording what was spent in the undo bucket.
+// Every spend is checked against the UTXO set as it goes, so a failing block
+// leaves nothing behind once the bolt transaction rolls back
+func (u *UTXOSet) connectBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	undo := BlockUndo{}
+	fees := 0
+	batch := &SchnorrBatch{}
+	spendTime := lockTimeCutoff(tx, block)
+
+	for _, txn := range block.Transactions {
+		if !txn.IsCoinbase() {
+			prevOuts := make(map[string]TXOutput)
+
+			for inID, vin := range txn.Vin {
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					str := fmt.Sprintf("transaction %x spends unknown output %x:%d", txn.ID, vin.Txid, vin.Vout)
+					return ruleError(ErrMissingInputs, str)
+				}
+				outs := DeSerializeOutputs(outsBytes)
+
+				out, ok := outs.Outputs[vin.Vout]
+				if !ok {
+					str := fmt.Sprintf("transaction %x spends spent output %x:%d", txn.ID, vin.Txid, vin.Vout)
+					return ruleError(ErrDoubleSpend, str)
+				}
+				if !outs.IsMature(block.Height) {
+					str := fmt.Sprintf("transaction %x spends coinbase %x from height %d at height %d",
+						txn.ID, vin.Txid, outs.Height, block.Height)
+					return ruleError(ErrImmatureSpend, str)
+				}
+				err := checkSequenceLock(txn, inID, outs.Height, outs.Time, block.Height, spendTime)
+				if err != nil {
+					return err
+				}
+				prevOuts[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = out
+				undo.Spent = append(undo.Spent, SpentOutput{vin.Txid, vin.Vout, out, outs.Height, outs.Time, outs.Coinbase})
+				delete(outs.Outputs, vin.Vout)
+
+				if len(outs.Outputs) == 0 {
+					err := b.Delete(vin.Txid)
+					if err != nil {
+						return err
 					}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
-
-					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
+				} else {
+					err := b.Put(vin.Txid, outs.Serialize())
+					if err != nil {
+						return err
 					}
 				}
 			}
 
-			newOutputs := TXOutputs{}
-			for _, out := range txn.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+			fee, err := checkTransactionInputs(txn, prevOuts, batch)
+			if err != nil {
+				return err
 			}
+			fees += fee
+			if fees > maxMoney {
+				str := fmt.Sprintf("block %x pays more than %d in fees", block.Hash, maxMoney)
+				return ruleError(ErrBadCoinbaseValue, str)
+			}
+		}
 
-			err := b.Put(txn.ID, newOutputs.Serialize())
-			if err != nil {
-				log.Panic(err)
+		// a transaction may not take the ID of one whose outputs are not
+		// all spent, as disconnecting it would take them away too
+		if b.Get(txn.ID) != nil {
+			str := fmt.Sprintf("transaction %x overwrites the unspent outputs of an earlier one", txn.ID)
+			return ruleError(ErrOverwriteTx, str)
+		}
+
+		newOutputs := TXOutputs{
+			Outputs:  make(map[int]TXOutput),
+			Height:   block.Height,
+			Time:     spendTime,
+			Coinbase: txn.IsCoinbase(),
+		}
+		for outIdx, out := range txn.Vout {
+			newOutputs.Outputs[outIdx] = out
+		}
+
+		err := b.Put(txn.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	if !batch.Verify() {
+		str := fmt.Sprintf("block %x has schnorr signatures that are not valid", block.Hash)
+		return ruleError(ErrBadSignature, str)
+	}
+
+	if len(block.Transactions) > 0 {
+		coinbaseValue := 0
+		for i, out := range block.Transactions[0].Vout {
+			if out.Value < 0 || out.Value > maxMoney {
+				str := fmt.Sprintf("coinbase output %d has value %d", i, out.Value)
+				return ruleError(ErrBadCoinbaseValue, str)
+			}
+
+			coinbaseValue += out.Value
+			if coinbaseValue > maxMoney {
+				str := fmt.Sprintf("coinbase pays more than %d", maxMoney)
+				return ruleError(ErrBadCoinbaseValue, str)
 			}
 		}
 
-		return nil
-	})
+		subsidy := activeParams.CalcBlockSubsidy(block.Height)
+		if coinbaseValue > subsidy+fees {
+			str := fmt.Sprintf("coinbase pays %d, more than subsidy %d plus fees %d", coinbaseValue, subsidy, fees)
+			return ruleError(ErrBadCoinbaseValue, str)
+		}
+	}
+
+	ub, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	return ub.Put(block.Hash, undo.Serialize())
+}
+
+// disconnectBlock - reverses connectBlock within an open bolt transaction,
+// restoring the outputs listed in the block's undo record
+func (u *UTXOSet) disconnectBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+
+	ub := tx.Bucket([]byte(undoBucket))
+	if ub == nil {
+		return fmt.Errorf("no undo data for block %x", block.Hash)
+	}
+	undoData := ub.Get(block.Hash)
+	if undoData == nil {
+		return fmt.Errorf("no undo data for block %x", block.Hash)
+	}
+	undo, err := DeserializeBlockUndo(undoData)
+	if err != nil {
+		return fmt.Errorf("undo data for block %x: %v", block.Hash, err)
+	}
+
+	next := len(undo.Spent)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		txn := block.Transactions[i]
+
+		if outsBytes := b.Get(txn.ID); outsBytes != nil && DeSerializeOutputs(outsBytes).Height != block.Height {
+			return fmt.Errorf("outputs of transaction %x were not made by block %x", txn.ID, block.Hash)
+		}
+		err := b.Delete(txn.ID)
+		if err != nil {
+			return err
+		}
+
+		if txn.IsCoinbase() {
+			continue
+		}
+
+		for j := len(txn.Vin) - 1; j >= 0; j-- {
+			next--
+			if next < 0 {
+				return fmt.Errorf("undo data for block %x is incomplete", block.Hash)
+			}
+			spent := undo.Spent[next]
+
+			outs := TXOutputs{
+				Outputs:  make(map[int]TXOutput),
+				Height:   spent.Height,
+				Time:     spent.Time,
+				Coinbase: spent.Coinbase,
+			}
+			if outsBytes := b.Get(spent.Txid); outsBytes != nil {
+				outs = DeSerializeOutputs(outsBytes)
+			}
+			outs.Outputs[spent.Vout] = spent.Output
+
+			err = b.Put(spent.Txid, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return ub.Delete(block.Hash)
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// maxFutureBlockTime - how far ahead of our clock a block may be stamped
+	maxFutureBlockTime = 2 * 60 * 60
+	// medianTimeBlocks - number of previous blocks used for median time past
+	medianTimeBlocks = 11
+)
+
+// ErrorCode - identifies the consensus rule a block or transaction broke
+type ErrorCode int
+
+// consensus rule violations
+const (
+	ErrNoTransactions ErrorCode = iota
+	ErrFirstTxNotCoinbase
+	ErrMultipleCoinbases
+	ErrDuplicateTx
+	ErrBadTxID
+	ErrBadTxVersion
+	ErrNoTxInputs
+	ErrNoTxOutputs
+	ErrBadTxOutValue
+	ErrDuplicateTxInputs
+	ErrBadBlockHash
+	ErrBadMerkleRoot
+	ErrHighHash
+	ErrUnexpectedDifficulty
+	ErrBadHeight
+	ErrTimeTooOld
+	ErrTimeTooNew
+	ErrMissingInputs
+	ErrDoubleSpend
+	ErrOverwriteTx
+	ErrImmatureSpend
+	ErrSpendTooHigh
+	ErrBadSignature
+	ErrBadCoinbaseValue
+	ErrUnfinalizedTx
+	ErrSequenceLockNotMet
+	ErrKnownInvalid
+)
+
+var errorCodeStrings = map[ErrorCode]string{
+	ErrNoTransactions:       "ErrNoTransactions",
+	ErrFirstTxNotCoinbase:   "ErrFirstTxNotCoinbase",
+	ErrMultipleCoinbases:    "ErrMultipleCoinbases",
+	ErrDuplicateTx:          "ErrDuplicateTx",
+	ErrBadTxID:              "ErrBadTxID",
+	ErrBadTxVersion:         "ErrBadTxVersion",
+	ErrNoTxInputs:           "ErrNoTxInputs",
+	ErrNoTxOutputs:          "ErrNoTxOutputs",
+	ErrBadTxOutValue:        "ErrBadTxOutValue",
+	ErrDuplicateTxInputs:    "ErrDuplicateTxInputs",
+	ErrBadBlockHash:         "ErrBadBlockHash",
+	ErrBadMerkleRoot:        "ErrBadMerkleRoot",
+	ErrHighHash:             "ErrHighHash",
+	ErrUnexpectedDifficulty: "ErrUnexpectedDifficulty",
+	ErrBadHeight:            "ErrBadHeight",
+	ErrTimeTooOld:           "ErrTimeTooOld",
+	ErrTimeTooNew:           "ErrTimeTooNew",
+	ErrMissingInputs:        "ErrMissingInputs",
+	ErrDoubleSpend:          "ErrDoubleSpend",
+	ErrOverwriteTx:          "ErrOverwriteTx",
+	ErrImmatureSpend:        "ErrImmatureSpend",
+	ErrSpendTooHigh:         "ErrSpendTooHigh",
+	ErrBadSignature:         "ErrBadSignature",
+	ErrBadCoinbaseValue:     "ErrBadCoinbaseValue",
+	ErrUnfinalizedTx:        "ErrUnfinalizedTx",
+	ErrSequenceLockNotMet:   "ErrSequenceLockNotMet",
+	ErrKnownInvalid:         "ErrKnownInvalid",
+}
+
+// String - returns the name of the error code
+func (e ErrorCode) String() string {
+	if s := errorCodeStrings[e]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// RuleError - returned when a block or transaction breaks a consensus rule
+type RuleError struct {
+	Code        ErrorCode
+	Description string
+}
+
+// Error - satisfies the error interface
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+func ruleError(c ErrorCode, desc string) RuleError {
+	return RuleError{Code: c, Description: desc}
+}
+
+// ValidateBlock - runs the context-free checks on block followed by the checks
+// against its parent. The checks against the UTXO set run when AddBlock
+// connects the block, inside the same bolt transaction that would store it.
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	err := CheckBlockSanity(block)
+	if err != nil {
+		return err
+	}
+
+	parent, err := bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		return errOrphanBlock
+	}
+
+	return bc.checkBlockContext(block, &parent)
+}
+
+// CheckBlockHeaderSanity - checks that need nothing but the header itself,
+// so headers can be verified before their bodies are fetched
+func CheckBlockHeaderSanity(header *BlockHeader) error {
+	target := CompactToBig(header.Bits)
+
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		str := fmt.Sprintf("block target %08x is out of range", header.Bits)
+		return ruleError(ErrUnexpectedDifficulty, str)
+	}
+
+	hash := header.Hash()
+	pow := ProofofWork{target: target}
+	if !pow.checkHash(hash) {
+		str := fmt.Sprintf("block hash %x is higher than its target", hash)
+		return ruleError(ErrHighHash, str)
+	}
+
+	if header.Timestamp > time.Now().Unix()+maxFutureBlockTime {
+		str := fmt.Sprintf("block timestamp %d is too far in the future", header.Timestamp)
+		return ruleError(ErrTimeTooNew, str)
+	}
+
+	return nil
+}
+
+// CheckBlockSanity - checks that need nothing but the block itself
+func CheckBlockSanity(block *Block) error {
+	err := CheckBlockHeaderSanity(&block.BlockHeader)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(block.BlockHeader.Hash(), block.Hash) {
+		str := fmt.Sprintf("block hash %x does not match its header", block.Hash)
+		return ruleError(ErrBadBlockHash, str)
+	}
+
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block does not contain any transactions")
+	}
+
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(ErrFirstTxNotCoinbase, "first transaction in block is not a coinbase")
+	}
+
+	seen := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			str := fmt.Sprintf("block contains second coinbase at index %d", i)
+			return ruleError(ErrMultipleCoinbases, str)
+		}
+
+		err := CheckTransactionSanity(tx)
+		if err != nil {
+			return err
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if seen[txID] {
+			str := fmt.Sprintf("block contains duplicate transaction %s", txID)
+			return ruleError(ErrDuplicateTx, str)
+		}
+		seen[txID] = true
+	}
+
+	if !bytes.Equal(block.HashTransactions(), block.MerkleRoot) {
+		str := fmt.Sprintf("block %x merkle root does not match its transactions", block.Hash)
+		return ruleError(ErrBadMerkleRoot, str)
+	}
+
+	return nil
+}
+
+// CheckTransactionSanity - checks that need nothing but the transaction itself
+func CheckTransactionSanity(tx *Transaction) error {
+	if tx.Version < 1 || tx.Version > txVersion {
+		str := fmt.Sprintf("transaction %x has unknown version %d", tx.ID, tx.Version)
+		return ruleError(ErrBadTxVersion, str)
+	}
+
+	if len(tx.Vin) == 0 {
+		return ruleError(ErrNoTxInputs, fmt.Sprintf("transaction %x has no inputs", tx.ID))
+	}
+
+	if len(tx.Vout) == 0 {
+		return ruleError(ErrNoTxOutputs, fmt.Sprintf("transaction %x has no outputs", tx.ID))
+	}
+
+	totalOut := 0
+	for i, out := range tx.Vout {
+		// once the subsidy runs out a coinbase without fees pays nothing
+		if out.Value < 0 || (out.Value == 0 && !tx.IsCoinbase()) || out.Value > maxMoney {
+			str := fmt.Sprintf("transaction %x output %d has value %d", tx.ID, i, out.Value)
+			return ruleError(ErrBadTxOutValue, str)
+		}
+
+		// each value is at most maxMoney, so the sum cannot wrap before
+		// it is caught here
+		totalOut += out.Value
+		if totalOut > maxMoney {
+			str := fmt.Sprintf("transaction %x outputs add up to more than %d", tx.ID, maxMoney)
+			return ruleError(ErrBadTxOutValue, str)
+		}
+	}
+
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return ruleError(ErrBadTxID, fmt.Sprintf("transaction %x does not match its contents", tx.ID))
+	}
+
+	spent := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+		if spent[outpoint] {
+			str := fmt.Sprintf("transaction %x spends %s twice", tx.ID, outpoint)
+			return ruleError(ErrDuplicateTxInputs, str)
+		}
+		spent[outpoint] = true
+	}
+
+	return nil
+}
+
+// checkBlockContext - checks the block header against its parent, and the
+// lock times of its transactions against the block
+func (bc *Blockchain) checkBlockContext(block, parent *Block) error {
+	if block.Height != parent.Height+1 {
+		str := fmt.Sprintf("block height %d does not follow parent height %d", block.Height, parent.Height)
+		return ruleError(ErrBadHeight, str)
+	}
+
+	expectedBits := bc.GetNextWorkRequired(parent)
+	if block.Bits != expectedBits {
+		str := fmt.Sprintf("block bits %08x, expected %08x", block.Bits, expectedBits)
+		return ruleError(ErrUnexpectedDifficulty, str)
+	}
+
+	medianTime := bc.medianTimePast(parent)
+	if block.Timestamp < medianTime {
+		str := fmt.Sprintf("block timestamp %d is before median time %d", block.Timestamp, medianTime)
+		return ruleError(ErrTimeTooOld, str)
+	}
+
+	// time locks are held against the median time past of the parent, which
+	// the miner cannot move by choosing the timestamp of the block
+	for _, tx := range block.Transactions {
+		if !IsFinalTx(tx, block.Height, medianTime) {
+			str := fmt.Sprintf("block contains transaction %x locked until %d", tx.ID, tx.LockTime)
+			return ruleError(ErrUnfinalizedTx, str)
+		}
+	}
+
+	return nil
+}
+
+// medianTimePast - the median timestamp of the last few blocks ending at
+// block, which must be stored
+func (bc *Blockchain) medianTimePast(block *Block) int64 {
+	var median int64
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		median = medianTimePastTx(tx, block.Hash)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return median
+}
+
+// medianTimePastTx - medianTimePast of the block with blockHash, read from
+// the headers bucket within an open bolt transaction
+func medianTimePastTx(tx *bolt.Tx, blockHash []byte) int64 {
+	b := tx.Bucket([]byte(headersBucket))
+	var timestamps []int64
+
+	for hash := blockHash; len(hash) > 0 && len(timestamps) < medianTimeBlocks; {
+		data := b.Get(hash)
+		if data == nil {
+			break
+		}
+		header, err := DeserializeBlockHeader(data[:blockHeaderLen])
+		if err != nil {
+			break
+		}
+
+		timestamps = append(timestamps, header.Timestamp)
+		hash = header.PrevBlockHash
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
+}
+
+// lockTimeCutoff - the time the time locks of the transactions of block are
+// held against, and the time its outputs count as created at for the
+// relative locks spending them: the median time past of its parent, as in
+// BIP113 and BIP68. The genesis block has no parent and uses its timestamp
+func lockTimeCutoff(tx *bolt.Tx, block *Block) int64 {
+	if len(block.PrevBlockHash) == 0 {
+		return block.Timestamp
+	}
+
+	return medianTimePastTx(tx, block.PrevBlockHash)
+}
+
+// checkTransactionInputs - checks tx against the outputs it spends, which are
+// keyed by outpoint, and returns the fee it pays. With batch set, the Schnorr
+// signatures are left in it for the caller to verify
+func checkTransactionInputs(tx *Transaction, prevOuts map[string]TXOutput, batch *SchnorrBatch) (int, error) {
+	totalIn := 0
+	for _, vin := range tx.Vin {
+		value := prevOuts[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)].Value
+		if value < 0 || value > maxMoney {
+			str := fmt.Sprintf("transaction %x spends output %x:%d of value %d", tx.ID, vin.Txid, vin.Vout, value)
+			return 0, ruleError(ErrSpendTooHigh, str)
+		}
+
+		totalIn += value
+		if totalIn > maxMoney {
+			str := fmt.Sprintf("transaction %x inputs add up to more than %d", tx.ID, maxMoney)
+			return 0, ruleError(ErrSpendTooHigh, str)
+		}
+	}
+
+	totalOut := 0
+	for i, out := range tx.Vout {
+		if out.Value < 0 || out.Value > maxMoney {
+			str := fmt.Sprintf("transaction %x output %d has value %d", tx.ID, i, out.Value)
+			return 0, ruleError(ErrBadTxOutValue, str)
+		}
+
+		totalOut += out.Value
+		if totalOut > maxMoney {
+			str := fmt.Sprintf("transaction %x outputs add up to more than %d", tx.ID, maxMoney)
+			return 0, ruleError(ErrBadTxOutValue, str)
+		}
+	}
+
+	if totalOut > totalIn {
+		str := fmt.Sprintf("transaction %x spends %d but only has %d in inputs", tx.ID, totalOut, totalIn)
+		return 0, ruleError(ErrSpendTooHigh, str)
+	}
+
+	for i, vin := range tx.Vin {
+		prevOut := prevOuts[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)]
+
+		err := verifyScript(tx, i, prevOut, batch)
+		if err != nil {
+			str := fmt.Sprintf("transaction %x input %d failed its script: %v", tx.ID, i, err)
+			return 0, ruleError(ErrBadSignature, str)
+		}
+	}
+
+	return totalIn - totalOut, nil
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// TestMain - mines the blocks of the tests at the easiest difficulty
+func TestMain(m *testing.M) {
+	initialBits = BigToCompact(powLimit)
+
+	os.Exit(m.Run())
+}
+
+// testChain - a new blockchain whose genesis coinbase pays wallet, removed
+// when the test ends. Coinbases mature after a single block
+func testChain(t *testing.T, wallet *Wallet) *Blockchain {
+	t.Helper()
+
+	nodeID := "validatetest"
+	os.Remove(fmt.Sprintf(dbFile, nodeID))
+
+	maturity := activeParams.CoinbaseMaturity
+	activeParams.CoinbaseMaturity = 1
+
+	bc := CreateBlockchain(string(wallet.GetAddress()), nodeID)
+	t.Cleanup(func() {
+		bc.db.Close()
+		os.Remove(fmt.Sprintf(dbFile, nodeID))
+		activeParams.CoinbaseMaturity = maturity
+	})
+
+	return bc
+}
+
+// solveBlock - sets the nonce and hash of block to meet its bits
+func solveBlock(block *Block) *Block {
+	nonce, hash := NewProofOfWork(block).Run()
+	block.Nonce = nonce
+	block.Hash = hash
+
+	return block
+}
+
+// spendTx - a transaction spending output vout of prev, signed by wallet,
+// paying the values to address
+func spendTx(t *testing.T, wallet *Wallet, prev *Transaction, vout int, address string, values ...int) *Transaction {
+	t.Helper()
+
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: prev.ID, Vout: vout, Sequence: MaxTxInSequenceNum}},
+	}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, *NewTXOutput(value, address))
+	}
+
+	signTx(t, wallet, tx, prev.Vout[vout])
+
+	return tx
+}
+
+// signTx - sets the ID of tx and signs its first input, which spends prevOut
+func signTx(t *testing.T, wallet *Wallet, tx *Transaction, prevOut TXOutput) {
+	t.Helper()
+
+	tx.SetID()
+	err := tx.SignInput(wallet.PrivateKey, 0, prevOut, SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// noRuleError - the code of the test cases that break no rule
+const noRuleError ErrorCode = -1
+
+// checkRuleError - fails the test unless err is a RuleError with code, or
+// nil for noRuleError
+func checkRuleError(t *testing.T, err error, code ErrorCode) {
+	t.Helper()
+
+	if code == noRuleError {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+
+	ruleErr, ok := err.(RuleError)
+	if !ok {
+		t.Fatalf("error %v, want %v", err, code)
+	}
+	if ruleErr.Code != code {
+		t.Fatalf("error %v (%v), want %v", ruleErr.Code, ruleErr, code)
+	}
+}
+
+func TestErrorCodeString(t *testing.T) {
+	for code := ErrNoTransactions; code <= ErrKnownInvalid; code++ {
+		if errorCodeStrings[code] == "" {
+			t.Errorf("error code %d has no name", int(code))
+		}
+	}
+
+	if s := ErrorCode(1000).String(); s != "Unknown ErrorCode (1000)" {
+		t.Fatalf("unknown code is %q", s)
+	}
+}
+
+func TestCheckTransactionSanity(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+
+	// txWith - a transaction spending two outputs, paying the values and
+	// changed by change before its ID is set
+	txWith := func(change func(tx *Transaction), values ...int) *Transaction {
+		tx := &Transaction{
+			Version: txVersion,
+			Vin: []TXInput{
+				{Txid: []byte{1}, Vout: 0, Sequence: MaxTxInSequenceNum},
+				{Txid: []byte{1}, Vout: 1, Sequence: MaxTxInSequenceNum},
+			},
+		}
+		for _, value := range values {
+			tx.Vout = append(tx.Vout, *NewTXOutput(value, address))
+		}
+		if change != nil {
+			change(tx)
+		}
+		tx.SetID()
+
+		return tx
+	}
+
+	coinbase := NewCoinbaseTX(address, "", 1, 0)
+	emptyCoinbase := NewCoinbaseTX(address, "", 1, 0)
+	emptyCoinbase.Vout[0].Value = 0
+	emptyCoinbase.SetID()
+	badID := txWith(nil, 5)
+	badID.Vout[0].Value = 4
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		code ErrorCode
+	}{
+		{"ok", txWith(nil, 5, 6), noRuleError},
+		{"coinbase", coinbase, noRuleError},
+		{"coinbase paying nothing", emptyCoinbase, noRuleError},
+		{"all of the money", txWith(nil, maxMoney), noRuleError},
+		{"version 0", txWith(func(tx *Transaction) { tx.Version = 0 }, 5), ErrBadTxVersion},
+		{"future version", txWith(func(tx *Transaction) { tx.Version = txVersion + 1 }, 5), ErrBadTxVersion},
+		{"no inputs", txWith(func(tx *Transaction) { tx.Vin = nil }, 5), ErrNoTxInputs},
+		{"no outputs", txWith(nil), ErrNoTxOutputs},
+		{"negative output", txWith(nil, 5, -1), ErrBadTxOutValue},
+		{"zero output", txWith(nil, 5, 0), ErrBadTxOutValue},
+		{"output above max money", txWith(nil, maxMoney+1), ErrBadTxOutValue},
+		{"max int output", txWith(nil, math.MaxInt64), ErrBadTxOutValue},
+		{"min int output", txWith(nil, math.MinInt64), ErrBadTxOutValue},
+		{"outputs above max money", txWith(nil, maxMoney, 1), ErrBadTxOutValue},
+		{"outputs wrapping around", txWith(nil, math.MaxInt64, math.MaxInt64, 2), ErrBadTxOutValue},
+		{"bad id", badID, ErrBadTxID},
+		{"duplicate inputs", txWith(func(tx *Transaction) { tx.Vin[1].Vout = 0 }, 5), ErrDuplicateTxInputs},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkRuleError(t, CheckTransactionSanity(test.tx), test.code)
+		})
+	}
+}
+
+func TestCheckTransactionInputs(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	script := AddressScript(address)
+
+	// spend - a transaction signed by wallet spending outputs of the values
+	// and paying out, with the outputs it spends
+	spend := func(inValues []int, out ...int) (*Transaction, map[string]TXOutput) {
+		tx := &Transaction{Version: txVersion}
+		prevOuts := make(map[string]TXOutput)
+
+		for i, value := range inValues {
+			tx.Vin = append(tx.Vin, TXInput{Txid: []byte{1}, Vout: i, Sequence: MaxTxInSequenceNum})
+			prevOuts[fmt.Sprintf("%x:%d", []byte{1}, i)] = TXOutput{Value: value, ScriptPubKey: script}
+		}
+		for _, value := range out {
+			tx.Vout = append(tx.Vout, TXOutput{Value: value, ScriptPubKey: script})
+		}
+		tx.SetID()
+
+		for i := range tx.Vin {
+			err := tx.SignInput(wallet.PrivateKey, i, prevOuts[fmt.Sprintf("%x:%d", []byte{1}, i)], SigHashAll)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return tx, prevOuts
+	}
+
+	tests := []struct {
+		name string
+		in   []int
+		out  []int
+		fee  int
+		code ErrorCode
+	}{
+		{"ok", []int{5, 6}, []int{8}, 3, noRuleError},
+		{"no fee", []int{5}, []int{2, 3}, 0, noRuleError},
+		{"all of the money", []int{maxMoney / 2, maxMoney - maxMoney/2}, []int{maxMoney}, 0, noRuleError},
+		{"outputs above inputs", []int{5}, []int{6}, 0, ErrSpendTooHigh},
+		{"negative input", []int{-1, 10}, []int{8}, 0, ErrSpendTooHigh},
+		{"input above max money", []int{maxMoney + 1}, []int{1}, 0, ErrSpendTooHigh},
+		{"max int input", []int{math.MaxInt64}, []int{1}, 0, ErrSpendTooHigh},
+		{"inputs above max money", []int{maxMoney, 1}, []int{1}, 0, ErrSpendTooHigh},
+		{"inputs wrapping around", []int{math.MaxInt64, math.MaxInt64, 2}, []int{1}, 0, ErrSpendTooHigh},
+		{"negative output", []int{5}, []int{-1, 6}, 0, ErrBadTxOutValue},
+		{"max int output", []int{5}, []int{math.MaxInt64}, 0, ErrBadTxOutValue},
+		{"outputs wrapping around", []int{5}, []int{math.MaxInt64, math.MaxInt64, 2}, 0, ErrBadTxOutValue},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, prevOuts := spend(test.in, test.out...)
+
+			fee, err := checkTransactionInputs(tx, prevOuts, nil)
+			checkRuleError(t, err, test.code)
+			if fee != test.fee {
+				t.Fatalf("fee %d, want %d", fee, test.fee)
+			}
+		})
+	}
+
+	t.Run("bad signature", func(t *testing.T) {
+		tx, prevOuts := spend([]int{5}, 4)
+		tx.Vout[0].Value = 3
+		tx.SetID()
+
+		_, err := checkTransactionInputs(tx, prevOuts, nil)
+		checkRuleError(t, err, ErrBadSignature)
+	})
+}
+
+func TestCheckBlockSanity(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	coinbase := NewCoinbaseTX(address, "", 1, 0)
+	other := NewCoinbaseTX(address, "", 1, 0)
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: []byte{1}, Vout: 0, Sequence: MaxTxInSequenceNum}},
+		Vout:    []TXOutput{*NewTXOutput(5, address)},
+	}
+	tx.SetID()
+	badTx := *tx
+	badTx.Version = 0
+	badTx.SetID()
+
+	many := []*Transaction{coinbase}
+	for i := 0; i < 16; i++ {
+		spend := *tx
+		spend.Vin = []TXInput{{Txid: []byte{1}, Vout: i + 1, Sequence: MaxTxInSequenceNum}}
+		spend.SetID()
+		many = append(many, &spend)
+	}
+
+	// blockWith - a block of the txs, changed by change before it is solved
+	blockWith := func(change func(block *Block), txs ...*Transaction) func() *Block {
+		return func() *Block {
+			block := &Block{
+				BlockHeader: BlockHeader{
+					Version:       blockVersion,
+					PrevBlockHash: make([]byte, hashLen),
+					Timestamp:     time.Now().Unix(),
+					Bits:          BigToCompact(powLimit),
+				},
+				Transactions: txs,
+				Height:       1,
+			}
+			if len(txs) > 0 {
+				block.MerkleRoot = block.HashTransactions()
+			} else {
+				block.MerkleRoot = make([]byte, hashLen)
+			}
+			if change != nil {
+				change(block)
+			}
+
+			return solveBlock(block)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		block func() *Block
+		code  ErrorCode
+	}{
+		{"ok", blockWith(nil, coinbase, tx), noRuleError},
+		{"coinbase only", blockWith(nil, coinbase), noRuleError},
+		{"many transactions", blockWith(nil, many...), noRuleError},
+		{"many transactions out of order", blockWith(func(block *Block) {
+			block.Transactions = append([]*Transaction{}, many...)
+			block.Transactions[1], block.Transactions[2] = block.Transactions[2], block.Transactions[1]
+		}, many...), ErrBadMerkleRoot},
+		{"target above the limit", func() *Block {
+			block := blockWith(nil, coinbase)()
+			block.Bits = BigToCompact(new(big.Int).Lsh(powLimit, 1))
+			return block
+		}, ErrUnexpectedDifficulty},
+		{"zero target", func() *Block {
+			block := blockWith(nil, coinbase)()
+			block.Bits = 0
+			return block
+		}, ErrUnexpectedDifficulty},
+		{"hash above the target", func() *Block {
+			block := blockWith(nil, coinbase)()
+			block.Bits = BigToCompact(big.NewInt(1))
+			return block
+		}, ErrHighHash},
+		{"too far in the future", blockWith(func(block *Block) {
+			block.Timestamp = time.Now().Unix() + maxFutureBlockTime + 60
+		}, coinbase), ErrTimeTooNew},
+		{"bad hash", func() *Block {
+			block := blockWith(nil, coinbase)()
+			block.Hash = make([]byte, hashLen)
+			return block
+		}, ErrBadBlockHash},
+		{"no transactions", blockWith(nil), ErrNoTransactions},
+		{"no coinbase", blockWith(nil, tx), ErrFirstTxNotCoinbase},
+		{"two coinbases", blockWith(nil, coinbase, other), ErrMultipleCoinbases},
+		{"bad transaction", blockWith(nil, coinbase, &badTx), ErrBadTxVersion},
+		{"duplicate transaction", blockWith(nil, coinbase, tx, tx), ErrDuplicateTx},
+		{"bad merkle root", blockWith(func(block *Block) {
+			block.MerkleRoot = make([]byte, hashLen)
+		}, coinbase, tx), ErrBadMerkleRoot},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkRuleError(t, CheckBlockSanity(test.block()), test.code)
+		})
+	}
+}
+
+func TestAddBlockRuleErrors(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	otherWallet := NewWallet()
+	other := string(otherWallet.GetAddress())
+
+	bc := testChain(t, wallet)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisTx := genesis.Transactions[0]
+
+	// nextBlock - a block of the txs on the tip, changed by change before
+	// it is solved
+	nextBlock := func(change func(block *Block), txs ...*Transaction) *Block {
+		tip, err := bc.GetBlock(bc.tip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block := NewBlock(txs, tip.Hash, tip.Height+1, bc.GetNextWorkRequired(&tip))
+		if change != nil {
+			change(block)
+			block.MerkleRoot = block.HashTransactions()
+			solveBlock(block)
+		}
+
+		return block
+	}
+
+	coinbase := NewCoinbaseTX(address, "", 1, 0)
+
+	overpaid := NewCoinbaseTX(address, "", 1, 1)
+	allMoney := NewCoinbaseTX(address, "", 1, maxMoney-activeParams.CalcBlockSubsidy(1))
+	maxIntCoinbase := NewCoinbaseTX(address, "", 1, 0)
+	maxIntCoinbase.Vout[0].Value = math.MaxInt64
+	maxIntCoinbase.SetID()
+
+	missing := spendTx(t, wallet, genesisTx, 0, other, 5)
+	missing.Vin[0].Txid = make([]byte, hashLen)
+	signTx(t, wallet, missing, genesisTx.Vout[0])
+
+	immature := spendTx(t, wallet, coinbase, 0, other, 5)
+	overspent := spendTx(t, wallet, genesisTx, 0, other, 6, 5)
+
+	badSignature := spendTx(t, wallet, genesisTx, 0, other, 5)
+	badSignature.Vout[0].Value = 4
+	badSignature.SetID()
+
+	unfinalized := spendTx(t, wallet, genesisTx, 0, other, 5)
+	unfinalized.LockTime = 5
+	unfinalized.Vin[0].Sequence = 0
+	signTx(t, wallet, unfinalized, genesisTx.Vout[0])
+
+	sequenceLocked := spendTx(t, wallet, genesisTx, 0, other, 5)
+	sequenceLocked.Vin[0].Sequence = 5
+	signTx(t, wallet, sequenceLocked, genesisTx.Vout[0])
+
+	tests := []struct {
+		name  string
+		block *Block
+		code  ErrorCode
+	}{
+		{"bad height", nextBlock(func(block *Block) { block.Height = 2 }, coinbase), ErrBadHeight},
+		{"unexpected difficulty", nextBlock(func(block *Block) {
+			block.Bits = BigToCompact(new(big.Int).Rsh(powLimit, 1))
+		}, coinbase), ErrUnexpectedDifficulty},
+		{"before median time", nextBlock(func(block *Block) {
+			block.Timestamp = genesis.Timestamp - 1
+		}, coinbase), ErrTimeTooOld},
+		{"unfinalized", nextBlock(nil, coinbase, unfinalized), ErrUnfinalizedTx},
+		{"max int coinbase", nextBlock(nil, maxIntCoinbase), ErrBadTxOutValue},
+		{"coinbase above the subsidy", nextBlock(nil, overpaid), ErrBadCoinbaseValue},
+		{"coinbase of all the money", nextBlock(nil, allMoney), ErrBadCoinbaseValue},
+		{"missing input", nextBlock(nil, coinbase, missing), ErrMissingInputs},
+		{"copy of the genesis coinbase", nextBlock(nil, NewCoinbaseTX(address, genesisCoinbaseData, 0, 0)), ErrOverwriteTx},
+		{"immature coinbase", nextBlock(nil, coinbase, immature), ErrImmatureSpend},
+		{"outputs above inputs", nextBlock(nil, coinbase, overspent), ErrSpendTooHigh},
+		{"bad signature", nextBlock(nil, coinbase, badSignature), ErrBadSignature},
+		{"sequence lock", nextBlock(nil, coinbase, sequenceLocked), ErrSequenceLockNotMet},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkRuleError(t, bc.AddBlock(test.block), test.code)
+			if bc.GetBestHeight() != 0 {
+				t.Fatalf("chain moved to height %d", bc.GetBestHeight())
+			}
+		})
+	}
+
+	// the genesis coinbase is spent into two outputs, so the one left keeps
+	// the transaction in the UTXO set
+	spent := spendTx(t, wallet, genesisTx, 0, other, 5, 5)
+	err = bc.AddBlock(nextBlock(nil, coinbase, spent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doubleSpent := nextBlock(nil, NewCoinbaseTX(address, "", 2, 0),
+		spendTx(t, otherWallet, spent, 0, address, 5), spendTx(t, otherWallet, spent, 0, address, 4))
+	checkRuleError(t, bc.AddBlock(doubleSpent), ErrDoubleSpend)
+
+	t.Run("known invalid", func(t *testing.T) {
+		checkRuleError(t, bc.AddBlock(doubleSpent), ErrKnownInvalid)
+
+		child := NewBlock([]*Transaction{NewCoinbaseTX(address, "", 3, 0)}, doubleSpent.Hash, 3, doubleSpent.Bits)
+		checkRuleError(t, bc.AddBlock(child), ErrKnownInvalid)
+	})
+
+	if bc.GetBestHeight() != 1 {
+		t.Fatalf("chain at height %d, want 1", bc.GetBestHeight())
+	}
+}
+
+func TestVerifyMissingPrevOutput(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	prev := NewCoinbaseTX(address, "", 1, 0)
+	tx := spendTx(t, wallet, prev, 0, address, 1)
+
+	if !tx.Verify(map[string]Transaction{hex.EncodeToString(prev.ID): *prev}) {
+		t.Fatal("signed input failed")
+	}
+
+	if tx.Verify(map[string]Transaction{}) {
+		t.Fatal("input of an unknown transaction verified")
+	}
+
+	tx.Vin[0].Vout = len(prev.Vout)
+	if tx.Verify(map[string]Transaction{hex.EncodeToString(prev.ID): *prev}) {
+		t.Fatal("input of an output out of range verified")
+	}
+}
+
+func TestFindSpendableOutputsSkipsSpent(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+
+	bc := testChain(t, wallet)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second output of split stays unspent after the first is spent
+	split := spendTx(t, wallet, genesis.Transactions[0], 0, address, 4, 6)
+	_, err = bc.MineBlock([]*Transaction{NewCoinbaseTX(other, "", 1, 0), split})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bc.MineBlock([]*Transaction{NewCoinbaseTX(other, "", 2, 0), spendTx(t, wallet, split, 0, other, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total, outputs, err := bc.FindSpendableOutputs(HashPubKey(wallet.PublicKey), 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 6 || len(outputs[hex.EncodeToString(split.ID)]) != 1 || outputs[hex.EncodeToString(split.ID)][0] != 1 {
+		t.Fatalf("found %d in %v, want output 1 of split", total, outputs)
+	}
+}
+
+// utxoSet - the UTXO set of bc, serialized outputs keyed by hex txid
+func utxoSet(t *testing.T, bc *Blockchain) map[string]string {
+	t.Helper()
+
+	set := make(map[string]string)
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			set[hex.EncodeToString(k)] = hex.EncodeToString(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return set
+}
+
+// headerStatus - the status stored with the header of blockHash, failing the
+// test if there is no header
+func headerStatus(t *testing.T, bc *Blockchain, blockHash []byte) byte {
+	t.Helper()
+
+	var data []byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		data = append(data, tx.Bucket([]byte(headersBucket)).Get(blockHash)...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		t.Fatalf("no header for block %x", blockHash)
+	}
+
+	return blockStatus(data)
+}
+
+func TestReorganize(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+
+	bc := testChain(t, wallet)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisTx := genesis.Transactions[0]
+
+	// blockOn - a block on parent of a new coinbase and the txs
+	blockOn := func(parent *Block, txs ...*Transaction) *Block {
+		coinbase := NewCoinbaseTX(address, "", parent.Height+1, 0)
+		txs = append([]*Transaction{coinbase}, txs...)
+
+		return NewBlock(txs, parent.Hash, parent.Height+1, bc.GetNextWorkRequired(parent))
+	}
+
+	// check - fails unless tip is the tip of bc, the UTXO set holds the
+	// outputs of exactly the txs and the headers have the statuses
+	check := func(tip *Block, txs []*Transaction, statuses map[*Block]byte) {
+		t.Helper()
+
+		if !bytes.Equal(bc.tip, tip.Hash) || bc.GetBestHeight() != tip.Height {
+			t.Fatalf("tip is %x at height %d, want %x at %d", bc.tip, bc.GetBestHeight(), tip.Hash, tip.Height)
+		}
+
+		set := utxoSet(t, bc)
+		if len(set) != len(txs) {
+			t.Fatalf("UTXO set has outputs of %d transactions, want %d", len(set), len(txs))
+		}
+		for _, tx := range txs {
+			if _, ok := set[hex.EncodeToString(tx.ID)]; !ok {
+				t.Fatalf("UTXO set has no outputs of transaction %x", tx.ID)
+			}
+		}
+
+		for block, status := range statuses {
+			if got := headerStatus(t, bc, block.Hash); got != status {
+				t.Fatalf("block at height %d has status %d, want %d", block.Height, got, status)
+			}
+		}
+	}
+
+	spendA := spendTx(t, wallet, genesisTx, 0, other, 10)
+	a1 := blockOn(&genesis, spendA)
+	checkRuleError(t, bc.AddBlock(a1), noRuleError)
+	check(a1, []*Transaction{a1.Transactions[0], spendA}, map[*Block]byte{a1: blockStatusValid})
+
+	// b1 has as much work as a1, so it is only stored
+	spendB := spendTx(t, wallet, genesisTx, 0, other, 4, 6)
+	b1 := blockOn(&genesis, spendB)
+	checkRuleError(t, bc.AddBlock(b1), noRuleError)
+	check(a1, []*Transaction{a1.Transactions[0], spendA},
+		map[*Block]byte{a1: blockStatusValid, b1: blockStatusUnvalidated})
+
+	// b2 makes the branch of b1 the heavier, so a1 is disconnected
+	b2 := blockOn(b1)
+	checkRuleError(t, bc.AddBlock(b2), noRuleError)
+	mainUTXO := []*Transaction{b1.Transactions[0], spendB, b2.Transactions[0]}
+	check(b2, mainUTXO, map[*Block]byte{a1: blockStatusValid, b1: blockStatusValid, b2: blockStatusValid})
+	before := utxoSet(t, bc)
+
+	// c2 builds on a1 and spends an output that does not exist, which is
+	// only found once it connects
+	missing := spendTx(t, wallet, genesisTx, 0, other, 10)
+	missing.Vin[0].Txid = make([]byte, hashLen)
+	signTx(t, wallet, missing, genesisTx.Vout[0])
+	c2 := blockOn(a1, missing)
+	checkRuleError(t, bc.AddBlock(c2), noRuleError)
+	check(b2, mainUTXO, map[*Block]byte{b2: blockStatusValid, c2: blockStatusUnvalidated})
+
+	// c3 makes the branch of c2 the heavier. The reorg disconnects b2 and
+	// b1 and connects a1 before c2 fails, and all of it rolls back
+	c3 := blockOn(c2)
+	checkRuleError(t, bc.AddBlock(c3), ErrMissingInputs)
+	check(b2, mainUTXO, map[*Block]byte{
+		a1: blockStatusValid, b1: blockStatusValid, b2: blockStatusValid,
+		c2: blockStatusInvalid, c3: blockStatusInvalid,
+	})
+	if !reflect.DeepEqual(utxoSet(t, bc), before) {
+		t.Fatal("UTXO set changed by the failed reorg")
+	}
+	if bc.HasBlock(c2.Hash) || bc.HasBlock(c3.Hash) {
+		t.Fatal("blocks of the invalid branch are stored")
+	}
+
+	checkRuleError(t, bc.AddBlock(blockOn(c3)), ErrKnownInvalid)
+	check(b2, mainUTXO, nil)
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	other := string(NewWallet().GetAddress())
+
+	bc := testChain(t, wallet)
+	activeParams.CoinbaseMaturity = 100
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisTx := genesis.Transactions[0]
+	UTXOSet := UTXOSet{Blockchain: bc}
+	script := AddressScript(address)
+
+	// the genesis coinbase has 99 confirmations once the tip is at 98
+	for height := 1; height <= 98; height++ {
+		_, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(other, "", height, 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spend := spendTx(t, wallet, genesisTx, 0, other, genesisTx.Vout[0].Value)
+
+	balance, immature := UTXOSet.GetBalance(script)
+	if balance != 0 || immature != genesisTx.Vout[0].Value {
+		t.Fatalf("balance %d, immature %d at 99 confirmations", balance, immature)
+	}
+	total, _, _ := UTXOSet.FindSpendableOutputs(script, 1)
+	if total != 0 {
+		t.Fatalf("found %d to spend at 99 confirmations", total)
+	}
+	if _, err := UTXOSet.CalculateFee(spend); err == nil {
+		t.Fatal("fee of an immature spend calculated")
+	}
+	_, err = bc.MineBlock([]*Transaction{NewCoinbaseTX(other, "", 99, 0), spend})
+	checkRuleError(t, err, ErrImmatureSpend)
+
+	_, err = bc.MineBlock([]*Transaction{NewCoinbaseTX(other, "", 99, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, immature = UTXOSet.GetBalance(script)
+	if balance != genesisTx.Vout[0].Value || immature != 0 {
+		t.Fatalf("balance %d, immature %d at 100 confirmations", balance, immature)
+	}
+	total, _, err = UTXOSet.FindSpendableOutputs(script, 1)
+	if err != nil || total != genesisTx.Vout[0].Value {
+		t.Fatalf("found %d to spend at 100 confirmations: %v", total, err)
+	}
+	if _, err := UTXOSet.CalculateFee(spend); err != nil {
+		t.Fatal(err)
+	}
+	_, err = bc.MineBlock([]*Transaction{NewCoinbaseTX(other, "", 100, 0), spend})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
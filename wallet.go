@@ -4,23 +4,45 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"log"
+	"math/big"
 
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
 	addressChecksumLen = 4
 	version            = byte(0x00)
-	walletFile         = "wallet_%s.dat"
+	// multisigVersion - version byte of addresses paying to a script hash,
+	// the multisig addresses and the contracts
+	multisigVersion = byte(0x05)
+	// schnorrVersion - version byte of addresses paying to an x-only key
+	schnorrVersion = byte(0x3f)
+	walletFile     = "wallet_%s.dat"
 )
 
+// curveIDs - the ids marking the curve of each key in the wallet file
+var curveIDs = map[elliptic.Curve]byte{
+	elliptic.P256():  0x01,
+	secp256k1.S256(): 0x02,
+}
+
+var curvesByID = map[byte]elliptic.Curve{
+	0x01: elliptic.P256(),
+	0x02: secp256k1.S256(),
+}
+
 // Wallet - represents a wallet
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	// Schnorr - the wallet is paid to its x-only key and signs with Schnorr
+	// signatures, instead of being paid to the hash of its key
+	Schnorr bool
 }
 
 // NewWallet - get a new Wallet
@@ -33,22 +55,167 @@ func NewWallet() *Wallet {
 	return &wallet
 }
 
+// NewSchnorrWallet - get a new Wallet paid to its x-only key
+func NewSchnorrWallet() *Wallet {
+	wallet := NewWallet()
+	wallet.Schnorr = true
+	return wallet
+}
+
 func newKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
-	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+	private, err := secp256k1.GeneratePrivateKey()
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	privKey := private.ToECDSA()
+	pubKey := pubKeyBytes(&privKey.PublicKey)
+
+	return *privKey, pubKey
+}
+
+// publicOnlyID - marks a wallet stored without its private key
+const publicOnlyID = byte(0x00)
+
+// GobEncode - a wallet is stored as the id of its curve, its private key and
+// whether it is a Schnorr wallet, everything else is derived from it on load.
+// Without its private key it is stored as publicOnlyID, its public key and
+// the Schnorr flag
+func (w Wallet) GobEncode() ([]byte, error) {
+	if w.PrivateKey.D == nil {
+		data := append([]byte{publicOnlyID}, w.PublicKey...)
+		if w.Schnorr {
+			return append(data, 1), nil
+		}
+		return append(data, 0), nil
+	}
+
+	curveID, ok := curveIDs[w.PrivateKey.Curve]
+	if !ok {
+		return nil, errors.New("wallet key is on an unknown curve")
+	}
+
+	if w.Schnorr && w.PrivateKey.Curve != secp256k1.S256() {
+		return nil, errors.New("schnorr wallets must be on secp256k1")
+	}
+
+	data := make([]byte, 2+curveSize(w.PrivateKey.Curve))
+	data[0] = curveID
+	w.PrivateKey.D.FillBytes(data[1 : len(data)-1])
+	if w.Schnorr {
+		data[len(data)-1] = 1
+	}
+
+	return data, nil
+}
+
+// GobDecode - restores a wallet stored by GobEncode. Wallets from before
+// secp256k1 hold a bare P-256 key
+func (w *Wallet) GobDecode(data []byte) error {
+	if len(data) > 34 && data[0] == publicOnlyID {
+		pub, err := parsePubKey(data[1 : len(data)-1])
+		if err != nil {
+			return err
+		}
+
+		w.PrivateKey = ecdsa.PrivateKey{PublicKey: *pub}
+		w.PublicKey = data[1 : len(data)-1]
+		w.Schnorr = data[len(data)-1] == 1
+		return nil
+	}
+
+	curve := elliptic.P256()
+	if len(data) == 34 {
+		w.Schnorr = data[33] == 1
+		data = data[:33]
+	}
+	if len(data) == 33 {
+		var ok bool
+		curve, ok = curvesByID[data[0]]
+		if !ok {
+			return fmt.Errorf("wallet key is on unknown curve %d", data[0])
+		}
+		data = data[1:]
+	}
+
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(data)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(data)
+
+	w.PrivateKey = private
+	w.PublicKey = pubKeyBytes(&private.PublicKey)
+
+	return nil
+}
+
+// publicOnly - a copy of the wallet without its private key
+func (w *Wallet) publicOnly() *Wallet {
+	return &Wallet{
+		PrivateKey: ecdsa.PrivateKey{PublicKey: w.PrivateKey.PublicKey},
+		PublicKey:  w.PublicKey,
+		Schnorr:    w.Schnorr,
+	}
+}
+
+// pubKeyBytes - the serialized form of a public key. secp256k1 keys are
+// compressed to 33 bytes, the parity of Y followed by X. P-256 keys keep the
+// X followed by Y they had before, so their addresses stay the same
+func pubKeyBytes(pub *ecdsa.PublicKey) []byte {
+	size := curveSize(pub.Curve)
+
+	if pub.Curve == secp256k1.S256() {
+		pubKey := make([]byte, 1+size)
+		pubKey[0] = secp256k1.PubKeyFormatCompressedEven | byte(pub.Y.Bit(0))
+		pub.X.FillBytes(pubKey[1:])
+		return pubKey
+	}
+
+	pubKey := make([]byte, 2*size)
+	pub.X.FillBytes(pubKey[:size])
+	pub.Y.FillBytes(pubKey[size:])
 
-	return *private, pubKey
+	return pubKey
+}
+
+// parsePubKey - parses a public key serialized by pubKeyBytes, checking that
+// it is on its curve. Its length tells the curve apart
+func parsePubKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	switch len(pubKey) {
+	case secp256k1.PubKeyBytesLenCompressed:
+		key, err := secp256k1.ParsePubKey(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		return key.ToECDSA(), nil
+	case 64:
+		curve := elliptic.P256()
+		x := new(big.Int).SetBytes(pubKey[:32])
+		y := new(big.Int).SetBytes(pubKey[32:])
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("public key is not on the P-256 curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("public key of %d bytes has no known encoding", len(pubKey))
+	}
 }
 
 // GetAddress - fetch address from a wallet
 func (w *Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	if w.Schnorr {
+		return encodeAddress(schnorrVersion, schnorrPubKey(&w.PrivateKey.PublicKey))
+	}
+
+	return encodeAddress(version, HashPubKey(w.PublicKey))
+}
+
+// ScriptAddress - the address paying to the hash of redeemScript, such as a
+// multisig script or a contract
+func ScriptAddress(redeemScript []byte) []byte {
+	return encodeAddress(multisigVersion, HashPubKey(redeemScript))
+}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
 	checksum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
@@ -60,16 +227,63 @@ func (w *Wallet) GetAddress() []byte {
 // ValidateAddress - used to validate address
 func ValidateAddress(address string) bool {
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) < 1+addressChecksumLen {
+		return false
+	}
 
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-
+	addressVersion := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+
+	// the payload must fill the script it is paid with
+	switch addressVersion {
+	case version, multisigVersion:
+		if len(pubKeyHash) != ripemd160.Size {
+			return false
+		}
+	case schnorrVersion:
+		if len(pubKeyHash) != 32 {
+			return false
+		}
+	default:
+		return false
+	}
+
+	targetChecksum := checksum(append([]byte{addressVersion}, pubKeyHash...))
 
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 }
 
+// AddressScript - the locking script paying to address
+func AddressScript(address string) []byte {
+	payload := Base58Decode([]byte(address))
+	hash := payload[1 : len(payload)-addressChecksumLen]
+
+	switch payload[0] {
+	case multisigVersion:
+		return PayToScriptHashScript(hash)
+	case schnorrVersion:
+		return PayToSchnorrPubKeyScript(hash)
+	}
+
+	return PayToPubKeyHashScript(hash)
+}
+
+// ExtractAddress - the address a locking script pays to, the reverse of
+// AddressScript, or "" for scripts no address stands for
+func ExtractAddress(script []byte) string {
+	switch GetScriptClass(script) {
+	case PubKeyHashTy:
+		return fmt.Sprintf("%s", encodeAddress(version, ExtractPubKeyHash(script)))
+	case ScriptHashTy:
+		return fmt.Sprintf("%s", encodeAddress(multisigVersion, ExtractScriptHash(script)))
+	case SchnorrPubKeyTy:
+		return fmt.Sprintf("%s", encodeAddress(schnorrVersion, ExtractSchnorrPubKey(script)))
+	}
+
+	return ""
+}
+
 // HashPubKey - hash the public key
 func HashPubKey(pubKey []byte) []byte {
 	publicSHA256 := sha256.Sum256(pubKey)
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func TestPubKeyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		curve  elliptic.Curve
+		key    string
+		pubKey string
+	}{
+		// the generator, compressed as in Bitcoin
+		{"secp256k1 key 1", secp256k1.S256(), "1",
+			"0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"secp256k1 key 3", secp256k1.S256(), "3",
+			"02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"},
+		{"secp256k1 odd y", secp256k1.S256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721", ""},
+		{"p256 key 1", elliptic.P256(), "1",
+			"6b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296" +
+				"4fe342e2fe1a7f9b8ee7eb4a7c0f9e162bce33576b315ececbb6406837bf51f5"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			privKey := testPrivKey(t, test.curve, test.key)
+
+			pubKey := pubKeyBytes(&privKey.PublicKey)
+			if test.pubKey != "" && hex.EncodeToString(pubKey) != test.pubKey {
+				t.Fatalf("public key encodes as %x, want %s", pubKey, test.pubKey)
+			}
+			if test.curve == secp256k1.S256() && (len(pubKey) != 33 || pubKey[0] != 2+byte(privKey.Y.Bit(0))) {
+				t.Fatalf("secp256k1 public key %x is not compressed", pubKey)
+			}
+			if test.curve == elliptic.P256() && len(pubKey) != 64 {
+				t.Fatalf("P-256 public key of %d bytes, want 64", len(pubKey))
+			}
+
+			parsed, err := parsePubKey(pubKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Curve != test.curve || parsed.X.Cmp(privKey.X) != 0 || parsed.Y.Cmp(privKey.Y) != 0 {
+				t.Fatal("public key changed in encoding")
+			}
+		})
+	}
+}
+
+func TestParsePubKeyErrors(t *testing.T) {
+	privKey := testPrivKey(t, secp256k1.S256(), "1")
+	compressed := pubKeyBytes(&privKey.PublicKey)
+	uncompressed := make([]byte, 65)
+	uncompressed[0] = secp256k1.PubKeyFormatUncompressed
+	privKey.X.FillBytes(uncompressed[1:33])
+	privKey.Y.FillBytes(uncompressed[33:])
+
+	p256Key := testPrivKey(t, elliptic.P256(), "1")
+	offCurve := pubKeyBytes(&p256Key.PublicKey)
+	offCurve[63] ^= 1
+
+	tests := []struct {
+		name   string
+		pubKey []byte
+	}{
+		{"empty", nil},
+		{"uncompressed secp256k1", uncompressed},
+		{"hybrid prefix", append([]byte{0x06}, compressed[1:]...)},
+		{"uncompressed prefix", append([]byte{secp256k1.PubKeyFormatUncompressed}, compressed[1:]...)},
+		{"x beyond the field", append([]byte{secp256k1.PubKeyFormatCompressedEven}, bytes.Repeat([]byte{0xff}, 32)...)},
+		{"short secp256k1", compressed[:32]},
+		{"P-256 off its curve", offCurve},
+		{"short P-256", offCurve[:63]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parsePubKey(test.pubKey); err == nil {
+				t.Fatalf("public key %x parsed", test.pubKey)
+			}
+		})
+	}
+}
+
+func TestWalletGobRoundTrip(t *testing.T) {
+	p256Key := testPrivKey(t, elliptic.P256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721")
+	p256Wallet := &Wallet{PrivateKey: p256Key, PublicKey: pubKeyBytes(&p256Key.PublicKey)}
+
+	tests := []struct {
+		name   string
+		wallet *Wallet
+	}{
+		{"secp256k1", NewWallet()},
+		{"schnorr", NewSchnorrWallet()},
+		{"p256", p256Wallet},
+		{"watch-only", NewWallet().publicOnly()},
+		{"watch-only schnorr", NewSchnorrWallet().publicOnly()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := test.wallet.GobEncode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decoded Wallet
+			if err := decoded.GobDecode(data); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded.PublicKey, test.wallet.PublicKey) || decoded.Schnorr != test.wallet.Schnorr ||
+				!bytes.Equal(decoded.GetAddress(), test.wallet.GetAddress()) {
+				t.Fatalf("wallet %s decodes as %s", test.wallet.GetAddress(), decoded.GetAddress())
+			}
+			if (decoded.PrivateKey.D == nil) != (test.wallet.PrivateKey.D == nil) ||
+				decoded.PrivateKey.D != nil && decoded.PrivateKey.D.Cmp(test.wallet.PrivateKey.D) != 0 {
+				t.Fatal("private key changed in encoding")
+			}
+		})
+	}
+
+	// wallets from before secp256k1 hold a bare P-256 key, and keep their
+	// addresses
+	var legacy Wallet
+	if err := legacy.GobDecode(p256Key.D.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.PrivateKey.Curve != elliptic.P256() || !bytes.Equal(legacy.GetAddress(), p256Wallet.GetAddress()) {
+		t.Fatalf("legacy wallet decodes as %s, want %s", legacy.GetAddress(), p256Wallet.GetAddress())
+	}
+
+	var unknown Wallet
+	if err := unknown.GobDecode(append([]byte{0x09}, p256Key.D.Bytes()...)); err == nil {
+		t.Fatal("wallet on an unknown curve decoded")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	walletKeyLen = 32
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrWalletLocked - returned for anything needing the private keys of an
+// encrypted wallet that has not been unlocked
+var ErrWalletLocked = errors.New("wallet is locked, unlock it with walletpassphrase")
+
+// WalletCrypt - how the key sealing an encrypted wallet is derived from its
+// passphrase
+type WalletCrypt struct {
+	Salt []byte
+	N    int
+	R    int
+	P    int
+}
+
+// walletSecrets - the part of the wallets sealed by encryption: the wallets
+// with their private keys, the seed, the secret musig nonces and the
+// contract secrets
+type walletSecrets struct {
+	Wallets     map[string]*Wallet
+	Seed        []byte
+	MuSigNonces map[string][]byte
+	Secrets     map[string][]byte
+}
+
+// deriveKey - the AES-256 key of passphrase
+func (c *WalletCrypt) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), c.Salt, c.N, c.R, c.P, walletKeyLen)
+}
+
+// IsEncrypted - whether the private keys are stored sealed
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.Crypt != nil
+}
+
+// IsLocked - whether the wallets are encrypted and their private keys are
+// not at hand
+func (ws *Wallets) IsLocked() bool {
+	return ws.Crypt != nil && ws.key == nil
+}
+
+// Encrypt - seals the private keys and the seed under a key derived from
+// passphrase from the next save on. The wallets stay unlocked until then
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.IsEncrypted() {
+		return errors.New("wallet is already encrypted")
+	}
+
+	crypt := WalletCrypt{Salt: make([]byte, 16), N: scryptN, R: scryptR, P: scryptP}
+	_, err := rand.Read(crypt.Salt)
+	if err != nil {
+		return err
+	}
+
+	key, err := crypt.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	ws.Crypt = &crypt
+	ws.key = key
+	return nil
+}
+
+// Unlock - opens the sealed private keys and the seed with key
+func (ws *Wallets) Unlock(key []byte) error {
+	if !ws.IsEncrypted() {
+		return errors.New("wallet is not encrypted")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	if len(ws.Sealed) < gcm.NonceSize() {
+		return errors.New("sealed wallet is truncated")
+	}
+	nonce, sealed := ws.Sealed[:gcm.NonceSize()], ws.Sealed[gcm.NonceSize():]
+
+	content, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return errors.New("wrong passphrase")
+	}
+
+	var secrets walletSecrets
+	err = gob.NewDecoder(bytes.NewReader(content)).Decode(&secrets)
+	if err != nil {
+		return err
+	}
+
+	for address, wallet := range secrets.Wallets {
+		ws.Wallets[address] = wallet
+	}
+	ws.Seed = secrets.Seed
+	if secrets.MuSigNonces != nil {
+		ws.MuSigNonces = secrets.MuSigNonces
+	}
+	// contract secrets stored in the clear by earlier versions are kept, to
+	// be sealed on the next save
+	for secretHash, secret := range secrets.Secrets {
+		ws.Secrets[secretHash] = secret
+	}
+	ws.key = key
+
+	return nil
+}
+
+// UnlockWithPassphrase - unlocks the wallets with the key derived from
+// passphrase. The key is only ever held in memory, until Lock
+func (ws *Wallets) UnlockWithPassphrase(passphrase string) error {
+	if !ws.IsEncrypted() {
+		return errors.New("wallet is not encrypted")
+	}
+
+	key, err := ws.Crypt.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	err = ws.Unlock(key)
+	if err != nil {
+		zeroBytes(key)
+	}
+
+	return err
+}
+
+// Lock - zeroes the key of unlocked wallets so it does not outlive the
+// command that needed it
+func (ws *Wallets) Lock() {
+	zeroBytes(ws.key)
+	ws.key = nil
+}
+
+// zeroBytes - overwrites b with zeros
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// sealed - seals the private keys and the seed again, returning the wallets
+// as they are stored: with only the public keys left in the clear
+func (ws *Wallets) sealed() (*Wallets, error) {
+	var content bytes.Buffer
+	err := gob.NewEncoder(&content).Encode(walletSecrets{Wallets: ws.Wallets, Seed: ws.Seed, MuSigNonces: ws.MuSigNonces, Secrets: ws.Secrets})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(ws.key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	ws.Sealed = gcm.Seal(nonce, nonce, content.Bytes(), nil)
+
+	stored := *ws
+	stored.Wallets = make(map[string]*Wallet)
+	for address, wallet := range ws.Wallets {
+		stored.Wallets[address] = wallet.publicOnly()
+	}
+	stored.Seed = nil
+	stored.MuSigNonces = nil
+	stored.Secrets = nil
+
+	return &stored, nil
+}
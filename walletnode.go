@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// walletCookieFile - the random cookie a running node asks of the wallet
+// commands sent to it, readable only by the owner of the node. It is not
+// the key, which never leaves the memory of the node and the command using it
+const walletCookieFile = "wallet_%s.cookie"
+
+const walletCookieLen = 32
+
+// nodeWallet - the key of the wallets of the running node, held in its memory
+// only, from walletpassphrase until the timeout or walletlock
+var nodeWallet struct {
+	sync.Mutex
+	nodeID string
+	cookie []byte
+	key    []byte
+	timer  *time.Timer
+	// unlocks - counts the unlocks and locks, so a timeout fired for an
+	// earlier unlock leaves the key of a later one alone
+	unlocks uint64
+}
+
+type walletunlock struct {
+	Cookie     []byte
+	Passphrase string
+	Timeout    int64
+}
+
+type walletlock struct {
+	Cookie []byte
+}
+
+type walletkey struct {
+	Cookie []byte
+}
+
+type walletreply struct {
+	Key   []byte
+	Error string
+}
+
+// initNodeWallet - makes the cookie of the wallet commands of the node nodeID
+func initNodeWallet(nodeID string) error {
+	cookie := make([]byte, walletCookieLen)
+	_, err := rand.Read(cookie)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf(walletCookieFile, nodeID), cookie, 0600)
+	if err != nil {
+		return err
+	}
+
+	nodeWallet.Lock()
+	defer nodeWallet.Unlock()
+	nodeWallet.nodeID = nodeID
+	nodeWallet.cookie = cookie
+	return nil
+}
+
+// checkCookie - whether cookie is the one of the running node
+func checkCookie(cookie []byte) bool {
+	return nodeWallet.cookie != nil && subtle.ConstantTimeCompare(cookie, nodeWallet.cookie) == 1
+}
+
+// lockNodeWallet - zeroes the key held by the node
+func lockNodeWallet() {
+	nodeWallet.Lock()
+	defer nodeWallet.Unlock()
+
+	clearNodeWallet()
+}
+
+// expireNodeWallet - zeroes the key held by the node when the timeout of the
+// unlock numbered unlocks passes, unless the wallet was locked or unlocked
+// again since
+func expireNodeWallet(unlocks uint64) {
+	nodeWallet.Lock()
+	defer nodeWallet.Unlock()
+
+	if nodeWallet.unlocks == unlocks {
+		clearNodeWallet()
+	}
+}
+
+// clearNodeWallet - zeroes the key and stops its timeout. nodeWallet must be
+// locked
+func clearNodeWallet() {
+	if nodeWallet.timer != nil {
+		nodeWallet.timer.Stop()
+		nodeWallet.timer = nil
+	}
+	zeroBytes(nodeWallet.key)
+	nodeWallet.key = nil
+	nodeWallet.unlocks++
+}
+
+// unlockNodeWallet - derives the key of the wallets from passphrase and holds
+// it until timeout has passed
+func unlockNodeWallet(passphrase string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return errors.New("the timeout must be positive")
+	}
+
+	wallets, err := NewWallets(nodeWallet.nodeID)
+	if err != nil {
+		return err
+	}
+	err = wallets.UnlockWithPassphrase(passphrase)
+	if err != nil {
+		return err
+	}
+	key := append([]byte(nil), wallets.key...)
+	wallets.Lock()
+
+	nodeWallet.Lock()
+	defer nodeWallet.Unlock()
+
+	clearNodeWallet()
+	unlocks := nodeWallet.unlocks
+	nodeWallet.key = key
+	nodeWallet.timer = time.AfterFunc(timeout, func() { expireNodeWallet(unlocks) })
+	return nil
+}
+
+// requestNode - sends request to the local node nodeID and returns its reply
+func requestNode(nodeID string, request []byte) (*walletreply, error) {
+	conn, err := net.Dial(protocol, fmt.Sprintf("localhost:%s", nodeID))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(request)
+	if err != nil {
+		return nil, err
+	}
+	// the node reads the request up to its end before replying
+	err = conn.(*net.TCPConn).CloseWrite()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply walletreply
+	err = gob.NewDecoder(bytes.NewReader(content)).Decode(&reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+
+	return &reply, nil
+}
+
+// readCookie - the cookie of the running node nodeID
+func readCookie(nodeID string) ([]byte, error) {
+	cookie, err := ioutil.ReadFile(fmt.Sprintf(walletCookieFile, nodeID))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no node %s is running, start it with startnode", nodeID)
+	}
+
+	return cookie, err
+}
+
+// sendWalletUnlock - asks the node nodeID to hold the key of its wallets for
+// timeout
+func sendWalletUnlock(nodeID, passphrase string, timeout time.Duration) error {
+	cookie, err := readCookie(nodeID)
+	if err != nil {
+		return err
+	}
+
+	payload := gobEncode(walletunlock{cookie, passphrase, int64(timeout / time.Second)})
+	_, err = requestNode(nodeID, append(commandToBytes("walletunlock"), payload...))
+	return err
+}
+
+// sendWalletLock - asks the node nodeID to drop the key of its wallets
+func sendWalletLock(nodeID string) error {
+	cookie, err := readCookie(nodeID)
+	if err != nil {
+		return err
+	}
+
+	payload := gobEncode(walletlock{cookie})
+	_, err = requestNode(nodeID, append(commandToBytes("walletlock"), payload...))
+	return err
+}
+
+// sendWalletKey - the key the node nodeID holds for its wallets, or nil if
+// it is not running or its wallets are locked
+func sendWalletKey(nodeID string) []byte {
+	cookie, err := readCookie(nodeID)
+	if err != nil {
+		return nil
+	}
+
+	payload := gobEncode(walletkey{cookie})
+	reply, err := requestNode(nodeID, append(commandToBytes("walletkey"), payload...))
+	if err != nil {
+		return nil
+	}
+
+	return reply.Key
+}
+
+// replyWallet - writes reply to the command connected on conn
+func replyWallet(conn net.Conn, reply walletreply) {
+	_, err := conn.Write(gobEncode(reply))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func handleWalletUnlock(request []byte, conn net.Conn) {
+	var payload walletunlock
+
+	err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !checkCookie(payload.Cookie) {
+		replyWallet(conn, walletreply{Error: "wrong wallet cookie"})
+		return
+	}
+
+	err = unlockNodeWallet(payload.Passphrase, time.Duration(payload.Timeout)*time.Second)
+	if err != nil {
+		replyWallet(conn, walletreply{Error: err.Error()})
+		return
+	}
+
+	replyWallet(conn, walletreply{})
+}
+
+func handleWalletLock(request []byte, conn net.Conn) {
+	var payload walletlock
+
+	err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !checkCookie(payload.Cookie) {
+		replyWallet(conn, walletreply{Error: "wrong wallet cookie"})
+		return
+	}
+
+	lockNodeWallet()
+	replyWallet(conn, walletreply{})
+}
+
+func handleWalletKey(request []byte, conn net.Conn) {
+	var payload walletkey
+
+	err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !checkCookie(payload.Cookie) {
+		replyWallet(conn, walletreply{Error: "wrong wallet cookie"})
+		return
+	}
+
+	nodeWallet.Lock()
+	key := append([]byte(nil), nodeWallet.key...)
+	nodeWallet.Unlock()
+
+	if len(key) == 0 {
+		replyWallet(conn, walletreply{Error: ErrWalletLocked.Error()})
+		return
+	}
+	replyWallet(conn, walletreply{Key: key})
+	zeroBytes(key)
+}
@@ -2,37 +2,205 @@ package main
 
 import (
 	"bytes"
-	"crypto/elliptic"
+	"crypto/ecdsa"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
-// Wallets - stores wallets
+// Wallets - stores wallets along with the seed they are derived from, if
+// any, the addresses watched without their keys, the redeem scripts of the
+// multisig addresses and the keys of the musig addresses they take part in,
+// and the hash time-locked contracts they take part in along with the
+// secrets of those contracts that are known
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets       map[string]*Wallet
+	RedeemScripts map[string][]byte
+	// MuSigKeys - the keys behind each musig address
+	MuSigKeys map[string][][]byte
+	// MuSigNonces - the secret nonces of musig signatures begun over a PSBT,
+	// keyed by hex signature hash and public key, until they have signed.
+	// Only encrypted wallets keep any, and only sealed
+	MuSigNonces map[string][]byte
+	// Contracts - contract scripts keyed by their address
+	Contracts map[string][]byte
+	// Secrets - contract secrets keyed by their hex SHA-256. Encrypted
+	// wallets keep them only sealed
+	Secrets map[string][]byte
+	// Seed - the BIP39 seed new wallets are derived from, nil if they are
+	// random
+	Seed []byte
+	// NextIndex - the index on the account chain of the next derived wallet
+	NextIndex uint32
+	// Paths - derivation paths keyed by the address of the derived wallet
+	Paths map[string]string
+	// WatchOnly - addresses followed without their keys, along with their
+	// public keys where known
+	WatchOnly map[string][]byte
+	// Crypt - how the key sealing the wallets is derived, nil unless they
+	// are encrypted
+	Crypt *WalletCrypt
+	// Sealed - the private keys and the seed of encrypted wallets
+	Sealed []byte
+
+	// key - the key opening Sealed, nil while the wallets are locked
+	key []byte
 }
 
 // NewWallets - creates Wallets and populates existing wallets from wallet
 func NewWallets(nodeID string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.RedeemScripts = make(map[string][]byte)
+	wallets.MuSigKeys = make(map[string][][]byte)
+	wallets.MuSigNonces = make(map[string][]byte)
+	wallets.Contracts = make(map[string][]byte)
+	wallets.Secrets = make(map[string][]byte)
+	wallets.Paths = make(map[string]string)
+	wallets.WatchOnly = make(map[string][]byte)
 
 	err := wallets.LoadFromFile(nodeID)
 	return &wallets, err
 }
 
-// CreateWallet - creates a new wallet
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
+// CreateWallet - creates a new wallet, paid to its x-only key when schnorr is
+// set. It is derived from the seed when there is one, and random otherwise
+func (ws *Wallets) CreateWallet(schnorr bool) string {
+	if ws.IsLocked() {
+		log.Panic(ErrWalletLocked)
+	}
+
+	if ws.Seed == nil {
+		wallet := NewWallet()
+		wallet.Schnorr = schnorr
+		address := fmt.Sprintf("%s", wallet.GetAddress())
+
+		ws.Wallets[address] = wallet
+		return address
+	}
+
+	wallet, path := ws.nextHDWallet()
+	wallet.Schnorr = schnorr
 	address := fmt.Sprintf("%s", wallet.GetAddress())
 
 	ws.Wallets[address] = wallet
+	ws.Paths[address] = path
 	return address
 }
 
+// AddMultiSig - adds the address needing m signatures from pubKeys
+func (ws *Wallets) AddMultiSig(m int, pubKeys [][]byte) (string, error) {
+	redeemScript, err := MultiSigScript(m, pubKeys)
+	if err != nil {
+		return "", err
+	}
+
+	address := fmt.Sprintf("%s", ScriptAddress(redeemScript))
+
+	ws.RedeemScripts[address] = redeemScript
+	return address, nil
+}
+
+// AddMuSig - adds the address of the key shared by the holders of pubKeys,
+// all of whom must sign to spend from it
+func (ws *Wallets) AddMuSig(pubKeys [][]byte) (string, error) {
+	key, err := AggregateKeys(pubKeys)
+	if err != nil {
+		return "", err
+	}
+
+	address := fmt.Sprintf("%s", encodeAddress(schnorrVersion, key.XOnly()))
+
+	ws.MuSigKeys[address] = key.PubKeys
+	return address, nil
+}
+
+// AddWatchAddress - follows an address without holding its keys
+func (ws *Wallets) AddWatchAddress(address string) error {
+	if !ValidateAddress(address) {
+		return fmt.Errorf("%s is not a valid address", address)
+	}
+
+	if _, ok := ws.WatchOnly[address]; !ok {
+		ws.WatchOnly[address] = nil
+	}
+	return nil
+}
+
+// AddWatchPubKey - follows the address of a public key, paid to its x-only
+// key when schnorr is set, without holding its private key
+func (ws *Wallets) AddWatchPubKey(pubKey []byte, schnorr bool) (string, error) {
+	pub, err := parsePubKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+
+	if schnorr && len(pubKey) != secp256k1.PubKeyBytesLenCompressed {
+		return "", errors.New("schnorr keys must be on secp256k1")
+	}
+
+	wallet := Wallet{PrivateKey: ecdsa.PrivateKey{PublicKey: *pub}, PublicKey: pubKey, Schnorr: schnorr}
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+
+	ws.WatchOnly[address] = pubKey
+	return address, nil
+}
+
+// IsWatchOnly - whether address is followed without its keys
+func (ws *Wallets) IsWatchOnly(address string) bool {
+	_, ok := ws.WatchOnly[address]
+	return ok
+}
+
+// AddContract - keeps track of a hash time-locked contract, returning its
+// address
+func (ws *Wallets) AddContract(contract []byte) string {
+	address := fmt.Sprintf("%s", ScriptAddress(contract))
+
+	ws.Contracts[address] = contract
+	return address
+}
+
+// AddSecret - remembers the secret of a contract
+func (ws *Wallets) AddSecret(secret []byte) {
+	secretHash := sha256.Sum256(secret)
+
+	ws.Secrets[hex.EncodeToString(secretHash[:])] = secret
+}
+
+// GetSecret - returns the known secret hashing to secretHash, or nil
+func (ws *Wallets) GetSecret(secretHash []byte) []byte {
+	return ws.Secrets[hex.EncodeToString(secretHash)]
+}
+
+// GetContract - returns the contract at an address tracked here, or else
+// decodes contract as a hex script
+func (ws *Wallets) GetContract(contract string) []byte {
+	if script, ok := ws.Contracts[contract]; ok {
+		return script
+	}
+
+	script, err := hex.DecodeString(contract)
+	if err != nil {
+		log.Panicf("Err : %s is neither a contract of the wallet nor a hex script", contract)
+	}
+
+	return script
+}
+
+// GetWalletByKeyHash - returns the wallet whose public key hashes to
+// pubKeyHash, or nil
+func (ws *Wallets) GetWalletByKeyHash(pubKeyHash []byte) *Wallet {
+	return ws.Wallets[fmt.Sprintf("%s", encodeAddress(version, pubKeyHash))]
+}
+
 // GetAddresses - returns the addresses from the wallet
 func (ws *Wallets) GetAddresses() []string {
 	var addresses []string
@@ -41,6 +209,18 @@ func (ws *Wallets) GetAddresses() []string {
 		addresses = append(addresses, address)
 	}
 
+	for address := range ws.RedeemScripts {
+		addresses = append(addresses, address)
+	}
+
+	for address := range ws.MuSigKeys {
+		addresses = append(addresses, address)
+	}
+
+	for address := range ws.WatchOnly {
+		addresses = append(addresses, address)
+	}
+
 	return addresses
 }
 
@@ -49,42 +229,127 @@ func (ws *Wallets) GetWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
+// GetMultiSigKeys - returns the private keys held here for the keys of a
+// multisig redeem script, in the order of the script
+func (ws *Wallets) GetMultiSigKeys(redeemScript []byte) []ecdsa.PrivateKey {
+	var privKeys []ecdsa.PrivateKey
+
+	_, pubKeys, err := ExtractMultiSig(redeemScript)
+	if err != nil {
+		return nil
+	}
+
+	for _, pubKey := range pubKeys {
+		for _, wallet := range ws.Wallets {
+			if bytes.Equal(wallet.PublicKey, pubKey) {
+				privKeys = append(privKeys, wallet.PrivateKey)
+				break
+			}
+		}
+	}
+
+	return privKeys
+}
+
+// GetMuSigKeys - returns the private keys held here for pubKeys, in order,
+// or nil unless every one of them is held
+func (ws *Wallets) GetMuSigKeys(pubKeys [][]byte) []ecdsa.PrivateKey {
+	var privKeys []ecdsa.PrivateKey
+
+	for _, pubKey := range pubKeys {
+		found := false
+		for _, wallet := range ws.Wallets {
+			if bytes.Equal(wallet.PublicKey, pubKey) {
+				privKeys = append(privKeys, wallet.PrivateKey)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	return privKeys
+}
+
 // LoadFromFile - load existing wallets from wallet
 func (ws *Wallets) LoadFromFile(nodeID string) error {
 	if _, err := os.Stat(fmt.Sprintf(walletFile, nodeID)); os.IsNotExist(err) {
 		return err
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := ioutil.ReadFile(fmt.Sprintf(walletFile, nodeID))
 	if err != nil {
 		return err
 	}
 
 	var wallets Wallets
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	ws.Wallets = wallets.Wallets
+	if wallets.Wallets != nil {
+		ws.Wallets = wallets.Wallets
+	}
+	if wallets.RedeemScripts != nil {
+		ws.RedeemScripts = wallets.RedeemScripts
+	}
+	if wallets.MuSigKeys != nil {
+		ws.MuSigKeys = wallets.MuSigKeys
+	}
+	if wallets.MuSigNonces != nil {
+		ws.MuSigNonces = wallets.MuSigNonces
+	}
+	if wallets.Contracts != nil {
+		ws.Contracts = wallets.Contracts
+	}
+	if wallets.Secrets != nil {
+		ws.Secrets = wallets.Secrets
+	}
+	if wallets.Paths != nil {
+		ws.Paths = wallets.Paths
+	}
+	if wallets.WatchOnly != nil {
+		ws.WatchOnly = wallets.WatchOnly
+	}
+	ws.Seed = wallets.Seed
+	ws.NextIndex = wallets.NextIndex
+	ws.Crypt = wallets.Crypt
+	ws.Sealed = wallets.Sealed
+
 	return nil
 }
 
-// SaveToFile - stores the wallets into the wallet
+// SaveToFile - stores the wallets into the wallet, sealing the private keys
+// and the seed if they are encrypted. The file can only be read by its owner
 func (ws *Wallets) SaveToFile(nodeID string) {
 	var content bytes.Buffer
 
-	gob.Register(elliptic.P256())
+	stored := ws
+	if ws.IsEncrypted() && !ws.IsLocked() {
+		var err error
+		stored, err = ws.sealed()
+		if err != nil {
+			log.Panic(err)
+		}
+	}
 
 	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	err := encoder.Encode(stored)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf(walletFile, nodeID), content.Bytes(), 0600)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = ioutil.WriteFile(fmt.Sprintf(walletFile, nodeID), content.Bytes(), 0644)
+	// files written before encryption keep their mode otherwise
+	err = os.Chmod(fmt.Sprintf(walletFile, nodeID), 0600)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+const (
+	// wifVersion - version byte of private keys in wallet import format
+	wifVersion = byte(0x80)
+	// wifCompressed - follows the key of a secp256k1 wallet, whose public key
+	// is compressed, as in Bitcoin
+	wifCompressed = byte(0x01)
+	// wifP256 - follows the key of a P-256 wallet from before secp256k1
+	wifP256 = byte(0x02)
+)
+
+// EncodeWIF - the private key in wallet import format: the version, the key,
+// a byte telling its curve and a checksum, in Base58
+func EncodeWIF(privKey ecdsa.PrivateKey) string {
+	suffix := wifCompressed
+	if privKey.Curve != secp256k1.S256() {
+		suffix = wifP256
+	}
+
+	payload := make([]byte, 1+curveSize(privKey.Curve))
+	payload[0] = wifVersion
+	privKey.D.FillBytes(payload[1:])
+	payload = append(payload, suffix)
+
+	return fmt.Sprintf("%s", Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// DecodeWIF - the private key of a key in wallet import format
+func DecodeWIF(wif string) (*ecdsa.PrivateKey, error) {
+	for i := range wif {
+		if bytes.IndexByte(b58Alphabet, wif[i]) < 0 {
+			return nil, errors.New("key is not Base58")
+		}
+	}
+
+	decoded := Base58Decode([]byte(wif))
+	if len(decoded) != 1+32+1+addressChecksumLen {
+		return nil, fmt.Errorf("key of %d bytes is not a private key", len(decoded))
+	}
+
+	payload := decoded[:len(decoded)-addressChecksumLen]
+	if !bytes.Equal(checksum(payload), decoded[len(decoded)-addressChecksumLen:]) {
+		return nil, errors.New("key checksum does not match")
+	}
+	if payload[0] != wifVersion {
+		return nil, fmt.Errorf("key version %d is not a private key", payload[0])
+	}
+
+	var curve elliptic.Curve
+	switch payload[len(payload)-1] {
+	case wifCompressed:
+		curve = secp256k1.S256()
+	case wifP256:
+		curve = elliptic.P256()
+	default:
+		return nil, errors.New("key is on an unknown curve")
+	}
+
+	d := new(big.Int).SetBytes(payload[1 : len(payload)-1])
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("key is out of range")
+	}
+
+	privKey := ecdsa.PrivateKey{D: d}
+	privKey.PublicKey.Curve = curve
+	privKey.PublicKey.X, privKey.PublicKey.Y = curve.ScalarBaseMult(payload[1 : len(payload)-1])
+
+	return &privKey, nil
+}
+
+// ImportKey - adds a wallet holding privKey, paid to its x-only key when
+// schnorr is set
+func (ws *Wallets) ImportKey(privKey ecdsa.PrivateKey, schnorr bool) (string, error) {
+	if ws.IsLocked() {
+		return "", ErrWalletLocked
+	}
+	if schnorr && privKey.Curve != secp256k1.S256() {
+		return "", errors.New("schnorr keys must be on secp256k1")
+	}
+
+	wallet := Wallet{
+		PrivateKey: privKey,
+		PublicKey:  pubKeyBytes(&privKey.PublicKey),
+		Schnorr:    schnorr,
+	}
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+
+	ws.Wallets[address] = &wallet
+	delete(ws.WatchOnly, address)
+	return address, nil
+}
+
+// DumpWallet - writes every private key of the wallets in wallet import
+// format, one per line with its address, then "schnorr" for wallets paid to
+// their x-only key and the derivation path for those derived from the seed.
+// The seed and the next index to derive at go in a comment line first
+func (ws *Wallets) DumpWallet(w io.Writer) error {
+	if ws.IsLocked() {
+		return ErrWalletLocked
+	}
+
+	fmt.Fprintf(w, "# mblah wallet dump\n")
+	fmt.Fprintf(w, "# created %s\n", time.Now().Format(time.RFC3339))
+	if ws.Seed != nil {
+		fmt.Fprintf(w, "# hdseed %x nextindex %d\n", ws.Seed, ws.NextIndex)
+	}
+
+	for address, wallet := range ws.Wallets {
+		line := fmt.Sprintf("%s %s", EncodeWIF(wallet.PrivateKey), address)
+		if wallet.Schnorr {
+			line += " schnorr"
+		}
+		if path, ok := ws.Paths[address]; ok {
+			line += " hdpath=" + path
+		}
+
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportWallet - adds the keys of a dump made by DumpWallet, and its seed if
+// the wallets have none yet. Returns the addresses imported
+func (ws *Wallets) ImportWallet(r io.Reader) ([]string, error) {
+	if ws.IsLocked() {
+		return nil, ErrWalletLocked
+	}
+
+	var addresses []string
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "#" {
+			if len(fields) == 5 && fields[1] == "hdseed" && ws.Seed == nil {
+				seed, err := hex.DecodeString(fields[2])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				next, err := strconv.ParseUint(fields[4], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				ws.Seed = seed
+				ws.NextIndex = uint32(next)
+			}
+			continue
+		}
+
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: no address after the key", line)
+		}
+
+		privKey, err := DecodeWIF(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		schnorr := false
+		path := ""
+		for _, field := range fields[2:] {
+			if field == "schnorr" {
+				schnorr = true
+			} else if strings.HasPrefix(field, "hdpath=") {
+				path = strings.TrimPrefix(field, "hdpath=")
+			}
+		}
+
+		address, err := ws.ImportKey(*privKey, schnorr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if address != fields[1] {
+			return nil, fmt.Errorf("line %d: key is not the key of %s", line, fields[1])
+		}
+		if path != "" {
+			ws.Paths[address] = path
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses, scanner.Err()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// wifOf - a key in wallet import format made of payload and its checksum
+func wifOf(payload ...[]byte) string {
+	data := bytes.Join(payload, nil)
+	return string(Base58Encode(append(data, checksum(data)...)))
+}
+
+func TestWIFRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve elliptic.Curve
+		key   string
+	}{
+		{"secp256k1", secp256k1.S256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"},
+		{"secp256k1 leading zeros", secp256k1.S256(), "0000000000000000000000000000000000000000000000000000000000000abc"},
+		{"p256", elliptic.P256(), "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"},
+		{"p256 leading zeros", elliptic.P256(), "00000000000000000000000000000000000000000000000000000000000000ff"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			privKey := testPrivKey(t, test.curve, test.key)
+
+			decoded, err := DecodeWIF(EncodeWIF(privKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Curve != test.curve || decoded.D.Cmp(privKey.D) != 0 ||
+				decoded.X.Cmp(privKey.X) != 0 || decoded.Y.Cmp(privKey.Y) != 0 {
+				t.Fatal("key changed in wallet import format")
+			}
+		})
+	}
+}
+
+func TestEncodeWIFBitcoin(t *testing.T) {
+	// compressed secp256k1 keys are encoded as in Bitcoin
+	privKey := testPrivKey(t, secp256k1.S256(), "1")
+	if wif := EncodeWIF(privKey); wif != "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn" {
+		t.Fatalf("key 1 encodes as %s", wif)
+	}
+}
+
+func TestDecodeWIFErrors(t *testing.T) {
+	key := mustHex(t, "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721")
+	valid := wifOf([]byte{wifVersion}, key, []byte{wifCompressed})
+
+	tests := []struct {
+		name string
+		wif  string
+	}{
+		{"not base58", valid[:10] + "0" + valid[11:]},
+		{"bad checksum", string(Base58Encode(bytes.Join([][]byte{{wifVersion}, key, {wifCompressed}, make([]byte, addressChecksumLen)}, nil)))},
+		{"address version", wifOf([]byte{version}, key, []byte{wifCompressed})},
+		{"unknown curve", wifOf([]byte{wifVersion}, key, []byte{0x03})},
+		{"no curve", wifOf([]byte{wifVersion}, key)},
+		{"short key", wifOf([]byte{wifVersion}, key[1:], []byte{wifCompressed})},
+		{"zero key", wifOf([]byte{wifVersion}, make([]byte, 32), []byte{wifCompressed})},
+		{"key of the group order", wifOf([]byte{wifVersion}, secp256k1.S256().N.Bytes(), []byte{wifCompressed})},
+		{"empty", ""},
+	}
+
+	if _, err := DecodeWIF(valid); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodeWIF(test.wif); err == nil {
+				t.Fatal("key decoded")
+			}
+		})
+	}
+}
+
+func TestDumpImportWallet(t *testing.T) {
+	wallets := Wallets{Wallets: make(map[string]*Wallet), Paths: make(map[string]string)}
+	plain := NewWallet()
+	schnorr := NewWallet()
+	schnorr.Schnorr = true
+	for _, wallet := range []*Wallet{plain, schnorr} {
+		wallets.Wallets[string(wallet.GetAddress())] = wallet
+	}
+	wallets.Paths[string(plain.GetAddress())] = "m/0'/0'/3'"
+
+	var dump bytes.Buffer
+	err := wallets.DumpWallet(&dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := Wallets{Wallets: make(map[string]*Wallet), Paths: make(map[string]string)}
+	addresses, err := imported.ImportWallet(strings.NewReader(dump.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 {
+		t.Fatalf("imported %d wallets, want 2", len(addresses))
+	}
+	for address, wallet := range wallets.Wallets {
+		got := imported.Wallets[address]
+		if got == nil || got.PrivateKey.D.Cmp(wallet.PrivateKey.D) != 0 || got.Schnorr != wallet.Schnorr {
+			t.Fatalf("wallet %s not imported as dumped", address)
+		}
+	}
+	if imported.Paths[string(plain.GetAddress())] != "m/0'/0'/3'" {
+		t.Fatal("derivation path not imported")
+	}
+
+	// a key listed under an address it does not pay to is refused
+	other := string(NewWallet().GetAddress())
+	forged := EncodeWIF(plain.PrivateKey) + " " + other + "\n"
+	if _, err := imported.ImportWallet(strings.NewReader(forged)); err == nil {
+		t.Fatal("key imported under another address")
+	}
+}